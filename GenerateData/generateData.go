@@ -11,6 +11,8 @@ import (
 	"strings"
 	"time"
 
+	"github.com/ClaudiaYao/CapstoneSubscriptionService/app/domain"
+	"github.com/ClaudiaYao/CapstoneSubscriptionService/app/recurrence"
 	"github.com/lithammer/shortuuid"
 )
 
@@ -143,14 +145,14 @@ func extractIDs() (allIDInfo, error) {
 }
 
 func generateDishDeliveryRecords(subscriptionDishID string, ExpectedTime time.Time) {
-	write_f, err := os.OpenFile("Generated/DishesDelivery.txt", os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	write_f, err := os.OpenFile("Generated/dishesDelivery.txt", os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
 	if err != nil {
 		log.Fatal(err)
 	}
 	// remember to close the file at the end of the program
 	defer write_f.Close()
 
-	statusChoice := []string{"Completed", "Cancelled", "Pending"}
+	statusChoice := []domain.DeliveryStatus{domain.DeliveryDelivered, domain.DeliveryCancelled, domain.DeliveryScheduled}
 	id := "DD" + shortuuid.New()
 	status := statusChoice[rand.Intn(len(statusChoice))]
 	deliveryTime := ExpectedTime.Add(time.Minute * time.Duration(rand.Intn(500)))
@@ -159,12 +161,12 @@ func generateDishDeliveryRecords(subscriptionDishID string, ExpectedTime time.Ti
 	new_text := ""
 
 	// Parse the data record
-	if status == "Completed" {
-		new_text = id + "|" + subscriptionDishID + "|" + status + "|" +
+	if status == domain.DeliveryDelivered {
+		new_text = id + "|" + subscriptionDishID + "|" + string(status) + "|" +
 			ExpectedTime.Format(time.RFC3339) + "|" +
 			deliveryTime.Format(time.RFC3339) + "|" + note
 	} else {
-		new_text = id + "|" + subscriptionDishID + "|" + status + "|" +
+		new_text = id + "|" + subscriptionDishID + "|" + string(status) + "|" +
 			ExpectedTime.Format(time.RFC3339) + "|" +
 			"\\N" + "|" + "not deliver yet"
 	}
@@ -175,18 +177,6 @@ func generateDishDeliveryRecords(subscriptionDishID string, ExpectedTime time.Ti
 
 }
 
-func nextDelivery(frequency string, thisDelivery time.Time) time.Time {
-	if frequency == "daily" {
-		return thisDelivery.AddDate(0, 0, 1)
-	} else if frequency == "weekly" {
-		return thisDelivery.AddDate(0, 0, 7)
-
-	} else if frequency == "monthly" {
-		return thisDelivery.AddDate(0, 1, 0)
-	}
-	return thisDelivery.AddDate(0, 0, 1)
-}
-
 func generateSubscriptionDishes(subscriptionID string, startDate time.Time, endDate time.Time, frequency string, dishIDs []string) []string {
 	write_f, err := os.OpenFile("Generated/subscriptionDishes.txt", os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
 	if err != nil {
@@ -232,10 +222,12 @@ func generateSubscriptionDishes(subscriptionID string, startDate time.Time, endD
 			scheduleTime.Format(time.RFC3339) +
 			"|" + frequency + "|" + string(optionsB) + "|" + note
 
-		nextTime := scheduleTime
-		for nextTime.Before(endDate) {
+		rule, err := recurrence.Parse(frequency)
+		if err != nil {
+			log.Fatal(err)
+		}
+		for _, nextTime := range rule.Between(scheduleTime, scheduleTime, endDate) {
 			generateDishDeliveryRecords(id, nextTime)
-			nextTime = nextDelivery(frequency, nextTime)
 		}
 
 		_, err = write_f.WriteString(new_text + "\n")
@@ -281,9 +273,10 @@ func generateSubscription(playlistIDs []string, playlistDishRelations map[string
 	}
 
 	frequencyChoices := []string{
-		"Daily",
-		"Weekly",
-		"Monthly",
+		"FREQ=DAILY",
+		"FREQ=WEEKLY",
+		"FREQ=WEEKLY;BYDAY=MO,WE,FR",
+		"FREQ=MONTHLY",
 	}
 
 	customizedChoice := []string{
@@ -42,8 +42,8 @@ func TestGenerateNewSubscriptionDTO(t *testing.T) {
 	}
 
 	frequencyChoices := []string{
-		"Daily",
-		"Weekly",
+		"FREQ=DAILY",
+		"FREQ=WEEKLY;BYDAY=MO,WE,FR",
 	}
 
 	customizedChoice := []bool{
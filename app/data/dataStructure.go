@@ -1,6 +1,7 @@
 package data
 
 import (
+	"encoding/json"
 	"errors"
 	"time"
 )
@@ -15,16 +16,21 @@ type DishDelivery struct {
 }
 
 type Subscription struct {
-	ID              string    `json:"id"`
-	UserID          string    `json:"userID"`
-	PlaylistID      string    `json:"playlistID,omitempty"`
-	Customized      bool      `json:"customized"`
-	Status          string    `json:"status"`
-	Frequency       string    `json:"frequency"`
-	StartDate       time.Time `json:"startDate"`
-	EndDate         time.Time `json:"endDate,omitempty"`
-	ReceiverName    string    `json:"receiverName"`
-	ReceiverContact string    `json:"receiverContact"`
+	ID                   string    `json:"id"`
+	UserID               string    `json:"userID"`
+	PlaylistID           string    `json:"playlistID,omitempty"`
+	Customized           bool      `json:"customized"`
+	Status               string    `json:"status"`
+	Frequency            string    `json:"frequency"`
+	StartDate            time.Time `json:"startDate"`
+	EndDate              time.Time `json:"endDate,omitempty"`
+	ReceiverName         string    `json:"receiverName"`
+	ReceiverContact      string    `json:"receiverContact"`
+	AutoRenew            bool      `json:"autoRenew"`
+	TermDays             int       `json:"termDays,omitempty"`
+	DeliveryInstructions string    `json:"deliveryInstructions,omitempty"`
+	TimeZone             string    `json:"timeZone"`
+	PostalCode           string    `json:"postalCode,omitempty"`
 }
 
 type SubscriptionDish struct {
@@ -35,6 +41,8 @@ type SubscriptionDish struct {
 	Frequency      string    `json:"frequency"`
 	DishOptions    string    `json:"dishOptions,omitempty"`
 	Note           string    `json:"note,omitempty"`
+	RemovedAt      time.Time `json:"removedAt,omitempty"`
+	SlotID         string    `json:"slotID,omitempty"`
 }
 
 type SubscriptionDishDTO struct {
@@ -45,6 +53,193 @@ type SubscriptionDishDTO struct {
 	Frequency      string     `json:"frequency"`
 	DishOptions    [][]string `json:"dishOptions,omitempty"`
 	Note           string     `json:"note,omitempty"`
+	SlotID         string     `json:"slotID,omitempty"`
+}
+
+// StatusTransition records one change of a subscription's status,
+// including who triggered it and when.
+type StatusTransition struct {
+	ID             string    `json:"id"`
+	SubscriptionID string    `json:"subscriptionID"`
+	FromStatus     string    `json:"fromStatus"`
+	ToStatus       string    `json:"toStatus"`
+	TriggeredBy    string    `json:"triggeredBy"`
+	TriggeredAt    time.Time `json:"triggeredAt"`
+}
+
+// SubscriptionPause is one holiday pause of a subscription. PauseUntil is zero when
+// the pause is open ended, ResumedAt is zero while the subscription is still paused.
+type SubscriptionPause struct {
+	ID             string    `json:"id"`
+	SubscriptionID string    `json:"subscriptionID"`
+	PauseFrom      time.Time `json:"pauseFrom"`
+	PauseUntil     time.Time `json:"pauseUntil,omitempty"`
+	ResumedAt      time.Time `json:"resumedAt,omitempty"`
+	ResumePolicy   string    `json:"resumePolicy,omitempty"`
+}
+
+// DeliveryWindowUpdate moves every delivery of a subscription that is in FromStatus and
+// expected within [From, Until) to ToStatus. A zero Until means there is no upper bound.
+type DeliveryWindowUpdate struct {
+	SubscriptionID string
+	FromStatus     string
+	ToStatus       string
+	From           time.Time
+	Until          time.Time
+}
+
+// ReceiverDetails is who receives a delivery and how it should be handed over.
+type ReceiverDetails struct {
+	ReceiverName         string `json:"receiverName"`
+	ReceiverContact      string `json:"receiverContact"`
+	DeliveryInstructions string `json:"deliveryInstructions,omitempty"`
+}
+
+// ReceiverHistory keeps receiver details of a subscription which were replaced. They still
+// apply to the deliveries expected before ValidUntil.
+type ReceiverHistory struct {
+	ID                   string    `json:"id"`
+	SubscriptionID       string    `json:"subscriptionID"`
+	ReceiverName         string    `json:"receiverName"`
+	ReceiverContact      string    `json:"receiverContact"`
+	DeliveryInstructions string    `json:"deliveryInstructions,omitempty"`
+	ValidUntil           time.Time `json:"validUntil"`
+	ChangedBy            string    `json:"changedBy"`
+	ChangedAt            time.Time `json:"changedAt"`
+}
+
+// SubscriptionCancellation records why and by whom a subscription was cancelled.
+// The cancellation can be undone until UndoUntil; UndoneAt is zero while it stands.
+type SubscriptionCancellation struct {
+	ID             string    `json:"id"`
+	SubscriptionID string    `json:"subscriptionID"`
+	ReasonCode     string    `json:"reasonCode,omitempty"`
+	Comment        string    `json:"comment,omitempty"`
+	PreviousStatus string    `json:"previousStatus"`
+	CancelledBy    string    `json:"cancelledBy"`
+	CancelledAt    time.Time `json:"cancelledAt"`
+	UndoUntil      time.Time `json:"undoUntil"`
+	UndoneAt       time.Time `json:"undoneAt,omitempty"`
+}
+
+// CancelledDelivery remembers the status a delivery had before a cancellation, so the
+// cancellation can be undone.
+type CancelledDelivery struct {
+	CancellationID string `json:"cancellationID"`
+	DishDeliveryID string `json:"dishDeliveryID"`
+	PreviousStatus string `json:"previousStatus"`
+}
+
+// DeliveryInRange is a delivery together with the dish and subscription it belongs to, as
+// read for the delivery calendars of users, subscriptions and restaurants.
+type DeliveryInRange struct {
+	DishDelivery
+	DishID         string `json:"dishID"`
+	SubscriptionID string `json:"subscriptionID"`
+	UserID         string `json:"userID"`
+	TimeZone       string `json:"-"`
+	// Sequence counts the changes of the delivery's time, status and note
+	Sequence int `json:"-"`
+}
+
+// The orders a listing of subscriptions can be sorted in. Subscriptions with the same
+// start date, or status and start date, are ordered by id.
+const (
+	SortByStartDate = "start_date"
+	SortByStatus    = "status"
+)
+
+// SubscriptionKey is the position of a subscription in a listing, the values it is sorted by.
+type SubscriptionKey struct {
+	StartDate time.Time `json:"startDate"`
+	Status    string    `json:"status,omitempty"`
+	ID        string    `json:"id"`
+}
+
+// SubscriptionListQuery selects one page of the subscriptions of a user. Filters left empty
+// select every subscription. From and To select the subscriptions running on some day
+// between them, both included.
+//
+// The page holds the Limit subscriptions right after After in the sort order or, when
+// Before is set, the ones right before Before.
+type SubscriptionListQuery struct {
+	UserID     string
+	Statuses   []string
+	From       time.Time
+	To         time.Time
+	PlaylistID string
+	Customized *bool
+	SortBy     string
+	Descending bool
+	After      *SubscriptionKey
+	Before     *SubscriptionKey
+	Limit      int
+}
+
+// Blackout blocks deliveries from StartDate to EndDate, both included. Global blackouts
+// have an empty ScopeID, restaurant and user blackouts the id of the restaurant or user.
+// SourceUID is the UID of the calendar event a blackout was imported from.
+type Blackout struct {
+	ID        string    `json:"id"`
+	Scope     string    `json:"scope"`
+	ScopeID   string    `json:"scopeID,omitempty"`
+	StartDate time.Time `json:"startDate"`
+	EndDate   time.Time `json:"endDate"`
+	Action    string    `json:"action"`
+	Summary   string    `json:"summary,omitempty"`
+	SourceUID string    `json:"sourceUID,omitempty"`
+	CreatedBy string    `json:"createdBy"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// DeliverySlot is a delivery window of the slot catalogue, e.g. 11:00 to 12:00 in the
+// time zone of each subscription. Capacity is the number of deliveries the slot takes per
+// postal zone and day.
+type DeliverySlot struct {
+	ID        string `json:"id"`
+	StartTime string `json:"startTime"`
+	EndTime   string `json:"endTime"`
+	Capacity  int    `json:"capacity"`
+	Active    bool   `json:"active"`
+}
+
+// SlotReservation counts the deliveries taken in a slot for one postal zone and day.
+type SlotReservation struct {
+	SlotID       string    `json:"slotID"`
+	PostalZone   string    `json:"postalZone"`
+	DeliveryDate time.Time `json:"deliveryDate"`
+	Reserved     int       `json:"reserved"`
+}
+
+// AuditEntry records one change of a subscription or of its dishes and deliveries: who
+// made it, in which request, and the values before and after it. OldValue is empty for
+// records which were created and NewValue for records which were deleted.
+type AuditEntry struct {
+	ID             string          `json:"id"`
+	SubscriptionID string          `json:"subscriptionID"`
+	Entity         string          `json:"entity"`
+	EntityID       string          `json:"entityID"`
+	Action         string          `json:"action"`
+	Actor          string          `json:"actor"`
+	OldValue       json.RawMessage `json:"oldValue,omitempty"`
+	NewValue       json.RawMessage `json:"newValue,omitempty"`
+	RequestID      string          `json:"requestID,omitempty"`
+	CreatedAt      time.Time       `json:"createdAt"`
+}
+
+// SlotFullError is returned when a slot has no room left for a reservation.
+type SlotFullError struct {
+	SlotID       string
+	PostalZone   string
+	DeliveryDate time.Time
+}
+
+func (e *SlotFullError) Error() string {
+	return "delivery slot " + e.SlotID + " is full for zone " + e.PostalZone + " on " + e.DeliveryDate.Format("2006-01-02")
+}
+
+func (e *SlotFullError) Is(target error) bool {
+	return target == ErrSlotFull
 }
 
 type MailPayload struct {
@@ -64,4 +259,7 @@ var (
 	ErrNotExist     = errors.New("row does not exist")
 	ErrUpdateFailed = errors.New("update failed")
 	ErrDeleteFailed = errors.New("delete failed")
+	ErrSlotFull     = errors.New("delivery slot is full")
+	// ErrInTransaction is returned by queries which need a connection of their own
+	ErrInTransaction = errors.New("not allowed inside a transaction")
 )
@@ -0,0 +1,86 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgconn"
+)
+
+// DBTX is what the queries run on, the connection pool or one of its transactions.
+type DBTX interface {
+	ExecContext(context.Context, string, ...any) (sql.Result, error)
+	PrepareContext(context.Context, string) (*sql.Stmt, error)
+	QueryContext(context.Context, string, ...any) (*sql.Rows, error)
+	QueryRowContext(context.Context, string, ...any) *sql.Row
+}
+
+func New(db DBTX) *DataQuery {
+	return &DataQuery{DBConn: db}
+}
+
+// WithTx returns the queries running in the transaction tx.
+func (dq *DataQuery) WithTx(tx *sql.Tx) *DataQuery {
+	return &DataQuery{DBConn: tx, sqlite: dq.sqlite}
+}
+
+// conn is what the queries run on. The queries are written for Postgres and rewritten as
+// they run when the database is SQLite.
+func (dq *DataQuery) conn() DBTX {
+	if dq.sqlite != nil {
+		return sqliteConn{dq.DBConn}
+	}
+	return dq.DBConn
+}
+
+// ExecTx runs fn with queries in one transaction, which is committed when fn returns nil
+// and rolled back otherwise. When dq already runs in a transaction fn joins it, so a
+// write made of other transactional writes commits or rolls back as a whole.
+func (dq *DataQuery) ExecTx(ctx context.Context, fn func(SubscriptionRepository) error) error {
+	return dq.execTx(ctx, func(q *DataQuery) error {
+		return fn(q)
+	})
+}
+
+func (dq *DataQuery) execTx(ctx context.Context, fn func(*DataQuery) error) error {
+	db, ok := dq.DBConn.(*sql.DB)
+	if !ok {
+		return fn(dq)
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	// C: rolling back after the commit does nothing
+	defer tx.Rollback()
+
+	if err := fn(dq.WithTx(tx)); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// C: error codes of https://www.postgresql.org/docs/current/errcodes-appendix.html
+const (
+	foreignKeyViolation = "23503"
+	uniqueViolation     = "23505"
+)
+
+// constraintError reports a row which breaks a primary key or unique index as ErrDuplicate,
+// and a row referring to a row which does not exist as ErrNotExist.
+func constraintError(err error) error {
+	var pgErr *pgconn.PgError
+	if !errors.As(err, &pgErr) {
+		return sqliteConstraintError(err)
+	}
+	switch pgErr.Code {
+	case uniqueViolation:
+		return fmt.Errorf("%w: %s", ErrDuplicate, pgErr.Detail)
+	case foreignKeyViolation:
+		return fmt.Errorf("%w: %s", ErrNotExist, pgErr.Detail)
+	}
+	return err
+}
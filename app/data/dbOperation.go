@@ -3,21 +3,29 @@ package data
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
 )
 
 // const dbTimeout = time.Second * 3
 
 // C: Although DataService struct only contains one *sql.DB, using this struct
-// C: Could allow to create own service
+// C: Could allow to create own service. DBConn is a *sql.Tx for the queries of a transaction
 type DataQuery struct {
-	DBConn *sql.DB
+	DBConn DBTX
+	// C: sqlite is set when DBConn is a SQLite database, see sqlite.go
+	sqlite *sqliteDB
 }
 
 const getDisDeliveryCondition = `select id, subscription_dish_id, status, expected_time, delivery_time, 
-note FROM dish_delivery where subscription_dish_id = $1`
+note FROM dish_delivery where subscription_dish_id = $1 order by expected_time`
 
 func (dq *DataQuery) GetDishDeliveryCondition(ctx context.Context, subscriptionDishID string) ([]DishDelivery, error) {
-	rows, err := dq.DBConn.QueryContext(ctx, getDisDeliveryCondition, subscriptionDishID)
+	rows, err := dq.conn().QueryContext(ctx, getDisDeliveryCondition, subscriptionDishID)
 	if err != nil {
 		return nil, err
 	}
@@ -25,16 +33,20 @@ func (dq *DataQuery) GetDishDeliveryCondition(ctx context.Context, subscriptionD
 	var items []DishDelivery
 	for rows.Next() {
 		var i DishDelivery
+		var deliveryTime sql.NullTime
+		var note sql.NullString
 		if err := rows.Scan(
 			&i.ID,
 			&i.SubscriptionDishID,
 			&i.Status,
 			&i.ExpectedTime,
-			&i.DeliveryTime,
-			&i.Note,
+			&deliveryTime,
+			&note,
 		); err != nil {
 			return nil, err
 		}
+		i.DeliveryTime = deliveryTime.Time
+		i.Note = note.String
 		items = append(items, i)
 	}
 	if err := rows.Close(); err != nil {
@@ -46,11 +58,12 @@ func (dq *DataQuery) GetDishDeliveryCondition(ctx context.Context, subscriptionD
 	return items, nil
 }
 
+// C: dishes removed from a subscription are kept for the history of their deliveries
 const getDishBySubscriptionID = `select id, dish_id, subscription_id, schedule_time, frequency, dish_options, 
-note FROM subscription_dish where subscription_id = $1`
+note, removed_at, slot_id FROM subscription_dish where subscription_id = $1 and removed_at is NULL`
 
 func (dq *DataQuery) GetDishBySubscriptionID(ctx context.Context, subscriptionID string) ([]SubscriptionDish, error) {
-	rows, err := dq.DBConn.QueryContext(ctx, getDishBySubscriptionID, subscriptionID)
+	rows, err := dq.conn().QueryContext(ctx, getDishBySubscriptionID, subscriptionID)
 	if err != nil {
 		return nil, err
 	}
@@ -58,15 +71,7 @@ func (dq *DataQuery) GetDishBySubscriptionID(ctx context.Context, subscriptionID
 	var items []SubscriptionDish
 	for rows.Next() {
 		var i SubscriptionDish
-		if err := rows.Scan(
-			&i.ID,
-			&i.DishID,
-			&i.SubscriptionID,
-			&i.ScheduleTime,
-			&i.Frequency,
-			&i.DishOptions,
-			&i.Note,
-		); err != nil {
+		if err := scanSubscriptionDish(rows, &i); err != nil {
 			return nil, err
 		}
 		items = append(items, i)
@@ -80,32 +85,106 @@ func (dq *DataQuery) GetDishBySubscriptionID(ctx context.Context, subscriptionID
 	return items, nil
 }
 
-const getSubscriptionByID = `select id, user_id, playlist_id, customized, status, frequency, 
-start_date, end_date, receiver_name, receiver_contact FROM subscription where id = $1`
+const getDishDeliveryByID = `select id, subscription_dish_id, status, expected_time, delivery_time, 
+note FROM dish_delivery where id = $1`
 
-func (dq *DataQuery) GetSubscriptionByID(ctx context.Context, id string) (Subscription, error) {
-	row := dq.DBConn.QueryRowContext(ctx, getSubscriptionByID, id)
-	var i Subscription
+func (dq *DataQuery) GetDishDeliveryByID(ctx context.Context, id string) (DishDelivery, error) {
+	row := dq.conn().QueryRowContext(ctx, getDishDeliveryByID, id)
+	var i DishDelivery
+	var deliveryTime sql.NullTime
+	var note sql.NullString
 	err := row.Scan(
 		&i.ID,
-		&i.UserID,
-		&i.PlaylistID,
-		&i.Customized,
+		&i.SubscriptionDishID,
 		&i.Status,
-		&i.Frequency,
-		&i.StartDate,
-		&i.EndDate,
-		&i.ReceiverName,
-		&i.ReceiverContact,
+		&i.ExpectedTime,
+		&deliveryTime,
+		&note,
 	)
+	if errors.Is(err, sql.ErrNoRows) {
+		return i, ErrNotExist
+	}
+	i.DeliveryTime = deliveryTime.Time
+	i.Note = note.String
+	return i, err
+}
+
+// C: loads the dishes of a page of subscriptions at once rather than one query per subscription
+const getDishesBySubscriptionIDs = `select id, dish_id, subscription_id, schedule_time, frequency, dish_options, 
+note, removed_at, slot_id FROM subscription_dish where subscription_id = any($1) and removed_at is NULL
+order by subscription_id, schedule_time, id`
+
+func (dq *DataQuery) GetDishesBySubscriptionIDs(ctx context.Context, subscriptionIDs []string) ([]SubscriptionDish, error) {
+	if len(subscriptionIDs) == 0 {
+		return nil, nil
+	}
+	rows, err := dq.conn().QueryContext(ctx, getDishesBySubscriptionIDs, subscriptionIDs)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []SubscriptionDish
+	for rows.Next() {
+		var i SubscriptionDish
+		if err := scanSubscriptionDish(rows, &i); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getSubscriptionDishByID = `select id, dish_id, subscription_id, schedule_time, frequency, dish_options, 
+note, removed_at, slot_id FROM subscription_dish where id = $1`
+
+func (dq *DataQuery) GetSubscriptionDishByID(ctx context.Context, id string) (SubscriptionDish, error) {
+	row := dq.conn().QueryRowContext(ctx, getSubscriptionDishByID, id)
+	var i SubscriptionDish
+	err := scanSubscriptionDish(row, &i)
+	if errors.Is(err, sql.ErrNoRows) {
+		return i, ErrNotExist
+	}
+	return i, err
+}
+
+const getDishTimeZone = `select s.time_zone FROM subscription_dish sd
+join subscription s on s.id = sd.subscription_id where sd.id = $1`
+
+func (dq *DataQuery) GetDishTimeZone(ctx context.Context, subscriptionDishID string) (string, error) {
+	var timeZone string
+	err := dq.conn().QueryRowContext(ctx, getDishTimeZone, subscriptionDishID).Scan(&timeZone)
+	if errors.Is(err, sql.ErrNoRows) {
+		return timeZone, ErrNotExist
+	}
+	return timeZone, err
+}
+
+const getSubscriptionByID = `select id, user_id, playlist_id, customized, status, frequency, 
+start_date, end_date, receiver_name, receiver_contact, auto_renew, term_days,
+delivery_instructions, time_zone, postal_code FROM subscription where id = $1`
+
+func (dq *DataQuery) GetSubscriptionByID(ctx context.Context, id string) (Subscription, error) {
+	row := dq.conn().QueryRowContext(ctx, getSubscriptionByID, id)
+	var i Subscription
+	err := scanSubscription(row, &i)
+	if errors.Is(err, sql.ErrNoRows) {
+		return i, ErrNotExist
+	}
 	return i, err
 }
 
 const getSubscriptionByUserID = `select id, user_id, playlist_id, customized, status, frequency, 
-start_date, end_date, receiver_name, receiver_contact FROM subscription where user_id = $1`
+start_date, end_date, receiver_name, receiver_contact, auto_renew, term_days,
+delivery_instructions, time_zone, postal_code FROM subscription where user_id = $1`
 
 func (dq *DataQuery) GetSubscriptionByUserID(ctx context.Context, userID string) ([]Subscription, error) {
-	rows, err := dq.DBConn.QueryContext(ctx, getSubscriptionByUserID, userID)
+	rows, err := dq.conn().QueryContext(ctx, getSubscriptionByUserID, userID)
 	if err != nil {
 		return nil, err
 	}
@@ -113,18 +192,191 @@ func (dq *DataQuery) GetSubscriptionByUserID(ctx context.Context, userID string)
 	var items []Subscription
 	for rows.Next() {
 		var i Subscription
-		if err := rows.Scan(
-			&i.ID,
-			&i.UserID,
-			&i.PlaylistID,
-			&i.Customized,
-			&i.Status,
-			&i.Frequency,
-			&i.StartDate,
-			&i.EndDate,
-			&i.ReceiverName,
-			&i.ReceiverContact,
-		); err != nil {
+		if err := scanSubscription(rows, &i); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listSubscriptions = `select id, user_id, playlist_id, customized, status, frequency, 
+start_date, end_date, receiver_name, receiver_contact, auto_renew, term_days,
+delivery_instructions, time_zone, postal_code FROM subscription where user_id = $1`
+
+// GetSubscriptionsPage returns one page of the subscriptions of a user, in the sort order
+// of the query whichever way the page was read.
+func (dq *DataQuery) GetSubscriptionsPage(ctx context.Context, q SubscriptionListQuery) ([]Subscription, error) {
+	query, args := subscriptionsPageQuery(q)
+	rows, err := dq.conn().QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Subscription
+	for rows.Next() {
+		var i Subscription
+		if err := scanSubscription(rows, &i); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if q.Before != nil {
+		reverseSubscriptions(items)
+	}
+	return items, nil
+}
+
+// C: the page is found by comparing the sort columns with those of the cursor (keyset
+// C: pagination), so reading a page costs the same however far into the listing it is.
+// C: The rows before a cursor are read backwards from it and reversed afterwards.
+func subscriptionsPageQuery(q SubscriptionListQuery) (string, []any) {
+	var query strings.Builder
+	query.WriteString(listSubscriptions)
+	args := []any{q.UserID}
+	param := func(v any) string {
+		args = append(args, v)
+		return "$" + strconv.Itoa(len(args))
+	}
+
+	if len(q.Statuses) > 0 {
+		fmt.Fprintf(&query, " and status = any(%s)", param(q.Statuses))
+	}
+	if !q.From.IsZero() {
+		fmt.Fprintf(&query, " and (end_date is NULL or end_date >= %s)", param(q.From))
+	}
+	if !q.To.IsZero() {
+		fmt.Fprintf(&query, " and start_date <= %s", param(q.To))
+	}
+	if q.PlaylistID != "" {
+		fmt.Fprintf(&query, " and playlist_id = %s", param(q.PlaylistID))
+	}
+	if q.Customized != nil {
+		fmt.Fprintf(&query, " and customized = %s", param(*q.Customized))
+	}
+
+	columns := []string{"start_date", "id"}
+	key := func(k *SubscriptionKey) []any { return []any{k.StartDate, k.ID} }
+	if q.SortBy == SortByStatus {
+		columns = []string{"status", "start_date", "id"}
+		key = func(k *SubscriptionKey) []any { return []any{k.Status, k.StartDate, k.ID} }
+	}
+
+	descending := q.Descending != (q.Before != nil)
+	cursor := q.After
+	if q.Before != nil {
+		cursor = q.Before
+	}
+	if cursor != nil {
+		params := make([]string, len(columns))
+		for i, v := range key(cursor) {
+			params[i] = param(v)
+		}
+		op := ">"
+		if descending {
+			op = "<"
+		}
+		fmt.Fprintf(&query, " and (%s) %s (%s)", strings.Join(columns, ", "), op, strings.Join(params, ", "))
+	}
+
+	direction := " asc"
+	if descending {
+		direction = " desc"
+	}
+	query.WriteString(" order by " + strings.Join(columns, direction+", ") + direction)
+	fmt.Fprintf(&query, " limit %s", param(q.Limit))
+	return query.String(), args
+}
+
+func reverseSubscriptions(subs []Subscription) {
+	for i, j := 0, len(subs)-1; i < j; i, j = i+1, j-1 {
+		subs[i], subs[j] = subs[j], subs[i]
+	}
+}
+
+const getSubscriptionsEndedBefore = `select id, user_id, playlist_id, customized, status, frequency, 
+start_date, end_date, receiver_name, receiver_contact, auto_renew, term_days,
+delivery_instructions, time_zone, postal_code FROM subscription
+where status = $1 and end_date is not NULL and end_date < $2`
+
+func (dq *DataQuery) GetSubscriptionsEndedBefore(ctx context.Context, status string, before time.Time) ([]Subscription, error) {
+	rows, err := dq.conn().QueryContext(ctx, getSubscriptionsEndedBefore, status, before)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Subscription
+	for rows.Next() {
+		var i Subscription
+		if err := scanSubscription(rows, &i); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getOpenEndedSubscriptions = `select id, user_id, playlist_id, customized, status, frequency, 
+start_date, end_date, receiver_name, receiver_contact, auto_renew, term_days,
+delivery_instructions, time_zone, postal_code FROM subscription
+where status = $1 and end_date is NULL`
+
+func (dq *DataQuery) GetOpenEndedSubscriptions(ctx context.Context, status string) ([]Subscription, error) {
+	rows, err := dq.conn().QueryContext(ctx, getOpenEndedSubscriptions, status)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Subscription
+	for rows.Next() {
+		var i Subscription
+		if err := scanSubscription(rows, &i); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getRenewableSubscriptions = `select id, user_id, playlist_id, customized, status, frequency, 
+start_date, end_date, receiver_name, receiver_contact, auto_renew, term_days,
+delivery_instructions, time_zone, postal_code FROM subscription
+where status = $1 and auto_renew and end_date is not NULL and end_date < $2`
+
+func (dq *DataQuery) GetRenewableSubscriptions(ctx context.Context, status string, before time.Time) ([]Subscription, error) {
+	rows, err := dq.conn().QueryContext(ctx, getRenewableSubscriptions, status, before)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Subscription
+	for rows.Next() {
+		var i Subscription
+		if err := scanSubscription(rows, &i); err != nil {
 			return nil, err
 		}
 		items = append(items, i)
@@ -138,13 +390,26 @@ func (dq *DataQuery) GetSubscriptionByUserID(ctx context.Context, userID string)
 	return items, nil
 }
 
+const getLatestDeliveryTime = `select max(expected_time) FROM dish_delivery where subscription_dish_id = $1`
+
+// C: returns the zero time when the dish has no deliveries yet. The SQLite query returns no
+// C: row then, see sqliteStatements
+func (dq *DataQuery) GetLatestDeliveryTime(ctx context.Context, subscriptionDishID string) (time.Time, error) {
+	var latest sql.NullTime
+	err := dq.conn().QueryRowContext(ctx, getLatestDeliveryTime, subscriptionDishID).Scan(&latest)
+	if errors.Is(err, sql.ErrNoRows) {
+		return time.Time{}, nil
+	}
+	return latest.Time, err
+}
+
 const insertDishDelivery = `insert into dish_delivery ("id", "subscription_dish_id", "status",
   "expected_time", "note")
   values ($1, $2, $3, $4, $5)
   returning id, subscription_dish_id, status, expected_time, note`
 
 func (dq *DataQuery) InsertDishDelivery(ctx context.Context, arg DishDelivery) (string, error) {
-	row := dq.DBConn.QueryRowContext(ctx, insertDishDelivery,
+	row := dq.conn().QueryRowContext(ctx, insertDishDelivery,
 		arg.ID,
 		arg.SubscriptionDishID,
 		arg.Status,
@@ -161,16 +426,94 @@ func (dq *DataQuery) InsertDishDelivery(ctx context.Context, arg DishDelivery) (
 		// &i.DeliveryTime,
 		&i.Note,
 	)
-	return i.ID, err
+	return i.ID, constraintError(err)
+}
+
+// C: the deliveries are sent as one array per column and unnested by the server, so a whole
+// C: batch is inserted in a single round trip whatever its size
+const insertDishDeliveries = `insert into dish_delivery ("id", "subscription_dish_id", "status",
+  "expected_time", "note")
+  select * from unnest($1::varchar[], $2::varchar[], $3::varchar[], $4::timestamptz[], $5::varchar[])
+  returning id`
+
+// maxDeliveryBatch bounds the size of one insert statement of InsertDishDeliveries
+const maxDeliveryBatch = 2000
+
+// InsertDishDeliveries inserts all deliveries or none of them and returns the ids of the
+// inserted rows. Delivery times and notes are stored as given, the same as InsertDishDelivery.
+func (dq *DataQuery) InsertDishDeliveries(ctx context.Context, deliveries []DishDelivery) ([]string, error) {
+	if len(deliveries) == 0 {
+		return nil, nil
+	}
+	ids := make([]string, 0, len(deliveries))
+	err := dq.execTx(ctx, func(q *DataQuery) error {
+		for start := 0; start < len(deliveries); start += maxDeliveryBatch {
+			end := start + maxDeliveryBatch
+			if end > len(deliveries) {
+				end = len(deliveries)
+			}
+			batch := deliveries[start:end]
+			inserted, err := q.insertDishDeliveryBatch(ctx, batch)
+			if err != nil {
+				return err
+			}
+			ids = append(ids, inserted...)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
+
+func (dq *DataQuery) insertDishDeliveryBatch(ctx context.Context, batch []DishDelivery) ([]string, error) {
+	if dq.sqlite != nil {
+		return dq.sqliteInsertDishDeliveryBatch(ctx, batch)
+	}
+
+	ids := make([]string, len(batch))
+	subscriptionDishIDs := make([]string, len(batch))
+	statuses := make([]string, len(batch))
+	expectedTimes := make([]time.Time, len(batch))
+	notes := make([]string, len(batch))
+	for i, arg := range batch {
+		ids[i] = arg.ID
+		subscriptionDishIDs[i] = arg.SubscriptionDishID
+		statuses[i] = arg.Status
+		expectedTimes[i] = arg.ExpectedTime
+		notes[i] = arg.Note
+	}
+
+	rows, err := dq.conn().QueryContext(ctx, insertDishDeliveries, ids, subscriptionDishIDs, statuses, expectedTimes, notes)
+	if err != nil {
+		return nil, constraintError(err)
+	}
+	defer rows.Close()
+	inserted := make([]string, 0, len(batch))
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		inserted = append(inserted, id)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return inserted, nil
 }
 
 const insertDishes = `insert into subscription_dish ("id", "dish_id", "subscription_id",
-  "schedule_time", "frequency", "dish_options", "note")
-  values ($1, $2, $3, $4, $5, $6, $7)
+  "schedule_time", "frequency", "dish_options", "note", "slot_id")
+  values ($1, $2, $3, $4, $5, $6, $7, $8)
   returning id, dish_id, subscription_id, schedule_time, frequency, dish_options, note`
 
 func (q *DataQuery) InsertDishes(ctx context.Context, arg SubscriptionDish) (string, error) {
-	row := q.DBConn.QueryRowContext(ctx, insertDishes,
+	row := q.conn().QueryRowContext(ctx, insertDishes,
 		arg.ID,
 		arg.DishID,
 		arg.SubscriptionID,
@@ -178,6 +521,7 @@ func (q *DataQuery) InsertDishes(ctx context.Context, arg SubscriptionDish) (str
 		arg.Frequency,
 		arg.DishOptions,
 		arg.Note,
+		nullString(arg.SlotID),
 	)
 	var i SubscriptionDish
 	err := row.Scan(
@@ -189,17 +533,81 @@ func (q *DataQuery) InsertDishes(ctx context.Context, arg SubscriptionDish) (str
 		&i.DishOptions,
 		&i.Note,
 	)
-	return i.ID, err
+	return i.ID, constraintError(err)
+}
+
+const updateSubscriptionDish = `update subscription_dish set frequency = $2, dish_options = $3, note = $4
+where id = $1 and removed_at is NULL
+returning id, dish_id, subscription_id, schedule_time, frequency, dish_options, note, removed_at, slot_id`
+
+func (dq *DataQuery) UpdateSubscriptionDish(ctx context.Context, arg SubscriptionDish) (SubscriptionDish, error) {
+	row := dq.conn().QueryRowContext(ctx, updateSubscriptionDish,
+		arg.ID,
+		arg.Frequency,
+		arg.DishOptions,
+		arg.Note,
+	)
+	var i SubscriptionDish
+	err := scanSubscriptionDish(row, &i)
+	if errors.Is(err, sql.ErrNoRows) {
+		return i, ErrUpdateFailed
+	}
+	return i, err
+}
+
+const removeSubscriptionDish = `update subscription_dish set removed_at = $2
+where id = $1 and removed_at is NULL
+returning id, dish_id, subscription_id, schedule_time, frequency, dish_options, note, removed_at, slot_id`
+
+func (dq *DataQuery) RemoveSubscriptionDish(ctx context.Context, removedAt time.Time, subscriptionDishID string) (SubscriptionDish, error) {
+	row := dq.conn().QueryRowContext(ctx, removeSubscriptionDish, subscriptionDishID, removedAt)
+	var i SubscriptionDish
+	err := scanSubscriptionDish(row, &i)
+	if errors.Is(err, sql.ErrNoRows) {
+		return i, ErrDeleteFailed
+	}
+	return i, err
+}
+
+// C: only deliveries which have not gone out yet are deleted, history is never touched
+const deleteDishDeliveriesFrom = `delete from dish_delivery
+where subscription_dish_id = $1 and status = $2 and expected_time >= $3 and delivery_time is NULL
+returning id, subscription_dish_id, status, expected_time, note`
+
+func (dq *DataQuery) DeleteDishDeliveriesFrom(ctx context.Context, subscriptionDishID string, status string, from time.Time) ([]DishDelivery, error) {
+	rows, err := dq.conn().QueryContext(ctx, deleteDishDeliveriesFrom, subscriptionDishID, status, from)
+	if err != nil {
+		return nil, err
+	}
+	return scanReturnedDeliveries(rows)
+}
+
+// C: deletes the given deliveries, those which left the status in the meantime are kept
+const deleteDishDeliveries = `delete from dish_delivery
+where id = any($1) and status = $2 and delivery_time is NULL
+returning id, subscription_dish_id, status, expected_time, note`
+
+func (dq *DataQuery) DeleteDishDeliveries(ctx context.Context, status string, deliveryIDs []string) ([]DishDelivery, error) {
+	if len(deliveryIDs) == 0 {
+		return nil, nil
+	}
+	rows, err := dq.conn().QueryContext(ctx, deleteDishDeliveries, deliveryIDs, status)
+	if err != nil {
+		return nil, err
+	}
+	return scanReturnedDeliveries(rows)
 }
 
 const insertSubscription = `insert into subscription ("id", "user_id", "playlist_id",
   "customized", "status", "frequency", "start_date",
-  "end_date", "receiver_name", "receiver_contact") values ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
-  returning id, user_id, playlist_id, customized, status, frequency, start_date, end_date, receiver_name, receiver_contact
+  "end_date", "receiver_name", "receiver_contact", "auto_renew", "term_days", "delivery_instructions", "time_zone",
+  "postal_code")
+  values ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15)
+  returning id, user_id, playlist_id, customized, status, frequency, start_date, end_date, receiver_name, receiver_contact, auto_renew, term_days, delivery_instructions, time_zone, postal_code
 `
 
 func (q *DataQuery) InsertSubscription(ctx context.Context, arg Subscription) (Subscription, error) {
-	row := q.DBConn.QueryRowContext(ctx, insertSubscription,
+	row := q.conn().QueryRowContext(ctx, insertSubscription,
 		arg.ID,
 		arg.UserID,
 		arg.PlaylistID,
@@ -207,33 +615,104 @@ func (q *DataQuery) InsertSubscription(ctx context.Context, arg Subscription) (S
 		arg.Status,
 		arg.Frequency,
 		arg.StartDate,
-		arg.EndDate,
+		nullTime(arg.EndDate),
 		arg.ReceiverName,
 		arg.ReceiverContact,
+		arg.AutoRenew,
+		nullInt(arg.TermDays),
+		arg.DeliveryInstructions,
+		arg.TimeZone,
+		arg.PostalCode,
 	)
 	var i Subscription
-	err := row.Scan(
-		&i.ID,
-		&i.UserID,
-		&i.PlaylistID,
-		&i.Customized,
-		&i.Status,
-		&i.Frequency,
-		&i.StartDate,
-		&i.EndDate,
-		&i.ReceiverName,
-		&i.ReceiverContact,
-	)
-	return i, err
+	err := scanSubscription(row, &i)
+	return i, constraintError(err)
 }
 
-const changeDishDeliveryStatus = `update dish_delivery set status = $1 where subscription_dish_id = $2 and delivery_time is NULL
+// C: only deliveries which have not been delivered yet can be changed
+const updateDishDelivery = `update dish_delivery set status = $2, expected_time = $3, note = $4
+where id = $1 and delivery_time is NULL
 returning id, subscription_dish_id, status, expected_time, note`
 
-func (dq *DataQuery) ChangeDishDeliveryStatus(ctx context.Context, toStatus string, subscriptionDishID string) ([]DishDelivery, error) {
-	rows, err := dq.DBConn.QueryContext(ctx, changeDishDeliveryStatus, toStatus, subscriptionDishID)
-	if err != nil {
-		return nil, err
+func (dq *DataQuery) UpdateDishDelivery(ctx context.Context, arg DishDelivery) (DishDelivery, error) {
+	row := dq.conn().QueryRowContext(ctx, updateDishDelivery,
+		arg.ID,
+		arg.Status,
+		arg.ExpectedTime,
+		arg.Note,
+	)
+	var i DishDelivery
+	err := row.Scan(
+		&i.ID,
+		&i.SubscriptionDishID,
+		&i.Status,
+		&i.ExpectedTime,
+		&i.Note,
+	)
+	if errors.Is(err, sql.ErrNoRows) {
+		return i, ErrUpdateFailed
+	}
+	return i, err
+}
+
+// C: the courier update only succeeds when the delivery is still in the status it was read with
+const updateDeliveryProgress = `update dish_delivery set status = $3, delivery_time = $4, note = $5
+where id = $1 and status = $2
+returning id, subscription_dish_id, status, expected_time, delivery_time, note`
+
+func (dq *DataQuery) UpdateDeliveryProgress(ctx context.Context, fromStatus string, arg DishDelivery) (DishDelivery, error) {
+	row := dq.conn().QueryRowContext(ctx, updateDeliveryProgress,
+		arg.ID,
+		fromStatus,
+		arg.Status,
+		nullTime(arg.DeliveryTime),
+		arg.Note,
+	)
+	var i DishDelivery
+	var deliveryTime sql.NullTime
+	var note sql.NullString
+	err := row.Scan(
+		&i.ID,
+		&i.SubscriptionDishID,
+		&i.Status,
+		&i.ExpectedTime,
+		&deliveryTime,
+		&note,
+	)
+	if errors.Is(err, sql.ErrNoRows) {
+		return i, ErrUpdateFailed
+	}
+	i.DeliveryTime = deliveryTime.Time
+	i.Note = note.String
+	return i, err
+}
+
+const changeDishDeliveryStatusByID = `update dish_delivery set status = $3 where id = $1 and status = $2
+returning id, subscription_dish_id, status, expected_time, note`
+
+func (dq *DataQuery) ChangeDishDeliveryStatusByID(ctx context.Context, fromStatus, toStatus string, deliveryID string) (DishDelivery, error) {
+	row := dq.conn().QueryRowContext(ctx, changeDishDeliveryStatusByID, deliveryID, fromStatus, toStatus)
+	var i DishDelivery
+	err := row.Scan(
+		&i.ID,
+		&i.SubscriptionDishID,
+		&i.Status,
+		&i.ExpectedTime,
+		&i.Note,
+	)
+	if errors.Is(err, sql.ErrNoRows) {
+		return i, ErrUpdateFailed
+	}
+	return i, err
+}
+
+const changeDishDeliveryStatus = `update dish_delivery set status = $1 where subscription_dish_id = $2 and delivery_time is NULL
+returning id, subscription_dish_id, status, expected_time, note`
+
+func (dq *DataQuery) ChangeDishDeliveryStatus(ctx context.Context, toStatus string, subscriptionDishID string) ([]DishDelivery, error) {
+	rows, err := dq.conn().QueryContext(ctx, changeDishDeliveryStatus, toStatus, subscriptionDishID)
+	if err != nil {
+		return nil, err
 	}
 	defer rows.Close()
 
@@ -261,25 +740,971 @@ func (dq *DataQuery) ChangeDishDeliveryStatus(ctx context.Context, toStatus stri
 	return dishesDelivery, nil
 }
 
+// C: the update only succeeds when the subscription is still in the expected status,
+// C: and the transition is logged in the same statement so the two never diverge
 const changeSubscriptionStatus = `
-update subscription set status = $1 where id = $2
-returning id, user_id, playlist_id, customized, status, frequency, start_date, end_date, receiver_name, receiver_contact
+with updated as (
+  update subscription set status = $3 where id = $2 and status = $1
+  returning id, user_id, playlist_id, customized, status, frequency, start_date, end_date, receiver_name, receiver_contact, auto_renew, term_days, delivery_instructions, time_zone, postal_code
+), logged as (
+  insert into subscription_status_transition ("id", "subscription_id", "from_status",
+    "to_status", "triggered_by", "triggered_at")
+  select $4, id, $1, $3, $5, $6 from updated
+)
+select id, user_id, playlist_id, customized, status, frequency, start_date, end_date, receiver_name, receiver_contact, auto_renew, term_days, delivery_instructions, time_zone, postal_code
+from updated
 `
 
-func (dq *DataQuery) ChangeSubscriptionStatus(ctx context.Context, toStatus, subscriptionID string) (Subscription, error) {
-	row := dq.DBConn.QueryRowContext(ctx, changeSubscriptionStatus, toStatus, subscriptionID)
+func (dq *DataQuery) ChangeSubscriptionStatus(ctx context.Context, arg StatusTransition) (Subscription, error) {
+	if dq.sqlite != nil {
+		return dq.sqliteChangeSubscriptionStatus(ctx, arg)
+	}
+	row := dq.conn().QueryRowContext(ctx, changeSubscriptionStatus,
+		arg.FromStatus,
+		arg.SubscriptionID,
+		arg.ToStatus,
+		arg.ID,
+		arg.TriggeredBy,
+		arg.TriggeredAt,
+	)
 	var sub Subscription
-	err := row.Scan(
-		&sub.ID,
-		&sub.UserID,
-		&sub.PlaylistID,
-		&sub.Customized,
-		&sub.Status,
-		&sub.Frequency,
-		&sub.StartDate,
-		&sub.EndDate,
-		&sub.ReceiverName,
-		&sub.ReceiverContact,
+	err := scanSubscription(row, &sub)
+	if errors.Is(err, sql.ErrNoRows) {
+		return sub, ErrUpdateFailed
+	}
+	return sub, err
+}
+
+const changeDeliveryStatusInWindow = `update dish_delivery set status = $3
+where status = $2 and expected_time >= $4 and ($5::timestamptz is null or expected_time < $5)
+  and subscription_dish_id in (select id from subscription_dish where subscription_id = $1)
+returning id, subscription_dish_id, status, expected_time, note`
+
+func (dq *DataQuery) ChangeDeliveryStatusInWindow(ctx context.Context, arg DeliveryWindowUpdate) ([]DishDelivery, error) {
+	rows, err := dq.conn().QueryContext(ctx, changeDeliveryStatusInWindow,
+		arg.SubscriptionID,
+		arg.FromStatus,
+		arg.ToStatus,
+		arg.From,
+		nullTime(arg.Until),
 	)
+	if err != nil {
+		return nil, err
+	}
+	return scanReturnedDeliveries(rows)
+}
+
+// C: scans the deliveries an update or delete returns, which have no delivery time yet
+func scanReturnedDeliveries(rows *sql.Rows) ([]DishDelivery, error) {
+	defer rows.Close()
+
+	var dishesDelivery []DishDelivery
+	for rows.Next() {
+		var dishDeliver DishDelivery
+		if err := rows.Scan(
+			&dishDeliver.ID,
+			&dishDeliver.SubscriptionDishID,
+			&dishDeliver.Status,
+			&dishDeliver.ExpectedTime,
+			&dishDeliver.Note,
+		); err != nil {
+			return nil, err
+		}
+		dishesDelivery = append(dishesDelivery, dishDeliver)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return dishesDelivery, nil
+}
+
+const updateSubscriptionEndDate = `
+update subscription set end_date = $1 where id = $2
+returning id, user_id, playlist_id, customized, status, frequency, start_date, end_date, receiver_name, receiver_contact, auto_renew, term_days, delivery_instructions, time_zone, postal_code
+`
+
+func (dq *DataQuery) UpdateSubscriptionEndDate(ctx context.Context, endDate time.Time, subscriptionID string) (Subscription, error) {
+	row := dq.conn().QueryRowContext(ctx, updateSubscriptionEndDate, nullTime(endDate), subscriptionID)
+	var sub Subscription
+	err := scanSubscription(row, &sub)
+	if errors.Is(err, sql.ErrNoRows) {
+		return sub, ErrNotExist
+	}
+	return sub, err
+}
+
+const renewSubscription = `
+update subscription set end_date = $1
+where id = $2 and status = $3 and auto_renew and end_date = $4
+returning id, user_id, playlist_id, customized, status, frequency, start_date, end_date, receiver_name, receiver_contact, auto_renew, term_days, delivery_instructions, time_zone, postal_code
+`
+
+// C: only renews while the subscription is still in the status, renews automatically and
+// ends on previousEndDate, otherwise ErrUpdateFailed is returned
+func (dq *DataQuery) RenewSubscription(ctx context.Context, status string, previousEndDate, endDate time.Time, subscriptionID string) (Subscription, error) {
+	row := dq.conn().QueryRowContext(ctx, renewSubscription, endDate, subscriptionID, status, previousEndDate)
+	var sub Subscription
+	err := scanSubscription(row, &sub)
+	if errors.Is(err, sql.ErrNoRows) {
+		return sub, ErrUpdateFailed
+	}
 	return sub, err
 }
+
+const updateSubscriptionTerm = `
+update subscription set start_date = $1, end_date = $2, term_days = $3 where id = $4
+returning id, user_id, playlist_id, customized, status, frequency, start_date, end_date, receiver_name, receiver_contact, auto_renew, term_days, delivery_instructions, time_zone, postal_code
+`
+
+// C: a zero end date makes the subscription open ended
+func (dq *DataQuery) UpdateSubscriptionTerm(ctx context.Context, startDate, endDate time.Time, termDays int, subscriptionID string) (Subscription, error) {
+	row := dq.conn().QueryRowContext(ctx, updateSubscriptionTerm, startDate, nullTime(endDate), nullInt(termDays), subscriptionID)
+	var sub Subscription
+	err := scanSubscription(row, &sub)
+	if errors.Is(err, sql.ErrNoRows) {
+		return sub, ErrNotExist
+	}
+	return sub, err
+}
+
+const updateSubscriptionAutoRenew = `
+update subscription set auto_renew = $1 where id = $2
+returning id, user_id, playlist_id, customized, status, frequency, start_date, end_date, receiver_name, receiver_contact, auto_renew, term_days, delivery_instructions, time_zone, postal_code
+`
+
+func (dq *DataQuery) UpdateSubscriptionAutoRenew(ctx context.Context, autoRenew bool, subscriptionID string) (Subscription, error) {
+	row := dq.conn().QueryRowContext(ctx, updateSubscriptionAutoRenew, autoRenew, subscriptionID)
+	var sub Subscription
+	err := scanSubscription(row, &sub)
+	if errors.Is(err, sql.ErrNoRows) {
+		return sub, ErrNotExist
+	}
+	return sub, err
+}
+
+// C: the receiver details being replaced are kept in the history, valid until the
+// C: effective date of the new ones, in the same statement as the update
+const changeSubscriptionReceiver = `
+with old as (
+  select id, receiver_name, receiver_contact, delivery_instructions from subscription where id = $1 for update
+), logged as (
+  insert into subscription_receiver_history ("id", "subscription_id", "receiver_name",
+    "receiver_contact", "delivery_instructions", "valid_until", "changed_by", "changed_at")
+  select $5, id, receiver_name, receiver_contact, delivery_instructions, $6, $7, $8 from old
+)
+update subscription set receiver_name = $2, receiver_contact = $3, delivery_instructions = $4
+where id = (select id from old)
+returning id, user_id, playlist_id, customized, status, frequency, start_date, end_date, receiver_name, receiver_contact, auto_renew, term_days, delivery_instructions, time_zone, postal_code
+`
+
+// C: only ID, ValidUntil, ChangedBy and ChangedAt of the history entry are used,
+// C: the replaced details themselves are copied from the subscription row
+func (dq *DataQuery) ChangeSubscriptionReceiver(ctx context.Context, subscriptionID string, receiver ReceiverDetails, history ReceiverHistory) (Subscription, error) {
+	if dq.sqlite != nil {
+		return dq.sqliteChangeSubscriptionReceiver(ctx, subscriptionID, receiver, history)
+	}
+	row := dq.conn().QueryRowContext(ctx, changeSubscriptionReceiver,
+		subscriptionID,
+		receiver.ReceiverName,
+		receiver.ReceiverContact,
+		receiver.DeliveryInstructions,
+		history.ID,
+		history.ValidUntil,
+		history.ChangedBy,
+		history.ChangedAt,
+	)
+	var sub Subscription
+	err := scanSubscription(row, &sub)
+	if errors.Is(err, sql.ErrNoRows) {
+		return sub, ErrNotExist
+	}
+	return sub, err
+}
+
+const getReceiverHistory = `select id, subscription_id, receiver_name, receiver_contact, delivery_instructions,
+valid_until, changed_by, changed_at FROM subscription_receiver_history where subscription_id = $1
+order by valid_until, changed_at`
+
+func (dq *DataQuery) GetReceiverHistory(ctx context.Context, subscriptionID string) ([]ReceiverHistory, error) {
+	rows, err := dq.conn().QueryContext(ctx, getReceiverHistory, subscriptionID)
+	if err != nil {
+		return nil, err
+	}
+	return scanReceiverHistory(rows)
+}
+
+// C: reads the receiver history of many subscriptions at once, e.g. for a calendar feed
+const getReceiverHistories = `select id, subscription_id, receiver_name, receiver_contact, delivery_instructions,
+valid_until, changed_by, changed_at FROM subscription_receiver_history where subscription_id = any($1)
+order by subscription_id, valid_until, changed_at`
+
+func (dq *DataQuery) GetReceiverHistories(ctx context.Context, subscriptionIDs []string) ([]ReceiverHistory, error) {
+	if len(subscriptionIDs) == 0 {
+		return nil, nil
+	}
+	rows, err := dq.conn().QueryContext(ctx, getReceiverHistories, subscriptionIDs)
+	if err != nil {
+		return nil, err
+	}
+	return scanReceiverHistory(rows)
+}
+
+func scanReceiverHistory(rows *sql.Rows) ([]ReceiverHistory, error) {
+	defer rows.Close()
+	var items []ReceiverHistory
+	for rows.Next() {
+		var i ReceiverHistory
+		var instructions sql.NullString
+		if err := rows.Scan(
+			&i.ID,
+			&i.SubscriptionID,
+			&i.ReceiverName,
+			&i.ReceiverContact,
+			&instructions,
+			&i.ValidUntil,
+			&i.ChangedBy,
+			&i.ChangedAt,
+		); err != nil {
+			return nil, err
+		}
+		i.DeliveryInstructions = instructions.String
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const insertSubscriptionPause = `insert into subscription_pause ("id", "subscription_id",
+  "pause_from", "pause_until") values ($1, $2, $3, $4)`
+
+func (dq *DataQuery) InsertSubscriptionPause(ctx context.Context, arg SubscriptionPause) error {
+	_, err := dq.conn().ExecContext(ctx, insertSubscriptionPause,
+		arg.ID,
+		arg.SubscriptionID,
+		arg.PauseFrom,
+		nullTime(arg.PauseUntil),
+	)
+	return constraintError(err)
+}
+
+const getOpenSubscriptionPause = `select id, subscription_id, pause_from, pause_until
+FROM subscription_pause where subscription_id = $1 and resumed_at is NULL
+order by pause_from desc limit 1`
+
+func (dq *DataQuery) GetOpenSubscriptionPause(ctx context.Context, subscriptionID string) (SubscriptionPause, error) {
+	row := dq.conn().QueryRowContext(ctx, getOpenSubscriptionPause, subscriptionID)
+	var i SubscriptionPause
+	var pauseUntil sql.NullTime
+	err := row.Scan(
+		&i.ID,
+		&i.SubscriptionID,
+		&i.PauseFrom,
+		&pauseUntil,
+	)
+	if errors.Is(err, sql.ErrNoRows) {
+		return i, ErrNotExist
+	}
+	i.PauseUntil = pauseUntil.Time
+	return i, err
+}
+
+const getPausesStartingBefore = `select p.id, p.subscription_id, p.pause_from, p.pause_until
+FROM subscription_pause p join subscription s on s.id = p.subscription_id
+where s.status = $1 and p.resumed_at is NULL and p.pause_from <= $2
+order by p.pause_from`
+
+// GetPausesStartingBefore returns the open pauses of subscriptions in the given status
+// which start at or before the given time.
+func (dq *DataQuery) GetPausesStartingBefore(ctx context.Context, subscriptionStatus string, before time.Time) ([]SubscriptionPause, error) {
+	return dq.getPauses(ctx, getPausesStartingBefore, subscriptionStatus, before)
+}
+
+const getPausesEndingBefore = `select p.id, p.subscription_id, p.pause_from, p.pause_until
+FROM subscription_pause p join subscription s on s.id = p.subscription_id
+where s.status = $1 and p.resumed_at is NULL and p.pause_until is not NULL and p.pause_until <= $2
+order by p.pause_until`
+
+// GetPausesEndingBefore returns the open pauses of subscriptions in the given status
+// which end at or before the given time. Pauses without an end never do.
+func (dq *DataQuery) GetPausesEndingBefore(ctx context.Context, subscriptionStatus string, before time.Time) ([]SubscriptionPause, error) {
+	return dq.getPauses(ctx, getPausesEndingBefore, subscriptionStatus, before)
+}
+
+func (dq *DataQuery) getPauses(ctx context.Context, query string, args ...interface{}) ([]SubscriptionPause, error) {
+	rows, err := dq.conn().QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []SubscriptionPause
+	for rows.Next() {
+		var i SubscriptionPause
+		var pauseUntil sql.NullTime
+		if err := rows.Scan(
+			&i.ID,
+			&i.SubscriptionID,
+			&i.PauseFrom,
+			&pauseUntil,
+		); err != nil {
+			return nil, err
+		}
+		i.PauseUntil = pauseUntil.Time
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const closeSubscriptionPause = `update subscription_pause set resumed_at = $1, resume_policy = $2 where id = $3`
+
+func (dq *DataQuery) CloseSubscriptionPause(ctx context.Context, resumedAt time.Time, policy string, pauseID string) error {
+	res, err := dq.conn().ExecContext(ctx, closeSubscriptionPause, resumedAt, policy, pauseID)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrUpdateFailed
+	}
+	return nil
+}
+
+const insertSubscriptionCancellation = `insert into subscription_cancellation ("id", "subscription_id",
+  "reason_code", "comment", "previous_status", "cancelled_by", "cancelled_at", "undo_until")
+  values ($1, $2, $3, $4, $5, $6, $7, $8)`
+
+func (dq *DataQuery) InsertSubscriptionCancellation(ctx context.Context, arg SubscriptionCancellation) error {
+	_, err := dq.conn().ExecContext(ctx, insertSubscriptionCancellation,
+		arg.ID,
+		arg.SubscriptionID,
+		arg.ReasonCode,
+		arg.Comment,
+		arg.PreviousStatus,
+		arg.CancelledBy,
+		arg.CancelledAt,
+		arg.UndoUntil,
+	)
+	return constraintError(err)
+}
+
+const insertCancelledDelivery = `insert into cancelled_delivery ("cancellation_id", "dish_delivery_id",
+  "previous_status") values ($1, $2, $3)`
+
+func (dq *DataQuery) InsertCancelledDelivery(ctx context.Context, arg CancelledDelivery) error {
+	_, err := dq.conn().ExecContext(ctx, insertCancelledDelivery,
+		arg.CancellationID,
+		arg.DishDeliveryID,
+		arg.PreviousStatus,
+	)
+	return constraintError(err)
+}
+
+const getLatestSubscriptionCancellation = `select id, subscription_id, reason_code, comment, previous_status,
+cancelled_by, cancelled_at, undo_until FROM subscription_cancellation
+where subscription_id = $1 and undone_at is NULL order by cancelled_at desc limit 1`
+
+func (dq *DataQuery) GetLatestSubscriptionCancellation(ctx context.Context, subscriptionID string) (SubscriptionCancellation, error) {
+	row := dq.conn().QueryRowContext(ctx, getLatestSubscriptionCancellation, subscriptionID)
+	var i SubscriptionCancellation
+	var reasonCode, comment sql.NullString
+	err := row.Scan(
+		&i.ID,
+		&i.SubscriptionID,
+		&reasonCode,
+		&comment,
+		&i.PreviousStatus,
+		&i.CancelledBy,
+		&i.CancelledAt,
+		&i.UndoUntil,
+	)
+	if errors.Is(err, sql.ErrNoRows) {
+		return i, ErrNotExist
+	}
+	i.ReasonCode = reasonCode.String
+	i.Comment = comment.String
+	return i, err
+}
+
+const getCancelledDeliveries = `select cancellation_id, dish_delivery_id, previous_status
+FROM cancelled_delivery where cancellation_id = $1`
+
+func (dq *DataQuery) GetCancelledDeliveries(ctx context.Context, cancellationID string) ([]CancelledDelivery, error) {
+	rows, err := dq.conn().QueryContext(ctx, getCancelledDeliveries, cancellationID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []CancelledDelivery
+	for rows.Next() {
+		var i CancelledDelivery
+		if err := rows.Scan(
+			&i.CancellationID,
+			&i.DishDeliveryID,
+			&i.PreviousStatus,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const markCancellationUndone = `update subscription_cancellation set undone_at = $1 where id = $2 and undone_at is NULL`
+
+func (dq *DataQuery) MarkCancellationUndone(ctx context.Context, undoneAt time.Time, cancellationID string) error {
+	res, err := dq.conn().ExecContext(ctx, markCancellationUndone, undoneAt, cancellationID)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrUpdateFailed
+	}
+	return nil
+}
+
+const tryAdvisoryLock = `select pg_try_advisory_lock($1)`
+
+const advisoryUnlock = `select pg_advisory_unlock($1)`
+
+// C: a session level advisory lock belongs to one connection, so the lock holds on to its
+// C: own connection until release is called. ok is false when another replica holds the lock.
+func (dq *DataQuery) TryAdvisoryLock(ctx context.Context, key int64) (release func() error, ok bool, err error) {
+	db, isDB := dq.DBConn.(*sql.DB)
+	if !isDB {
+		return nil, false, ErrInTransaction
+	}
+	if dq.sqlite != nil {
+		return dq.sqlite.tryLock(key)
+	}
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return nil, false, err
+	}
+
+	if err := conn.QueryRowContext(ctx, tryAdvisoryLock, key).Scan(&ok); err != nil || !ok {
+		conn.Close()
+		return nil, false, err
+	}
+
+	release = func() error {
+		defer conn.Close()
+		_, err := conn.ExecContext(context.Background(), advisoryUnlock, key)
+		return err
+	}
+	return release, true, nil
+}
+
+func nullInt(n int) sql.NullInt32 {
+	return sql.NullInt32{Int32: int32(n), Valid: n != 0}
+}
+
+const insertBlackout = `insert into blackout ("id", "scope", "scope_id", "start_date", "end_date",
+  "action", "summary", "source_uid", "created_by", "created_at")
+  values ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+  returning id, scope, scope_id, start_date, end_date, action, summary, source_uid, created_by, created_at`
+
+func (dq *DataQuery) InsertBlackout(ctx context.Context, arg Blackout) (Blackout, error) {
+	row := dq.conn().QueryRowContext(ctx, insertBlackout,
+		arg.ID,
+		arg.Scope,
+		arg.ScopeID,
+		arg.StartDate,
+		arg.EndDate,
+		arg.Action,
+		arg.Summary,
+		nullString(arg.SourceUID),
+		arg.CreatedBy,
+		arg.CreatedAt,
+	)
+	var i Blackout
+	err := scanBlackout(row, &i)
+	return i, constraintError(err)
+}
+
+// C: importing the same calendar again updates the events instead of adding them twice
+const upsertBlackout = `insert into blackout ("id", "scope", "scope_id", "start_date", "end_date",
+  "action", "summary", "source_uid", "created_by", "created_at")
+  values ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+  on conflict ("scope", "scope_id", "source_uid") do update
+  set start_date = excluded.start_date, end_date = excluded.end_date, action = excluded.action,
+    summary = excluded.summary
+  returning id, scope, scope_id, start_date, end_date, action, summary, source_uid, created_by, created_at`
+
+func (dq *DataQuery) UpsertBlackout(ctx context.Context, arg Blackout) (Blackout, error) {
+	row := dq.conn().QueryRowContext(ctx, upsertBlackout,
+		arg.ID,
+		arg.Scope,
+		arg.ScopeID,
+		arg.StartDate,
+		arg.EndDate,
+		arg.Action,
+		arg.Summary,
+		arg.SourceUID,
+		arg.CreatedBy,
+		arg.CreatedAt,
+	)
+	var i Blackout
+	err := scanBlackout(row, &i)
+	return i, constraintError(err)
+}
+
+const getBlackoutsByScope = `select id, scope, scope_id, start_date, end_date, action, summary, source_uid,
+created_by, created_at FROM blackout where scope = $1 and scope_id = $2 order by start_date`
+
+func (dq *DataQuery) GetBlackoutsByScope(ctx context.Context, scope, scopeID string) ([]Blackout, error) {
+	rows, err := dq.conn().QueryContext(ctx, getBlackoutsByScope, scope, scopeID)
+	if err != nil {
+		return nil, err
+	}
+	return scanBlackouts(rows)
+}
+
+// C: every blackout which may apply to the deliveries of a user between the two dates,
+// C: the restaurant ones are narrowed down by the caller
+const getBlackoutsForUser = `select id, scope, scope_id, start_date, end_date, action, summary, source_uid,
+created_by, created_at FROM blackout
+where (scope = 'global' or scope = 'restaurant' or (scope = 'user' and scope_id = $1))
+and end_date >= $2 and start_date <= $3
+order by start_date`
+
+func (dq *DataQuery) GetBlackoutsForUser(ctx context.Context, userID string, from, until time.Time) ([]Blackout, error) {
+	rows, err := dq.conn().QueryContext(ctx, getBlackoutsForUser, userID, from, until)
+	if err != nil {
+		return nil, err
+	}
+	return scanBlackouts(rows)
+}
+
+const getBlackoutByID = `select id, scope, scope_id, start_date, end_date, action, summary, source_uid,
+created_by, created_at FROM blackout where id = $1`
+
+func (dq *DataQuery) GetBlackoutByID(ctx context.Context, id string) (Blackout, error) {
+	row := dq.conn().QueryRowContext(ctx, getBlackoutByID, id)
+	var i Blackout
+	err := scanBlackout(row, &i)
+	if errors.Is(err, sql.ErrNoRows) {
+		return i, ErrNotExist
+	}
+	return i, err
+}
+
+const deleteBlackout = `delete from blackout where id = $1`
+
+func (dq *DataQuery) DeleteBlackout(ctx context.Context, id string) error {
+	res, err := dq.conn().ExecContext(ctx, deleteBlackout, id)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrDeleteFailed
+	}
+	return nil
+}
+
+const insertDeliverySlot = `insert into delivery_slot ("id", "start_time", "end_time", "capacity", "active")
+  values ($1, $2, $3, $4, $5)
+  returning id, start_time, end_time, capacity, active`
+
+func (dq *DataQuery) InsertDeliverySlot(ctx context.Context, arg DeliverySlot) (DeliverySlot, error) {
+	row := dq.conn().QueryRowContext(ctx, insertDeliverySlot,
+		arg.ID,
+		arg.StartTime,
+		arg.EndTime,
+		arg.Capacity,
+		arg.Active,
+	)
+	var i DeliverySlot
+	err := row.Scan(&i.ID, &i.StartTime, &i.EndTime, &i.Capacity, &i.Active)
+	return i, constraintError(err)
+}
+
+const getActiveDeliverySlots = `select id, start_time, end_time, capacity, active
+FROM delivery_slot where active order by start_time`
+
+func (dq *DataQuery) GetActiveDeliverySlots(ctx context.Context) ([]DeliverySlot, error) {
+	rows, err := dq.conn().QueryContext(ctx, getActiveDeliverySlots)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []DeliverySlot
+	for rows.Next() {
+		var i DeliverySlot
+		if err := rows.Scan(&i.ID, &i.StartTime, &i.EndTime, &i.Capacity, &i.Active); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getSlotReservations = `select slot_id, postal_zone, delivery_date, reserved
+FROM slot_reservation where postal_zone = $1 and delivery_date >= $2 and delivery_date <= $3
+order by delivery_date, slot_id`
+
+func (dq *DataQuery) GetSlotReservations(ctx context.Context, postalZone string, from, until time.Time) ([]SlotReservation, error) {
+	rows, err := dq.conn().QueryContext(ctx, getSlotReservations, postalZone, from, until)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []SlotReservation
+	for rows.Next() {
+		var i SlotReservation
+		if err := rows.Scan(&i.SlotID, &i.PostalZone, &i.DeliveryDate, &i.Reserved); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+// C: the row lock taken by "on conflict do update" makes concurrent reservations of the same
+// C: slot and day wait for each other, the capacity is checked again once the lock is held
+const reserveSlot = `insert into slot_reservation ("slot_id", "postal_zone", "delivery_date", "reserved")
+  select id, $2, $3, $4 from delivery_slot where id = $1 and active and capacity >= $4
+  on conflict ("slot_id", "postal_zone", "delivery_date") do update
+  set reserved = slot_reservation.reserved + excluded.reserved
+  where slot_reservation.reserved + excluded.reserved <= (select capacity from delivery_slot where id = excluded.slot_id)
+  returning reserved`
+
+// ReserveSlots takes every reservation or none of them. Reserved is the number of deliveries
+// to add; a *SlotFullError tells which slot and day ran out of room.
+func (dq *DataQuery) ReserveSlots(ctx context.Context, reservations []SlotReservation) error {
+	return dq.execTx(ctx, func(q *DataQuery) error {
+		for _, arg := range reservations {
+			var reserved int
+			err := q.conn().QueryRowContext(ctx, reserveSlot, arg.SlotID, arg.PostalZone, arg.DeliveryDate, arg.Reserved).Scan(&reserved)
+			if errors.Is(err, sql.ErrNoRows) {
+				return &SlotFullError{SlotID: arg.SlotID, PostalZone: arg.PostalZone, DeliveryDate: arg.DeliveryDate}
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+const releaseSlot = `update slot_reservation set reserved = greatest(reserved - $4, 0)
+where slot_id = $1 and postal_zone = $2 and delivery_date = $3`
+
+// ReleaseSlots gives back the places taken by ReserveSlots.
+func (dq *DataQuery) ReleaseSlots(ctx context.Context, reservations []SlotReservation) error {
+	return dq.execTx(ctx, func(q *DataQuery) error {
+		for _, arg := range reservations {
+			if _, err := q.conn().ExecContext(ctx, releaseSlot, arg.SlotID, arg.PostalZone, arg.DeliveryDate, arg.Reserved); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// C: creating a new token replaces the old one, so old feed URLs stop working
+const upsertCalendarToken = `insert into calendar_token ("user_id", "token_hash", "created_at")
+  values ($1, $2, $3)
+  on conflict ("user_id") do update set token_hash = excluded.token_hash, created_at = excluded.created_at`
+
+func (dq *DataQuery) UpsertCalendarToken(ctx context.Context, userID, tokenHash string, createdAt time.Time) error {
+	_, err := dq.conn().ExecContext(ctx, upsertCalendarToken, userID, tokenHash, createdAt)
+	return err
+}
+
+const getCalendarTokenHash = `select token_hash FROM calendar_token where user_id = $1`
+
+func (dq *DataQuery) GetCalendarTokenHash(ctx context.Context, userID string) (string, error) {
+	var tokenHash string
+	err := dq.conn().QueryRowContext(ctx, getCalendarTokenHash, userID).Scan(&tokenHash)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", ErrNotExist
+	}
+	return tokenHash, err
+}
+
+const insertAuditEntry = `insert into audit_log ("id", "subscription_id", "entity", "entity_id", "action",
+"actor", "old_value", "new_value", "request_id", "created_at") values ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)`
+
+func (dq *DataQuery) InsertAuditEntry(ctx context.Context, arg AuditEntry) error {
+	_, err := dq.conn().ExecContext(ctx, insertAuditEntry,
+		arg.ID,
+		arg.SubscriptionID,
+		arg.Entity,
+		arg.EntityID,
+		arg.Action,
+		arg.Actor,
+		nullString(string(arg.OldValue)),
+		nullString(string(arg.NewValue)),
+		nullString(arg.RequestID),
+		arg.CreatedAt,
+	)
+	return constraintError(err)
+}
+
+const getAuditLog = `select id, subscription_id, entity, entity_id, action, actor, old_value, new_value,
+request_id, created_at FROM audit_log where subscription_id = $1 order by created_at, id`
+
+func (dq *DataQuery) GetAuditLog(ctx context.Context, subscriptionID string) ([]AuditEntry, error) {
+	rows, err := dq.conn().QueryContext(ctx, getAuditLog, subscriptionID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []AuditEntry
+	for rows.Next() {
+		var i AuditEntry
+		var oldValue, newValue, requestID sql.NullString
+		if err := rows.Scan(
+			&i.ID,
+			&i.SubscriptionID,
+			&i.Entity,
+			&i.EntityID,
+			&i.Action,
+			&i.Actor,
+			&oldValue,
+			&newValue,
+			&requestID,
+			&i.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		if oldValue.Valid {
+			i.OldValue = json.RawMessage(oldValue.String)
+		}
+		if newValue.Valid {
+			i.NewValue = json.RawMessage(newValue.String)
+		}
+		i.RequestID = requestID.String
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+// C: the range queries select the deliveries with from <= expected_time < until, they use the
+// C: indexes of migration 00014
+const deliveriesInRangeColumns = `select dd.id, dd.subscription_dish_id, dd.status, dd.expected_time,
+dd.delivery_time, dd.note, sd.dish_id, s.id, s.user_id, s.time_zone, dd.sequence FROM dish_delivery dd
+join subscription_dish sd on sd.id = dd.subscription_dish_id
+join subscription s on s.id = sd.subscription_id `
+
+const getDeliveriesByUserInRange = deliveriesInRangeColumns +
+	`where s.user_id = $1 and dd.expected_time >= $2 and dd.expected_time < $3 order by dd.expected_time, dd.id`
+
+func (dq *DataQuery) GetDeliveriesByUserInRange(ctx context.Context, userID string, from, until time.Time) ([]DeliveryInRange, error) {
+	rows, err := dq.conn().QueryContext(ctx, getDeliveriesByUserInRange, userID, from, until)
+	if err != nil {
+		return nil, err
+	}
+	return scanDeliveriesInRange(rows)
+}
+
+const getDeliveriesBySubscriptionInRange = deliveriesInRangeColumns +
+	`where s.id = $1 and dd.expected_time >= $2 and dd.expected_time < $3 order by dd.expected_time, dd.id`
+
+func (dq *DataQuery) GetDeliveriesBySubscriptionInRange(ctx context.Context, subscriptionID string, from, until time.Time) ([]DeliveryInRange, error) {
+	rows, err := dq.conn().QueryContext(ctx, getDeliveriesBySubscriptionInRange, subscriptionID, from, until)
+	if err != nil {
+		return nil, err
+	}
+	return scanDeliveriesInRange(rows)
+}
+
+// C: dishes are owned by the playlist service, so the deliveries of a restaurant are those of its dishes
+const getDeliveriesByDishesInRange = deliveriesInRangeColumns +
+	`where sd.dish_id = any($1) and dd.expected_time >= $2 and dd.expected_time < $3 order by dd.expected_time, dd.id`
+
+func (dq *DataQuery) GetDeliveriesByDishesInRange(ctx context.Context, dishIDs []string, from, until time.Time) ([]DeliveryInRange, error) {
+	if len(dishIDs) == 0 {
+		return nil, nil
+	}
+	rows, err := dq.conn().QueryContext(ctx, getDeliveriesByDishesInRange, dishIDs, from, until)
+	if err != nil {
+		return nil, err
+	}
+	return scanDeliveriesInRange(rows)
+}
+
+// C: rowScanner is implemented by both *sql.Row and *sql.Rows
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+// C: end_date and term_days are NULL for open ended subscriptions and are scanned into zero values
+func scanSubscription(row rowScanner, i *Subscription) error {
+	var endDate sql.NullTime
+	var termDays sql.NullInt32
+	var instructions sql.NullString
+	err := row.Scan(
+		&i.ID,
+		&i.UserID,
+		&i.PlaylistID,
+		&i.Customized,
+		&i.Status,
+		&i.Frequency,
+		&i.StartDate,
+		&endDate,
+		&i.ReceiverName,
+		&i.ReceiverContact,
+		&i.AutoRenew,
+		&termDays,
+		&instructions,
+		&i.TimeZone,
+		&i.PostalCode,
+	)
+	i.EndDate = endDate.Time
+	i.TermDays = int(termDays.Int32)
+	i.DeliveryInstructions = instructions.String
+	return err
+}
+
+func scanSubscriptionDish(row rowScanner, i *SubscriptionDish) error {
+	var note sql.NullString
+	var removedAt sql.NullTime
+	var slotID sql.NullString
+	err := row.Scan(
+		&i.ID,
+		&i.DishID,
+		&i.SubscriptionID,
+		&i.ScheduleTime,
+		&i.Frequency,
+		&i.DishOptions,
+		&note,
+		&removedAt,
+		&slotID,
+	)
+	i.Note = note.String
+	i.RemovedAt = removedAt.Time
+	i.SlotID = slotID.String
+	return err
+}
+
+func scanBlackout(row rowScanner, i *Blackout) error {
+	var summary sql.NullString
+	var sourceUID sql.NullString
+	err := row.Scan(
+		&i.ID,
+		&i.Scope,
+		&i.ScopeID,
+		&i.StartDate,
+		&i.EndDate,
+		&i.Action,
+		&summary,
+		&sourceUID,
+		&i.CreatedBy,
+		&i.CreatedAt,
+	)
+	i.Summary = summary.String
+	i.SourceUID = sourceUID.String
+	return err
+}
+
+func scanBlackouts(rows *sql.Rows) ([]Blackout, error) {
+	defer rows.Close()
+	var items []Blackout
+	for rows.Next() {
+		var i Blackout
+		if err := scanBlackout(rows, &i); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+// C: nullable columns are written as NULL when the Go value is the zero time
+func nullTime(t time.Time) sql.NullTime {
+	return sql.NullTime{Time: t, Valid: !t.IsZero()}
+}
+
+func nullString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
+}
+
+func scanDeliveriesInRange(rows *sql.Rows) ([]DeliveryInRange, error) {
+	defer rows.Close()
+	var items []DeliveryInRange
+	for rows.Next() {
+		var i DeliveryInRange
+		var deliveryTime sql.NullTime
+		var note sql.NullString
+		if err := rows.Scan(
+			&i.ID,
+			&i.SubscriptionDishID,
+			&i.Status,
+			&i.ExpectedTime,
+			&deliveryTime,
+			&note,
+			&i.DishID,
+			&i.SubscriptionID,
+			&i.UserID,
+			&i.TimeZone,
+			&i.Sequence,
+		); err != nil {
+			return nil, err
+		}
+		i.DeliveryTime = deliveryTime.Time
+		i.Note = note.String
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
@@ -0,0 +1,110 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	_ "github.com/jackc/pgx/v4/stdlib"
+)
+
+// The benchmarks need a migrated database, e.g.
+//
+//	BENCH_DSN="host=localhost port=5432 user=postgres password=password dbname=subscription sslmode=disable" \
+//	  go test ./app/data -run '^$' -bench DishDeliveries
+//
+// Every iteration runs in a transaction which is rolled back, so the database is left as it was.
+
+var deliveryCounts = []int{30, 180, 900, 3600}
+
+func BenchmarkInsertDishDeliveries(b *testing.B) {
+	db := openBenchDB(b)
+	for _, n := range deliveryCounts {
+		b.Run(fmt.Sprintf("bulk/%d", n), func(b *testing.B) {
+			benchmarkDeliveryInsert(b, db, n, func(ctx context.Context, q *DataQuery, deliveries []DishDelivery) error {
+				_, err := q.InsertDishDeliveries(ctx, deliveries)
+				return err
+			})
+		})
+		b.Run(fmt.Sprintf("row_by_row/%d", n), func(b *testing.B) {
+			benchmarkDeliveryInsert(b, db, n, func(ctx context.Context, q *DataQuery, deliveries []DishDelivery) error {
+				for _, delivery := range deliveries {
+					if _, err := q.InsertDishDelivery(ctx, delivery); err != nil {
+						return err
+					}
+				}
+				return nil
+			})
+		})
+	}
+}
+
+func openBenchDB(b *testing.B) *sql.DB {
+	dsn := os.Getenv("BENCH_DSN")
+	if dsn == "" {
+		b.Skip("BENCH_DSN is not set")
+	}
+	db, err := sql.Open("pgx", dsn)
+	if err != nil {
+		b.Fatal(err)
+	}
+	if err := db.Ping(); err != nil {
+		b.Fatal(err)
+	}
+	b.Cleanup(func() { db.Close() })
+	return db
+}
+
+func benchmarkDeliveryInsert(b *testing.B, db *sql.DB, n int, insert func(context.Context, *DataQuery, []DishDelivery) error) {
+	ctx := context.Background()
+	start := time.Date(2023, 1, 2, 11, 30, 0, 0, time.UTC)
+
+	for i := 0; i < b.N; i++ {
+		b.StopTimer()
+		tx, err := db.BeginTx(ctx, nil)
+		if err != nil {
+			b.Fatal(err)
+		}
+		q := New(db).WithTx(tx)
+
+		// five dishes share the deliveries, like a daily subscription with five dishes
+		sub := Subscription{
+			ID: fmt.Sprintf("SubBench%d", i), UserID: "UBench", PlaylistID: "PBench", Status: "Active",
+			Frequency: "FREQ=DAILY", StartDate: start, ReceiverName: "Bench", ReceiverContact: "0", TimeZone: "UTC",
+		}
+		if _, err := q.InsertSubscription(ctx, sub); err != nil {
+			b.Fatal(err)
+		}
+		dishIDs := make([]string, 5)
+		for d := range dishIDs {
+			dishIDs[d] = fmt.Sprintf("SDishBench%d-%d", i, d)
+			dish := SubscriptionDish{ID: dishIDs[d], DishID: "Dish1", SubscriptionID: sub.ID, ScheduleTime: start, Frequency: sub.Frequency, DishOptions: "{}"}
+			if _, err := q.InsertDishes(ctx, dish); err != nil {
+				b.Fatal(err)
+			}
+		}
+
+		deliveries := make([]DishDelivery, n)
+		for d := range deliveries {
+			deliveries[d] = DishDelivery{
+				ID:                 fmt.Sprintf("DDBench%d-%d", i, d),
+				SubscriptionDishID: dishIDs[d%len(dishIDs)],
+				Status:             "Scheduled",
+				ExpectedTime:       start.AddDate(0, 0, d/len(dishIDs)),
+			}
+		}
+
+		b.StartTimer()
+		if err := insert(ctx, q, deliveries); err != nil {
+			b.Fatal(err)
+		}
+		b.StopTimer()
+
+		if err := tx.Rollback(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,1176 @@
+package data
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// MemoryRepository keeps the records of the subscription service in memory. It has the
+// semantics of DataQuery, including the keys and references of the database schema, and
+// is meant for tests and for running the service without a database. Records are lost
+// when the process exits.
+//
+// Writes are serialised. A transaction holds the repository for its whole length and
+// restores a copy of the records taken when it began if it fails.
+type MemoryRepository struct {
+	store *memoryStore
+	// inTx is set on the repository given to the function of ExecTx, which already holds the lock
+	inTx bool
+}
+
+type memoryStore struct {
+	mu    sync.Mutex
+	state *memoryState
+
+	// C: advisory locks are not records, they are neither copied nor restored by transactions
+	locksMu sync.Mutex
+	locks   map[int64]bool
+}
+
+func NewMemoryRepository() *MemoryRepository {
+	return &MemoryRepository{store: &memoryStore{state: newMemoryState(), locks: map[int64]bool{}}}
+}
+
+// memoryTable holds the rows of one table by key, in the order they were inserted.
+type memoryTable[T any] struct {
+	rows  map[string]T
+	order []string
+}
+
+func newMemoryTable[T any]() *memoryTable[T] {
+	return &memoryTable[T]{rows: map[string]T{}}
+}
+
+func (t *memoryTable[T]) get(key string) (T, bool) {
+	row, ok := t.rows[key]
+	return row, ok
+}
+
+func (t *memoryTable[T]) insert(key string, row T) error {
+	if _, ok := t.rows[key]; ok {
+		return fmt.Errorf("%w: key %s", ErrDuplicate, key)
+	}
+	t.put(key, row)
+	return nil
+}
+
+// put inserts the row or replaces the row with the same key
+func (t *memoryTable[T]) put(key string, row T) {
+	if _, ok := t.rows[key]; !ok {
+		t.order = append(t.order, key)
+	}
+	t.rows[key] = row
+}
+
+func (t *memoryTable[T]) delete(key string) bool {
+	if _, ok := t.rows[key]; !ok {
+		return false
+	}
+	delete(t.rows, key)
+	for i, k := range t.order {
+		if k == key {
+			t.order = append(t.order[:i], t.order[i+1:]...)
+			break
+		}
+	}
+	return true
+}
+
+func (t *memoryTable[T]) all() []T {
+	rows := make([]T, 0, len(t.order))
+	for _, key := range t.order {
+		rows = append(rows, t.rows[key])
+	}
+	return rows
+}
+
+func (t *memoryTable[T]) clone() *memoryTable[T] {
+	c := &memoryTable[T]{rows: make(map[string]T, len(t.rows)), order: append([]string(nil), t.order...)}
+	for key, row := range t.rows {
+		c.rows[key] = row
+	}
+	return c
+}
+
+type calendarToken struct {
+	userID    string
+	tokenHash string
+	createdAt time.Time
+}
+
+type memoryState struct {
+	subscriptions       *memoryTable[Subscription]
+	transitions         *memoryTable[StatusTransition]
+	receiverHistory     *memoryTable[ReceiverHistory]
+	dishes              *memoryTable[SubscriptionDish]
+	deliveries          *memoryTable[DishDelivery]
+	pauses              *memoryTable[SubscriptionPause]
+	cancellations       *memoryTable[SubscriptionCancellation]
+	cancelledDeliveries *memoryTable[CancelledDelivery]
+	blackouts           *memoryTable[Blackout]
+	slots               *memoryTable[DeliverySlot]
+	reservations        *memoryTable[SlotReservation]
+	calendarTokens      *memoryTable[calendarToken]
+	auditLog            *memoryTable[AuditEntry]
+	deliverySequences   *memoryTable[int]
+}
+
+func newMemoryState() *memoryState {
+	return &memoryState{
+		subscriptions:       newMemoryTable[Subscription](),
+		transitions:         newMemoryTable[StatusTransition](),
+		receiverHistory:     newMemoryTable[ReceiverHistory](),
+		dishes:              newMemoryTable[SubscriptionDish](),
+		deliveries:          newMemoryTable[DishDelivery](),
+		pauses:              newMemoryTable[SubscriptionPause](),
+		cancellations:       newMemoryTable[SubscriptionCancellation](),
+		cancelledDeliveries: newMemoryTable[CancelledDelivery](),
+		blackouts:           newMemoryTable[Blackout](),
+		slots:               newMemoryTable[DeliverySlot](),
+		reservations:        newMemoryTable[SlotReservation](),
+		calendarTokens:      newMemoryTable[calendarToken](),
+		auditLog:            newMemoryTable[AuditEntry](),
+		deliverySequences:   newMemoryTable[int](),
+	}
+}
+
+func (s *memoryState) clone() *memoryState {
+	return &memoryState{
+		subscriptions:       s.subscriptions.clone(),
+		transitions:         s.transitions.clone(),
+		receiverHistory:     s.receiverHistory.clone(),
+		dishes:              s.dishes.clone(),
+		deliveries:          s.deliveries.clone(),
+		pauses:              s.pauses.clone(),
+		cancellations:       s.cancellations.clone(),
+		cancelledDeliveries: s.cancelledDeliveries.clone(),
+		blackouts:           s.blackouts.clone(),
+		slots:               s.slots.clone(),
+		reservations:        s.reservations.clone(),
+		calendarTokens:      s.calendarTokens.clone(),
+		auditLog:            s.auditLog.clone(),
+		deliverySequences:   s.deliverySequences.clone(),
+	}
+}
+
+// lock takes the repository for one call and returns the function releasing it. Inside a
+// transaction the lock is already held.
+func (r *MemoryRepository) lock() func() {
+	if r.inTx {
+		return func() {}
+	}
+	r.store.mu.Lock()
+	return r.store.mu.Unlock
+}
+
+// ExecTx runs fn with the repository held until fn returns. The records are restored as
+// they were before fn when it returns an error or panics. The repository given to fn must
+// not be used after fn returned.
+func (r *MemoryRepository) ExecTx(ctx context.Context, fn func(SubscriptionRepository) error) error {
+	if r.inTx {
+		return fn(r)
+	}
+
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+	saved := r.store.state.clone()
+	committed := false
+	defer func() {
+		if !committed {
+			r.store.state = saved
+		}
+	}()
+
+	if err := fn(&MemoryRepository{store: r.store, inTx: true}); err != nil {
+		return err
+	}
+	committed = true
+	return nil
+}
+
+// C: like a session level advisory lock, the lock is not taken within a transaction
+func (r *MemoryRepository) TryAdvisoryLock(ctx context.Context, key int64) (release func() error, ok bool, err error) {
+	if r.inTx {
+		return nil, false, ErrInTransaction
+	}
+	r.store.locksMu.Lock()
+	defer r.store.locksMu.Unlock()
+	if r.store.locks[key] {
+		return nil, false, nil
+	}
+	r.store.locks[key] = true
+
+	release = func() error {
+		r.store.locksMu.Lock()
+		defer r.store.locksMu.Unlock()
+		delete(r.store.locks, key)
+		return nil
+	}
+	return release, true, nil
+}
+
+// memoryDate keeps the calendar date of t, as a date column does
+func memoryDate(t time.Time) time.Time {
+	y, m, d := t.Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+}
+
+func missing(table, id string) error {
+	return fmt.Errorf("%w: %s %s", ErrNotExist, table, id)
+}
+
+func (r *MemoryRepository) InsertSubscription(ctx context.Context, arg Subscription) (Subscription, error) {
+	defer r.lock()()
+	arg.StartDate = memoryDate(arg.StartDate)
+	if !arg.EndDate.IsZero() {
+		arg.EndDate = memoryDate(arg.EndDate)
+	}
+	if err := r.store.state.subscriptions.insert(arg.ID, arg); err != nil {
+		return Subscription{}, err
+	}
+	return arg, nil
+}
+
+func (r *MemoryRepository) GetSubscriptionByID(ctx context.Context, id string) (Subscription, error) {
+	defer r.lock()()
+	sub, ok := r.store.state.subscriptions.get(id)
+	if !ok {
+		return sub, ErrNotExist
+	}
+	return sub, nil
+}
+
+func (r *MemoryRepository) selectSubscriptions(keep func(Subscription) bool) []Subscription {
+	var items []Subscription
+	for _, sub := range r.store.state.subscriptions.all() {
+		if keep(sub) {
+			items = append(items, sub)
+		}
+	}
+	return items
+}
+
+func (r *MemoryRepository) GetSubscriptionByUserID(ctx context.Context, userID string) ([]Subscription, error) {
+	defer r.lock()()
+	return r.selectSubscriptions(func(sub Subscription) bool {
+		return sub.UserID == userID
+	}), nil
+}
+
+func (r *MemoryRepository) GetSubscriptionsEndedBefore(ctx context.Context, status string, before time.Time) ([]Subscription, error) {
+	defer r.lock()()
+	return r.selectSubscriptions(func(sub Subscription) bool {
+		return sub.Status == status && !sub.EndDate.IsZero() && sub.EndDate.Before(before)
+	}), nil
+}
+
+func (r *MemoryRepository) GetOpenEndedSubscriptions(ctx context.Context, status string) ([]Subscription, error) {
+	defer r.lock()()
+	return r.selectSubscriptions(func(sub Subscription) bool {
+		return sub.Status == status && sub.EndDate.IsZero()
+	}), nil
+}
+
+func (r *MemoryRepository) GetRenewableSubscriptions(ctx context.Context, status string, before time.Time) ([]Subscription, error) {
+	defer r.lock()()
+	return r.selectSubscriptions(func(sub Subscription) bool {
+		return sub.Status == status && sub.AutoRenew && !sub.EndDate.IsZero() && sub.EndDate.Before(before)
+	}), nil
+}
+
+func (r *MemoryRepository) GetSubscriptionsPage(ctx context.Context, q SubscriptionListQuery) ([]Subscription, error) {
+	statuses := map[string]bool{}
+	for _, status := range q.Statuses {
+		statuses[status] = true
+	}
+	defer r.lock()()
+	subs := r.selectSubscriptions(func(sub Subscription) bool {
+		return sub.UserID == q.UserID &&
+			(len(statuses) == 0 || statuses[sub.Status]) &&
+			(q.From.IsZero() || sub.EndDate.IsZero() || !sub.EndDate.Before(q.From)) &&
+			(q.To.IsZero() || !sub.StartDate.After(q.To)) &&
+			(q.PlaylistID == "" || sub.PlaylistID == q.PlaylistID) &&
+			(q.Customized == nil || sub.Customized == *q.Customized)
+	})
+
+	// C: like the query, the rows before a cursor are read backwards from it
+	descending := q.Descending != (q.Before != nil)
+	before := func(a, b SubscriptionKey) bool {
+		if descending {
+			return b.precedes(a, q.SortBy)
+		}
+		return a.precedes(b, q.SortBy)
+	}
+	sort.Slice(subs, func(i, j int) bool {
+		return before(subscriptionKey(subs[i]), subscriptionKey(subs[j]))
+	})
+
+	cursor := q.After
+	if q.Before != nil {
+		cursor = q.Before
+	}
+	var items []Subscription
+	for _, sub := range subs {
+		if len(items) == q.Limit {
+			break
+		}
+		if cursor == nil || before(*cursor, subscriptionKey(sub)) {
+			items = append(items, sub)
+		}
+	}
+	if q.Before != nil {
+		reverseSubscriptions(items)
+	}
+	return items, nil
+}
+
+func subscriptionKey(sub Subscription) SubscriptionKey {
+	return SubscriptionKey{StartDate: sub.StartDate, Status: sub.Status, ID: sub.ID}
+}
+
+// precedes tells whether k comes before other in ascending order of sortBy
+func (k SubscriptionKey) precedes(other SubscriptionKey, sortBy string) bool {
+	if sortBy == SortByStatus && k.Status != other.Status {
+		return k.Status < other.Status
+	}
+	if !k.StartDate.Equal(other.StartDate) {
+		return k.StartDate.Before(other.StartDate)
+	}
+	return k.ID < other.ID
+}
+
+func (r *MemoryRepository) ChangeSubscriptionStatus(ctx context.Context, arg StatusTransition) (Subscription, error) {
+	defer r.lock()()
+	s := r.store.state
+	sub, ok := s.subscriptions.get(arg.SubscriptionID)
+	if !ok || sub.Status != arg.FromStatus {
+		return Subscription{}, ErrUpdateFailed
+	}
+	if err := s.transitions.insert(arg.ID, arg); err != nil {
+		return Subscription{}, err
+	}
+	sub.Status = arg.ToStatus
+	s.subscriptions.put(sub.ID, sub)
+	return sub, nil
+}
+
+// updateSubscription applies change to the subscription and returns the updated row
+func (r *MemoryRepository) updateSubscription(subscriptionID string, change func(*Subscription)) (Subscription, error) {
+	defer r.lock()()
+	sub, ok := r.store.state.subscriptions.get(subscriptionID)
+	if !ok {
+		return Subscription{}, ErrNotExist
+	}
+	change(&sub)
+	r.store.state.subscriptions.put(sub.ID, sub)
+	return sub, nil
+}
+
+func (r *MemoryRepository) UpdateSubscriptionEndDate(ctx context.Context, endDate time.Time, subscriptionID string) (Subscription, error) {
+	return r.updateSubscription(subscriptionID, func(sub *Subscription) {
+		sub.EndDate = time.Time{}
+		if !endDate.IsZero() {
+			sub.EndDate = memoryDate(endDate)
+		}
+	})
+}
+
+func (r *MemoryRepository) RenewSubscription(ctx context.Context, status string, previousEndDate, endDate time.Time, subscriptionID string) (Subscription, error) {
+	defer r.lock()()
+	sub, ok := r.store.state.subscriptions.get(subscriptionID)
+	if !ok || sub.Status != status || !sub.AutoRenew || !sub.EndDate.Equal(memoryDate(previousEndDate)) {
+		return Subscription{}, ErrUpdateFailed
+	}
+	sub.EndDate = memoryDate(endDate)
+	r.store.state.subscriptions.put(sub.ID, sub)
+	return sub, nil
+}
+
+func (r *MemoryRepository) UpdateSubscriptionTerm(ctx context.Context, startDate, endDate time.Time, termDays int, subscriptionID string) (Subscription, error) {
+	return r.updateSubscription(subscriptionID, func(sub *Subscription) {
+		sub.StartDate = memoryDate(startDate)
+		sub.EndDate = time.Time{}
+		if !endDate.IsZero() {
+			sub.EndDate = memoryDate(endDate)
+		}
+		sub.TermDays = termDays
+	})
+}
+
+func (r *MemoryRepository) UpdateSubscriptionAutoRenew(ctx context.Context, autoRenew bool, subscriptionID string) (Subscription, error) {
+	return r.updateSubscription(subscriptionID, func(sub *Subscription) {
+		sub.AutoRenew = autoRenew
+	})
+}
+
+func (r *MemoryRepository) ChangeSubscriptionReceiver(ctx context.Context, subscriptionID string, receiver ReceiverDetails, history ReceiverHistory) (Subscription, error) {
+	defer r.lock()()
+	s := r.store.state
+	sub, ok := s.subscriptions.get(subscriptionID)
+	if !ok {
+		return Subscription{}, ErrNotExist
+	}
+
+	history.SubscriptionID = sub.ID
+	history.ReceiverName = sub.ReceiverName
+	history.ReceiverContact = sub.ReceiverContact
+	history.DeliveryInstructions = sub.DeliveryInstructions
+	if err := s.receiverHistory.insert(history.ID, history); err != nil {
+		return Subscription{}, err
+	}
+
+	sub.ReceiverName = receiver.ReceiverName
+	sub.ReceiverContact = receiver.ReceiverContact
+	sub.DeliveryInstructions = receiver.DeliveryInstructions
+	s.subscriptions.put(sub.ID, sub)
+	return sub, nil
+}
+
+func (r *MemoryRepository) GetReceiverHistory(ctx context.Context, subscriptionID string) ([]ReceiverHistory, error) {
+	return r.GetReceiverHistories(ctx, []string{subscriptionID})
+}
+
+func (r *MemoryRepository) GetReceiverHistories(ctx context.Context, subscriptionIDs []string) ([]ReceiverHistory, error) {
+	subscriptions := map[string]bool{}
+	for _, id := range subscriptionIDs {
+		subscriptions[id] = true
+	}
+	defer r.lock()()
+	var items []ReceiverHistory
+	for _, history := range r.store.state.receiverHistory.all() {
+		if subscriptions[history.SubscriptionID] {
+			items = append(items, history)
+		}
+	}
+	sort.SliceStable(items, func(i, j int) bool {
+		a, b := items[i], items[j]
+		if a.SubscriptionID != b.SubscriptionID {
+			return a.SubscriptionID < b.SubscriptionID
+		}
+		if !a.ValidUntil.Equal(b.ValidUntil) {
+			return a.ValidUntil.Before(b.ValidUntil)
+		}
+		return a.ChangedAt.Before(b.ChangedAt)
+	})
+	return items, nil
+}
+
+func (r *MemoryRepository) InsertDishes(ctx context.Context, arg SubscriptionDish) (string, error) {
+	defer r.lock()()
+	s := r.store.state
+	if _, ok := s.subscriptions.get(arg.SubscriptionID); !ok {
+		return "", missing("subscription", arg.SubscriptionID)
+	}
+	if _, ok := s.slots.get(arg.SlotID); arg.SlotID != "" && !ok {
+		return "", missing("delivery slot", arg.SlotID)
+	}
+	arg.RemovedAt = time.Time{}
+	if err := s.dishes.insert(arg.ID, arg); err != nil {
+		return "", err
+	}
+	return arg.ID, nil
+}
+
+func (r *MemoryRepository) GetDishBySubscriptionID(ctx context.Context, subscriptionID string) ([]SubscriptionDish, error) {
+	defer r.lock()()
+	var items []SubscriptionDish
+	for _, dish := range r.store.state.dishes.all() {
+		if dish.SubscriptionID == subscriptionID && dish.RemovedAt.IsZero() {
+			items = append(items, dish)
+		}
+	}
+	return items, nil
+}
+
+func (r *MemoryRepository) GetDishesBySubscriptionIDs(ctx context.Context, subscriptionIDs []string) ([]SubscriptionDish, error) {
+	subscriptions := map[string]bool{}
+	for _, id := range subscriptionIDs {
+		subscriptions[id] = true
+	}
+	defer r.lock()()
+	var items []SubscriptionDish
+	for _, dish := range r.store.state.dishes.all() {
+		if subscriptions[dish.SubscriptionID] && dish.RemovedAt.IsZero() {
+			items = append(items, dish)
+		}
+	}
+	sort.SliceStable(items, func(i, j int) bool {
+		a, b := items[i], items[j]
+		if a.SubscriptionID != b.SubscriptionID {
+			return a.SubscriptionID < b.SubscriptionID
+		}
+		if !a.ScheduleTime.Equal(b.ScheduleTime) {
+			return a.ScheduleTime.Before(b.ScheduleTime)
+		}
+		return a.ID < b.ID
+	})
+	return items, nil
+}
+
+func (r *MemoryRepository) GetSubscriptionDishByID(ctx context.Context, id string) (SubscriptionDish, error) {
+	defer r.lock()()
+	dish, ok := r.store.state.dishes.get(id)
+	if !ok {
+		return dish, ErrNotExist
+	}
+	return dish, nil
+}
+
+func (r *MemoryRepository) GetDishTimeZone(ctx context.Context, subscriptionDishID string) (string, error) {
+	defer r.lock()()
+	s := r.store.state
+	dish, ok := s.dishes.get(subscriptionDishID)
+	if !ok {
+		return "", ErrNotExist
+	}
+	sub, ok := s.subscriptions.get(dish.SubscriptionID)
+	if !ok {
+		return "", ErrNotExist
+	}
+	return sub.TimeZone, nil
+}
+
+func (r *MemoryRepository) UpdateSubscriptionDish(ctx context.Context, arg SubscriptionDish) (SubscriptionDish, error) {
+	defer r.lock()()
+	dish, ok := r.store.state.dishes.get(arg.ID)
+	if !ok || !dish.RemovedAt.IsZero() {
+		return SubscriptionDish{}, ErrUpdateFailed
+	}
+	dish.Frequency = arg.Frequency
+	dish.DishOptions = arg.DishOptions
+	dish.Note = arg.Note
+	r.store.state.dishes.put(dish.ID, dish)
+	return dish, nil
+}
+
+func (r *MemoryRepository) RemoveSubscriptionDish(ctx context.Context, removedAt time.Time, subscriptionDishID string) (SubscriptionDish, error) {
+	defer r.lock()()
+	dish, ok := r.store.state.dishes.get(subscriptionDishID)
+	if !ok || !dish.RemovedAt.IsZero() {
+		return SubscriptionDish{}, ErrDeleteFailed
+	}
+	dish.RemovedAt = removedAt
+	r.store.state.dishes.put(dish.ID, dish)
+	return dish, nil
+}
+
+// C: like the insert statements, the delivery time of a new delivery is not kept
+func (r *MemoryRepository) insertDishDelivery(arg DishDelivery) error {
+	s := r.store.state
+	if _, ok := s.dishes.get(arg.SubscriptionDishID); !ok {
+		return missing("subscription dish", arg.SubscriptionDishID)
+	}
+	arg.DeliveryTime = time.Time{}
+	return s.deliveries.insert(arg.ID, arg)
+}
+
+func (r *MemoryRepository) InsertDishDelivery(ctx context.Context, arg DishDelivery) (string, error) {
+	defer r.lock()()
+	if err := r.insertDishDelivery(arg); err != nil {
+		return "", err
+	}
+	return arg.ID, nil
+}
+
+func (r *MemoryRepository) InsertDishDeliveries(ctx context.Context, deliveries []DishDelivery) ([]string, error) {
+	if len(deliveries) == 0 {
+		return nil, nil
+	}
+	ids := make([]string, 0, len(deliveries))
+	err := r.ExecTx(ctx, func(repo SubscriptionRepository) error {
+		tx := repo.(*MemoryRepository)
+		for _, delivery := range deliveries {
+			if err := tx.insertDishDelivery(delivery); err != nil {
+				return err
+			}
+			ids = append(ids, delivery.ID)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
+
+func (r *MemoryRepository) GetDishDeliveryCondition(ctx context.Context, subscriptionDishID string) ([]DishDelivery, error) {
+	defer r.lock()()
+	var items []DishDelivery
+	for _, delivery := range r.store.state.deliveries.all() {
+		if delivery.SubscriptionDishID == subscriptionDishID {
+			items = append(items, delivery)
+		}
+	}
+	sort.SliceStable(items, func(i, j int) bool {
+		return items[i].ExpectedTime.Before(items[j].ExpectedTime)
+	})
+	return items, nil
+}
+
+func (r *MemoryRepository) GetDishDeliveryByID(ctx context.Context, id string) (DishDelivery, error) {
+	defer r.lock()()
+	delivery, ok := r.store.state.deliveries.get(id)
+	if !ok {
+		return delivery, ErrNotExist
+	}
+	return delivery, nil
+}
+
+func (r *MemoryRepository) GetLatestDeliveryTime(ctx context.Context, subscriptionDishID string) (time.Time, error) {
+	defer r.lock()()
+	var latest time.Time
+	for _, delivery := range r.store.state.deliveries.all() {
+		if delivery.SubscriptionDishID == subscriptionDishID && delivery.ExpectedTime.After(latest) {
+			latest = delivery.ExpectedTime
+		}
+	}
+	return latest, nil
+}
+
+// deliveriesInRange joins the deliveries expected in [from, until) with their dish and
+// subscription, in the order of the range queries
+func (r *MemoryRepository) deliveriesInRange(from, until time.Time, keep func(SubscriptionDish, Subscription) bool) []DeliveryInRange {
+	s := r.store.state
+	var items []DeliveryInRange
+	for _, delivery := range s.deliveries.all() {
+		if delivery.ExpectedTime.Before(from) || !delivery.ExpectedTime.Before(until) {
+			continue
+		}
+		dish, _ := s.dishes.get(delivery.SubscriptionDishID)
+		sub, _ := s.subscriptions.get(dish.SubscriptionID)
+		if !keep(dish, sub) {
+			continue
+		}
+		sequence, _ := s.deliverySequences.get(delivery.ID)
+		items = append(items, DeliveryInRange{
+			DishDelivery:   delivery,
+			DishID:         dish.DishID,
+			SubscriptionID: sub.ID,
+			UserID:         sub.UserID,
+			TimeZone:       sub.TimeZone,
+			Sequence:       sequence,
+		})
+	}
+	sort.Slice(items, func(i, j int) bool {
+		if !items[i].ExpectedTime.Equal(items[j].ExpectedTime) {
+			return items[i].ExpectedTime.Before(items[j].ExpectedTime)
+		}
+		return items[i].ID < items[j].ID
+	})
+	return items
+}
+
+func (r *MemoryRepository) GetDeliveriesByUserInRange(ctx context.Context, userID string, from, until time.Time) ([]DeliveryInRange, error) {
+	defer r.lock()()
+	return r.deliveriesInRange(from, until, func(_ SubscriptionDish, sub Subscription) bool {
+		return sub.UserID == userID
+	}), nil
+}
+
+func (r *MemoryRepository) GetDeliveriesBySubscriptionInRange(ctx context.Context, subscriptionID string, from, until time.Time) ([]DeliveryInRange, error) {
+	defer r.lock()()
+	return r.deliveriesInRange(from, until, func(_ SubscriptionDish, sub Subscription) bool {
+		return sub.ID == subscriptionID
+	}), nil
+}
+
+func (r *MemoryRepository) GetDeliveriesByDishesInRange(ctx context.Context, dishIDs []string, from, until time.Time) ([]DeliveryInRange, error) {
+	if len(dishIDs) == 0 {
+		return nil, nil
+	}
+	wanted := map[string]bool{}
+	for _, id := range dishIDs {
+		wanted[id] = true
+	}
+	defer r.lock()()
+	return r.deliveriesInRange(from, until, func(dish SubscriptionDish, _ Subscription) bool {
+		return wanted[dish.DishID]
+	}), nil
+}
+
+func (r *MemoryRepository) UpdateDishDelivery(ctx context.Context, arg DishDelivery) (DishDelivery, error) {
+	defer r.lock()()
+	delivery, ok := r.store.state.deliveries.get(arg.ID)
+	if !ok || !delivery.DeliveryTime.IsZero() {
+		return DishDelivery{}, ErrUpdateFailed
+	}
+	before := delivery
+	delivery.Status = arg.Status
+	delivery.ExpectedTime = arg.ExpectedTime
+	delivery.Note = arg.Note
+	r.store.state.putDelivery(before, delivery)
+	return delivery, nil
+}
+
+func (r *MemoryRepository) UpdateDeliveryProgress(ctx context.Context, fromStatus string, arg DishDelivery) (DishDelivery, error) {
+	defer r.lock()()
+	delivery, ok := r.store.state.deliveries.get(arg.ID)
+	if !ok || delivery.Status != fromStatus {
+		return DishDelivery{}, ErrUpdateFailed
+	}
+	before := delivery
+	delivery.Status = arg.Status
+	delivery.DeliveryTime = arg.DeliveryTime
+	delivery.Note = arg.Note
+	r.store.state.putDelivery(before, delivery)
+	return delivery, nil
+}
+
+// C: the status updates return the deliveries without their delivery time, as the queries do
+func (r *MemoryRepository) ChangeDishDeliveryStatusByID(ctx context.Context, fromStatus, toStatus string, deliveryID string) (DishDelivery, error) {
+	defer r.lock()()
+	delivery, ok := r.store.state.deliveries.get(deliveryID)
+	if !ok || delivery.Status != fromStatus {
+		return DishDelivery{}, ErrUpdateFailed
+	}
+	before := delivery
+	delivery.Status = toStatus
+	r.store.state.putDelivery(before, delivery)
+	delivery.DeliveryTime = time.Time{}
+	return delivery, nil
+}
+
+// putDelivery stores a changed delivery and counts the change in its sequence, like the
+// trigger of the dish_delivery table does
+func (s *memoryState) putDelivery(before, after DishDelivery) {
+	if !after.ExpectedTime.Equal(before.ExpectedTime) || after.Status != before.Status || after.Note != before.Note {
+		sequence, _ := s.deliverySequences.get(after.ID)
+		s.deliverySequences.put(after.ID, sequence+1)
+	}
+	s.deliveries.put(after.ID, after)
+}
+
+// updateDeliveries sets the status of the deliveries kept by keep and returns them
+func (r *MemoryRepository) updateDeliveries(toStatus string, keep func(DishDelivery) bool) []DishDelivery {
+	var updated []DishDelivery
+	for _, delivery := range r.store.state.deliveries.all() {
+		if !keep(delivery) {
+			continue
+		}
+		before := delivery
+		delivery.Status = toStatus
+		r.store.state.putDelivery(before, delivery)
+		delivery.DeliveryTime = time.Time{}
+		updated = append(updated, delivery)
+	}
+	return updated
+}
+
+func (r *MemoryRepository) ChangeDishDeliveryStatus(ctx context.Context, toStatus string, subscriptionDishID string) ([]DishDelivery, error) {
+	defer r.lock()()
+	return r.updateDeliveries(toStatus, func(delivery DishDelivery) bool {
+		return delivery.SubscriptionDishID == subscriptionDishID && delivery.DeliveryTime.IsZero()
+	}), nil
+}
+
+func (r *MemoryRepository) ChangeDeliveryStatusInWindow(ctx context.Context, arg DeliveryWindowUpdate) ([]DishDelivery, error) {
+	defer r.lock()()
+	s := r.store.state
+	return r.updateDeliveries(arg.ToStatus, func(delivery DishDelivery) bool {
+		dish, _ := s.dishes.get(delivery.SubscriptionDishID)
+		return dish.SubscriptionID == arg.SubscriptionID &&
+			delivery.Status == arg.FromStatus &&
+			!delivery.ExpectedTime.Before(arg.From) &&
+			(arg.Until.IsZero() || delivery.ExpectedTime.Before(arg.Until))
+	}), nil
+}
+
+// C: the cancelled deliveries referring to a deleted delivery are deleted with it
+func (r *MemoryRepository) DeleteDishDeliveriesFrom(ctx context.Context, subscriptionDishID string, status string, from time.Time) ([]DishDelivery, error) {
+	defer r.lock()()
+	return r.deleteDeliveries(func(delivery DishDelivery) bool {
+		return delivery.SubscriptionDishID == subscriptionDishID && delivery.Status == status &&
+			!delivery.ExpectedTime.Before(from) && delivery.DeliveryTime.IsZero()
+	}), nil
+}
+
+func (r *MemoryRepository) DeleteDishDeliveries(ctx context.Context, status string, deliveryIDs []string) ([]DishDelivery, error) {
+	ids := map[string]bool{}
+	for _, id := range deliveryIDs {
+		ids[id] = true
+	}
+	defer r.lock()()
+	return r.deleteDeliveries(func(delivery DishDelivery) bool {
+		return ids[delivery.ID] && delivery.Status == status && delivery.DeliveryTime.IsZero()
+	}), nil
+}
+
+// deleteDeliveries deletes the deliveries kept by the filter together with their
+// cancellation records, as the foreign key does in the database.
+func (r *MemoryRepository) deleteDeliveries(keep func(DishDelivery) bool) []DishDelivery {
+	s := r.store.state
+	var items []DishDelivery
+	deleted := map[string]bool{}
+	for _, delivery := range s.deliveries.all() {
+		if keep(delivery) {
+			s.deliveries.delete(delivery.ID)
+			s.deliverySequences.delete(delivery.ID)
+			deleted[delivery.ID] = true
+			items = append(items, delivery)
+		}
+	}
+	for _, cancelled := range s.cancelledDeliveries.all() {
+		if deleted[cancelled.DishDeliveryID] {
+			s.cancelledDeliveries.delete(cancelledDeliveryKey(cancelled))
+		}
+	}
+	return items
+}
+
+func (r *MemoryRepository) InsertSubscriptionPause(ctx context.Context, arg SubscriptionPause) error {
+	defer r.lock()()
+	s := r.store.state
+	if _, ok := s.subscriptions.get(arg.SubscriptionID); !ok {
+		return missing("subscription", arg.SubscriptionID)
+	}
+	arg.ResumedAt = time.Time{}
+	arg.ResumePolicy = ""
+	return s.pauses.insert(arg.ID, arg)
+}
+
+func (r *MemoryRepository) GetOpenSubscriptionPause(ctx context.Context, subscriptionID string) (SubscriptionPause, error) {
+	defer r.lock()()
+	var open SubscriptionPause
+	found := false
+	for _, pause := range r.store.state.pauses.all() {
+		if pause.SubscriptionID != subscriptionID || !pause.ResumedAt.IsZero() {
+			continue
+		}
+		if !found || pause.PauseFrom.After(open.PauseFrom) {
+			open, found = pause, true
+		}
+	}
+	if !found {
+		return open, ErrNotExist
+	}
+	return open, nil
+}
+
+func (r *MemoryRepository) GetPausesStartingBefore(ctx context.Context, subscriptionStatus string, before time.Time) ([]SubscriptionPause, error) {
+	defer r.lock()()
+	pauses := r.selectOpenPauses(subscriptionStatus, func(pause SubscriptionPause) bool {
+		return !pause.PauseFrom.After(before)
+	})
+	sort.SliceStable(pauses, func(i, j int) bool { return pauses[i].PauseFrom.Before(pauses[j].PauseFrom) })
+	return pauses, nil
+}
+
+func (r *MemoryRepository) GetPausesEndingBefore(ctx context.Context, subscriptionStatus string, before time.Time) ([]SubscriptionPause, error) {
+	defer r.lock()()
+	pauses := r.selectOpenPauses(subscriptionStatus, func(pause SubscriptionPause) bool {
+		return !pause.PauseUntil.IsZero() && !pause.PauseUntil.After(before)
+	})
+	sort.SliceStable(pauses, func(i, j int) bool { return pauses[i].PauseUntil.Before(pauses[j].PauseUntil) })
+	return pauses, nil
+}
+
+// selectOpenPauses returns the open pauses matching keep of subscriptions in the given status
+func (r *MemoryRepository) selectOpenPauses(subscriptionStatus string, keep func(SubscriptionPause) bool) []SubscriptionPause {
+	s := r.store.state
+	var pauses []SubscriptionPause
+	for _, pause := range s.pauses.all() {
+		sub, ok := s.subscriptions.get(pause.SubscriptionID)
+		if !ok || sub.Status != subscriptionStatus || !pause.ResumedAt.IsZero() || !keep(pause) {
+			continue
+		}
+		pauses = append(pauses, pause)
+	}
+	return pauses
+}
+
+func (r *MemoryRepository) CloseSubscriptionPause(ctx context.Context, resumedAt time.Time, policy string, pauseID string) error {
+	defer r.lock()()
+	pause, ok := r.store.state.pauses.get(pauseID)
+	if !ok {
+		return ErrUpdateFailed
+	}
+	pause.ResumedAt = resumedAt
+	pause.ResumePolicy = policy
+	r.store.state.pauses.put(pause.ID, pause)
+	return nil
+}
+
+func (r *MemoryRepository) InsertSubscriptionCancellation(ctx context.Context, arg SubscriptionCancellation) error {
+	defer r.lock()()
+	s := r.store.state
+	if _, ok := s.subscriptions.get(arg.SubscriptionID); !ok {
+		return missing("subscription", arg.SubscriptionID)
+	}
+	arg.UndoneAt = time.Time{}
+	return s.cancellations.insert(arg.ID, arg)
+}
+
+func cancelledDeliveryKey(arg CancelledDelivery) string {
+	return arg.CancellationID + "|" + arg.DishDeliveryID
+}
+
+func (r *MemoryRepository) InsertCancelledDelivery(ctx context.Context, arg CancelledDelivery) error {
+	defer r.lock()()
+	s := r.store.state
+	if _, ok := s.cancellations.get(arg.CancellationID); !ok {
+		return missing("cancellation", arg.CancellationID)
+	}
+	if _, ok := s.deliveries.get(arg.DishDeliveryID); !ok {
+		return missing("dish delivery", arg.DishDeliveryID)
+	}
+	return s.cancelledDeliveries.insert(cancelledDeliveryKey(arg), arg)
+}
+
+func (r *MemoryRepository) GetLatestSubscriptionCancellation(ctx context.Context, subscriptionID string) (SubscriptionCancellation, error) {
+	defer r.lock()()
+	var latest SubscriptionCancellation
+	found := false
+	for _, cancellation := range r.store.state.cancellations.all() {
+		if cancellation.SubscriptionID != subscriptionID || !cancellation.UndoneAt.IsZero() {
+			continue
+		}
+		if !found || cancellation.CancelledAt.After(latest.CancelledAt) {
+			latest, found = cancellation, true
+		}
+	}
+	if !found {
+		return latest, ErrNotExist
+	}
+	return latest, nil
+}
+
+func (r *MemoryRepository) GetCancelledDeliveries(ctx context.Context, cancellationID string) ([]CancelledDelivery, error) {
+	defer r.lock()()
+	var items []CancelledDelivery
+	for _, cancelled := range r.store.state.cancelledDeliveries.all() {
+		if cancelled.CancellationID == cancellationID {
+			items = append(items, cancelled)
+		}
+	}
+	return items, nil
+}
+
+func (r *MemoryRepository) MarkCancellationUndone(ctx context.Context, undoneAt time.Time, cancellationID string) error {
+	defer r.lock()()
+	cancellation, ok := r.store.state.cancellations.get(cancellationID)
+	if !ok || !cancellation.UndoneAt.IsZero() {
+		return ErrUpdateFailed
+	}
+	cancellation.UndoneAt = undoneAt
+	r.store.state.cancellations.put(cancellation.ID, cancellation)
+	return nil
+}
+
+// sourceBlackout finds the blackout imported from the same calendar event. Like the unique
+// index, blackouts without a source UID never clash.
+func (r *MemoryRepository) sourceBlackout(arg Blackout) (Blackout, bool) {
+	if arg.SourceUID == "" {
+		return Blackout{}, false
+	}
+	for _, blackout := range r.store.state.blackouts.all() {
+		if blackout.Scope == arg.Scope && blackout.ScopeID == arg.ScopeID && blackout.SourceUID == arg.SourceUID {
+			return blackout, true
+		}
+	}
+	return Blackout{}, false
+}
+
+func (r *MemoryRepository) InsertBlackout(ctx context.Context, arg Blackout) (Blackout, error) {
+	defer r.lock()()
+	if _, ok := r.sourceBlackout(arg); ok {
+		return Blackout{}, fmt.Errorf("%w: blackout of event %s", ErrDuplicate, arg.SourceUID)
+	}
+	arg.StartDate = memoryDate(arg.StartDate)
+	arg.EndDate = memoryDate(arg.EndDate)
+	if err := r.store.state.blackouts.insert(arg.ID, arg); err != nil {
+		return Blackout{}, err
+	}
+	return arg, nil
+}
+
+func (r *MemoryRepository) UpsertBlackout(ctx context.Context, arg Blackout) (Blackout, error) {
+	defer r.lock()()
+	blackout, ok := r.sourceBlackout(arg)
+	if !ok {
+		arg.StartDate = memoryDate(arg.StartDate)
+		arg.EndDate = memoryDate(arg.EndDate)
+		if err := r.store.state.blackouts.insert(arg.ID, arg); err != nil {
+			return Blackout{}, err
+		}
+		return arg, nil
+	}
+	blackout.StartDate = memoryDate(arg.StartDate)
+	blackout.EndDate = memoryDate(arg.EndDate)
+	blackout.Action = arg.Action
+	blackout.Summary = arg.Summary
+	r.store.state.blackouts.put(blackout.ID, blackout)
+	return blackout, nil
+}
+
+func (r *MemoryRepository) selectBlackouts(keep func(Blackout) bool) []Blackout {
+	var items []Blackout
+	for _, blackout := range r.store.state.blackouts.all() {
+		if keep(blackout) {
+			items = append(items, blackout)
+		}
+	}
+	sort.SliceStable(items, func(i, j int) bool {
+		return items[i].StartDate.Before(items[j].StartDate)
+	})
+	return items
+}
+
+func (r *MemoryRepository) GetBlackoutsByScope(ctx context.Context, scope, scopeID string) ([]Blackout, error) {
+	defer r.lock()()
+	return r.selectBlackouts(func(blackout Blackout) bool {
+		return blackout.Scope == scope && blackout.ScopeID == scopeID
+	}), nil
+}
+
+func (r *MemoryRepository) GetBlackoutsForUser(ctx context.Context, userID string, from, until time.Time) ([]Blackout, error) {
+	defer r.lock()()
+	return r.selectBlackouts(func(blackout Blackout) bool {
+		inScope := blackout.Scope == "global" || blackout.Scope == "restaurant" ||
+			(blackout.Scope == "user" && blackout.ScopeID == userID)
+		return inScope && !blackout.EndDate.Before(from) && !blackout.StartDate.After(until)
+	}), nil
+}
+
+func (r *MemoryRepository) GetBlackoutByID(ctx context.Context, id string) (Blackout, error) {
+	defer r.lock()()
+	blackout, ok := r.store.state.blackouts.get(id)
+	if !ok {
+		return blackout, ErrNotExist
+	}
+	return blackout, nil
+}
+
+func (r *MemoryRepository) DeleteBlackout(ctx context.Context, id string) error {
+	defer r.lock()()
+	if !r.store.state.blackouts.delete(id) {
+		return ErrDeleteFailed
+	}
+	return nil
+}
+
+func (r *MemoryRepository) InsertDeliverySlot(ctx context.Context, arg DeliverySlot) (DeliverySlot, error) {
+	defer r.lock()()
+	if err := r.store.state.slots.insert(arg.ID, arg); err != nil {
+		return DeliverySlot{}, err
+	}
+	return arg, nil
+}
+
+func (r *MemoryRepository) GetActiveDeliverySlots(ctx context.Context) ([]DeliverySlot, error) {
+	defer r.lock()()
+	var items []DeliverySlot
+	for _, slot := range r.store.state.slots.all() {
+		if slot.Active {
+			items = append(items, slot)
+		}
+	}
+	sort.SliceStable(items, func(i, j int) bool {
+		return items[i].StartTime < items[j].StartTime
+	})
+	return items, nil
+}
+
+func slotReservationKey(slotID, postalZone string, deliveryDate time.Time) string {
+	return slotID + "|" + postalZone + "|" + deliveryDate.Format("2006-01-02")
+}
+
+func (r *MemoryRepository) GetSlotReservations(ctx context.Context, postalZone string, from, until time.Time) ([]SlotReservation, error) {
+	defer r.lock()()
+	var items []SlotReservation
+	for _, reservation := range r.store.state.reservations.all() {
+		if reservation.PostalZone == postalZone &&
+			!reservation.DeliveryDate.Before(from) && !reservation.DeliveryDate.After(until) {
+			items = append(items, reservation)
+		}
+	}
+	sort.Slice(items, func(i, j int) bool {
+		if !items[i].DeliveryDate.Equal(items[j].DeliveryDate) {
+			return items[i].DeliveryDate.Before(items[j].DeliveryDate)
+		}
+		return items[i].SlotID < items[j].SlotID
+	})
+	return items, nil
+}
+
+// ReserveSlots takes every reservation or none of them, see DataQuery.ReserveSlots.
+func (r *MemoryRepository) ReserveSlots(ctx context.Context, reservations []SlotReservation) error {
+	return r.ExecTx(ctx, func(repo SubscriptionRepository) error {
+		s := repo.(*MemoryRepository).store.state
+		for _, arg := range reservations {
+			date := memoryDate(arg.DeliveryDate)
+			key := slotReservationKey(arg.SlotID, arg.PostalZone, date)
+			reservation, ok := s.reservations.get(key)
+			if !ok {
+				reservation = SlotReservation{SlotID: arg.SlotID, PostalZone: arg.PostalZone, DeliveryDate: date}
+			}
+
+			slot, ok := s.slots.get(arg.SlotID)
+			if !ok || !slot.Active || reservation.Reserved+arg.Reserved > slot.Capacity {
+				return &SlotFullError{SlotID: arg.SlotID, PostalZone: arg.PostalZone, DeliveryDate: arg.DeliveryDate}
+			}
+			reservation.Reserved += arg.Reserved
+			s.reservations.put(key, reservation)
+		}
+		return nil
+	})
+}
+
+// ReleaseSlots gives back the places taken by ReserveSlots.
+func (r *MemoryRepository) ReleaseSlots(ctx context.Context, reservations []SlotReservation) error {
+	return r.ExecTx(ctx, func(repo SubscriptionRepository) error {
+		s := repo.(*MemoryRepository).store.state
+		for _, arg := range reservations {
+			key := slotReservationKey(arg.SlotID, arg.PostalZone, memoryDate(arg.DeliveryDate))
+			reservation, ok := s.reservations.get(key)
+			if !ok {
+				continue
+			}
+			reservation.Reserved -= arg.Reserved
+			if reservation.Reserved < 0 {
+				reservation.Reserved = 0
+			}
+			s.reservations.put(key, reservation)
+		}
+		return nil
+	})
+}
+
+func (r *MemoryRepository) UpsertCalendarToken(ctx context.Context, userID, tokenHash string, createdAt time.Time) error {
+	defer r.lock()()
+	r.store.state.calendarTokens.put(userID, calendarToken{userID: userID, tokenHash: tokenHash, createdAt: createdAt})
+	return nil
+}
+
+func (r *MemoryRepository) GetCalendarTokenHash(ctx context.Context, userID string) (string, error) {
+	defer r.lock()()
+	token, ok := r.store.state.calendarTokens.get(userID)
+	if !ok {
+		return "", ErrNotExist
+	}
+	return token.tokenHash, nil
+}
+
+func (r *MemoryRepository) InsertAuditEntry(ctx context.Context, arg AuditEntry) error {
+	defer r.lock()()
+	return r.store.state.auditLog.insert(arg.ID, arg)
+}
+
+func (r *MemoryRepository) GetAuditLog(ctx context.Context, subscriptionID string) ([]AuditEntry, error) {
+	defer r.lock()()
+	var items []AuditEntry
+	for _, entry := range r.store.state.auditLog.all() {
+		if entry.SubscriptionID == subscriptionID {
+			items = append(items, entry)
+		}
+	}
+	sort.SliceStable(items, func(i, j int) bool {
+		if !items[i].CreatedAt.Equal(items[j].CreatedAt) {
+			return items[i].CreatedAt.Before(items[j].CreatedAt)
+		}
+		return items[i].ID < items[j].ID
+	})
+	return items, nil
+}
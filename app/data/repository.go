@@ -0,0 +1,96 @@
+package data
+
+import (
+	"context"
+	"time"
+)
+
+// SubscriptionRepository is where the subscription service keeps its records. DataQuery
+// stores them in Postgres, MemoryRepository in memory for tests and local runs.
+//
+// Every implementation reports a missing row as ErrNotExist, a second row with the same key
+// as ErrDuplicate, and a conditional update whose condition no longer holds as
+// ErrUpdateFailed or ErrDeleteFailed.
+type SubscriptionRepository interface {
+	// ExecTx runs fn with a repository whose writes are kept only when fn returns nil.
+	// Called on the repository given to fn, it joins the transaction already open.
+	ExecTx(ctx context.Context, fn func(SubscriptionRepository) error) error
+	// TryAdvisoryLock takes a lock shared by every replica of the service without waiting.
+	TryAdvisoryLock(ctx context.Context, key int64) (release func() error, ok bool, err error)
+
+	InsertSubscription(ctx context.Context, arg Subscription) (Subscription, error)
+	GetSubscriptionByID(ctx context.Context, id string) (Subscription, error)
+	GetSubscriptionByUserID(ctx context.Context, userID string) ([]Subscription, error)
+	GetSubscriptionsPage(ctx context.Context, q SubscriptionListQuery) ([]Subscription, error)
+	GetSubscriptionsEndedBefore(ctx context.Context, status string, before time.Time) ([]Subscription, error)
+	GetOpenEndedSubscriptions(ctx context.Context, status string) ([]Subscription, error)
+	GetRenewableSubscriptions(ctx context.Context, status string, before time.Time) ([]Subscription, error)
+	ChangeSubscriptionStatus(ctx context.Context, arg StatusTransition) (Subscription, error)
+	UpdateSubscriptionEndDate(ctx context.Context, endDate time.Time, subscriptionID string) (Subscription, error)
+	RenewSubscription(ctx context.Context, status string, previousEndDate, endDate time.Time, subscriptionID string) (Subscription, error)
+	UpdateSubscriptionTerm(ctx context.Context, startDate, endDate time.Time, termDays int, subscriptionID string) (Subscription, error)
+	UpdateSubscriptionAutoRenew(ctx context.Context, autoRenew bool, subscriptionID string) (Subscription, error)
+	ChangeSubscriptionReceiver(ctx context.Context, subscriptionID string, receiver ReceiverDetails, history ReceiverHistory) (Subscription, error)
+	GetReceiverHistory(ctx context.Context, subscriptionID string) ([]ReceiverHistory, error)
+	GetReceiverHistories(ctx context.Context, subscriptionIDs []string) ([]ReceiverHistory, error)
+
+	InsertDishes(ctx context.Context, arg SubscriptionDish) (string, error)
+	GetDishBySubscriptionID(ctx context.Context, subscriptionID string) ([]SubscriptionDish, error)
+	GetDishesBySubscriptionIDs(ctx context.Context, subscriptionIDs []string) ([]SubscriptionDish, error)
+	GetSubscriptionDishByID(ctx context.Context, id string) (SubscriptionDish, error)
+	GetDishTimeZone(ctx context.Context, subscriptionDishID string) (string, error)
+	UpdateSubscriptionDish(ctx context.Context, arg SubscriptionDish) (SubscriptionDish, error)
+	RemoveSubscriptionDish(ctx context.Context, removedAt time.Time, subscriptionDishID string) (SubscriptionDish, error)
+
+	InsertDishDelivery(ctx context.Context, arg DishDelivery) (string, error)
+	InsertDishDeliveries(ctx context.Context, deliveries []DishDelivery) ([]string, error)
+	GetDishDeliveryCondition(ctx context.Context, subscriptionDishID string) ([]DishDelivery, error)
+	GetDishDeliveryByID(ctx context.Context, id string) (DishDelivery, error)
+	GetLatestDeliveryTime(ctx context.Context, subscriptionDishID string) (time.Time, error)
+	GetDeliveriesByUserInRange(ctx context.Context, userID string, from, until time.Time) ([]DeliveryInRange, error)
+	GetDeliveriesBySubscriptionInRange(ctx context.Context, subscriptionID string, from, until time.Time) ([]DeliveryInRange, error)
+	GetDeliveriesByDishesInRange(ctx context.Context, dishIDs []string, from, until time.Time) ([]DeliveryInRange, error)
+	UpdateDishDelivery(ctx context.Context, arg DishDelivery) (DishDelivery, error)
+	UpdateDeliveryProgress(ctx context.Context, fromStatus string, arg DishDelivery) (DishDelivery, error)
+	ChangeDishDeliveryStatusByID(ctx context.Context, fromStatus, toStatus string, deliveryID string) (DishDelivery, error)
+	ChangeDishDeliveryStatus(ctx context.Context, toStatus string, subscriptionDishID string) ([]DishDelivery, error)
+	ChangeDeliveryStatusInWindow(ctx context.Context, arg DeliveryWindowUpdate) ([]DishDelivery, error)
+	DeleteDishDeliveriesFrom(ctx context.Context, subscriptionDishID string, status string, from time.Time) ([]DishDelivery, error)
+	DeleteDishDeliveries(ctx context.Context, status string, deliveryIDs []string) ([]DishDelivery, error)
+
+	InsertSubscriptionPause(ctx context.Context, arg SubscriptionPause) error
+	GetOpenSubscriptionPause(ctx context.Context, subscriptionID string) (SubscriptionPause, error)
+	GetPausesStartingBefore(ctx context.Context, subscriptionStatus string, before time.Time) ([]SubscriptionPause, error)
+	GetPausesEndingBefore(ctx context.Context, subscriptionStatus string, before time.Time) ([]SubscriptionPause, error)
+	CloseSubscriptionPause(ctx context.Context, resumedAt time.Time, policy string, pauseID string) error
+
+	InsertSubscriptionCancellation(ctx context.Context, arg SubscriptionCancellation) error
+	InsertCancelledDelivery(ctx context.Context, arg CancelledDelivery) error
+	GetLatestSubscriptionCancellation(ctx context.Context, subscriptionID string) (SubscriptionCancellation, error)
+	GetCancelledDeliveries(ctx context.Context, cancellationID string) ([]CancelledDelivery, error)
+	MarkCancellationUndone(ctx context.Context, undoneAt time.Time, cancellationID string) error
+
+	InsertBlackout(ctx context.Context, arg Blackout) (Blackout, error)
+	UpsertBlackout(ctx context.Context, arg Blackout) (Blackout, error)
+	GetBlackoutsByScope(ctx context.Context, scope, scopeID string) ([]Blackout, error)
+	GetBlackoutsForUser(ctx context.Context, userID string, from, until time.Time) ([]Blackout, error)
+	GetBlackoutByID(ctx context.Context, id string) (Blackout, error)
+	DeleteBlackout(ctx context.Context, id string) error
+
+	InsertDeliverySlot(ctx context.Context, arg DeliverySlot) (DeliverySlot, error)
+	GetActiveDeliverySlots(ctx context.Context) ([]DeliverySlot, error)
+	GetSlotReservations(ctx context.Context, postalZone string, from, until time.Time) ([]SlotReservation, error)
+	ReserveSlots(ctx context.Context, reservations []SlotReservation) error
+	ReleaseSlots(ctx context.Context, reservations []SlotReservation) error
+
+	UpsertCalendarToken(ctx context.Context, userID, tokenHash string, createdAt time.Time) error
+	GetCalendarTokenHash(ctx context.Context, userID string) (string, error)
+
+	InsertAuditEntry(ctx context.Context, arg AuditEntry) error
+	GetAuditLog(ctx context.Context, subscriptionID string) ([]AuditEntry, error)
+}
+
+var (
+	_ SubscriptionRepository = (*DataQuery)(nil)
+	_ SubscriptionRepository = (*MemoryRepository)(nil)
+)
@@ -0,0 +1,347 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// C: SQLite runs the queries of DataQuery for local development and tests, without a
+// C: Postgres server. The queries stay written for Postgres and are rewritten by sqliteConn
+// C: as they run; the few which SQLite cannot express have a SQLite version in this file.
+
+// sqliteDB is what a DataQuery on SQLite keeps for itself which Postgres keeps on the server
+type sqliteDB struct {
+	mu    sync.Mutex
+	locks map[int64]bool
+}
+
+// NewSQLite returns the queries for a SQLite database opened with OpenSQLite and migrated
+// with MigrateSQLite.
+func NewSQLite(db *sql.DB) *DataQuery {
+	return &DataQuery{DBConn: db, sqlite: &sqliteDB{locks: map[int64]bool{}}}
+}
+
+// OpenSQLite opens the SQLite database in the file at path, which is created when it does
+// not exist. An empty path opens a database in memory, which is gone once it is closed.
+func OpenSQLite(path string) (*sql.DB, error) {
+	dsn := "file:" + path + "?_foreign_keys=on&_busy_timeout=5000"
+	if path == "" {
+		dsn = "file::memory:?_foreign_keys=on"
+	}
+	db, err := sql.Open("sqlite3", dsn)
+	if err != nil {
+		return nil, err
+	}
+	// C: SQLite writes one transaction at a time, and a database in memory only lives as
+	// C: long as its connection, so the service uses a single one
+	db.SetMaxOpenConns(1)
+	db.SetConnMaxLifetime(0)
+
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+// C: goose keeps the applied versions in this table, so the goose binary works on the same
+// C: database as MigrateSQLite
+const createGooseVersionTable = `create table if not exists goose_db_version (
+  id integer primary key autoincrement,
+  version_id integer not null,
+  is_applied integer not null,
+  tstamp timestamp default (datetime('now'))
+)`
+
+const getGooseVersions = `select version_id, is_applied from goose_db_version order by id`
+
+const insertGooseVersion = `insert into goose_db_version (version_id, is_applied) values (?, ?)`
+
+// MigrateSQLite applies the goose migrations in migrations which the database does not have
+// yet, each in a transaction of its own.
+func MigrateSQLite(ctx context.Context, db *sql.DB, migrations fs.FS) error {
+	if _, err := db.ExecContext(ctx, createGooseVersionTable); err != nil {
+		return err
+	}
+
+	applied := map[int64]bool{}
+	rows, err := db.QueryContext(ctx, getGooseVersions)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var version int64
+		var isApplied bool
+		if err := rows.Scan(&version, &isApplied); err != nil {
+			return err
+		}
+		// C: a later row of a version tells whether it was rolled back
+		applied[version] = isApplied
+	}
+	if err := rows.Close(); err != nil {
+		return err
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if len(applied) == 0 {
+		if _, err := db.ExecContext(ctx, insertGooseVersion, 0, true); err != nil {
+			return err
+		}
+	}
+
+	files, err := fs.Glob(migrations, "*.sql")
+	if err != nil {
+		return err
+	}
+	type migration struct {
+		version int64
+		file    string
+	}
+	var pending []migration
+	for _, file := range files {
+		version, err := strconv.ParseInt(strings.SplitN(path.Base(file), "_", 2)[0], 10, 64)
+		if err != nil {
+			return fmt.Errorf("migration %s does not start with its version: %w", file, err)
+		}
+		if !applied[version] {
+			pending = append(pending, migration{version: version, file: file})
+		}
+	}
+	sort.Slice(pending, func(i, j int) bool {
+		return pending[i].version < pending[j].version
+	})
+
+	for _, m := range pending {
+		content, err := fs.ReadFile(migrations, m.file)
+		if err != nil {
+			return err
+		}
+		if err := applySQLiteMigration(ctx, db, m.version, gooseUp(string(content))); err != nil {
+			return fmt.Errorf("error when applying migration %s: %w", m.file, err)
+		}
+	}
+	return nil
+}
+
+// gooseUp returns the statements of the Up section of a goose migration
+func gooseUp(migration string) string {
+	_, up, _ := strings.Cut(migration, "-- +goose Up")
+	up, _, _ = strings.Cut(up, "-- +goose Down")
+	return up
+}
+
+func applySQLiteMigration(ctx context.Context, db *sql.DB, version int64, statements string) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, statements); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, insertGooseVersion, version, true); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// sqliteTimeLayout is how times are stored in SQLite. Times are stored in UTC with all
+// digits of the fraction, so that comparing them as text compares them as times.
+const sqliteTimeLayout = "2006-01-02 15:04:05.000000000-07:00"
+
+var (
+	pgParam = regexp.MustCompile(`\$(\d+)`)
+	pgCast  = regexp.MustCompile(`::[a-z]+(\[\])?`)
+	pgAny   = regexp.MustCompile(`= any\((\$\d+)\)`)
+)
+
+// sqliteStatements are the queries which are written differently for SQLite, by their
+// Postgres query
+var sqliteStatements = map[string]string{
+	// C: max() of a column is read back as text, while the column itself is read as a time
+	getLatestDeliveryTime: `select expected_time FROM dish_delivery where subscription_dish_id = $1
+order by expected_time desc limit 1`,
+}
+
+// sqliteQuery rewrites a Postgres query for SQLite. Parameters become ?n, which SQLite
+// binds by number like $n; casts are dropped; an array parameter is read with json_each,
+// see sqliteArgs.
+func sqliteQuery(query string) string {
+	if statement, ok := sqliteStatements[query]; ok {
+		query = statement
+	}
+	query = pgAny.ReplaceAllString(query, "in (select value from json_each(${1}))")
+	query = pgCast.ReplaceAllString(query, "")
+	query = strings.ReplaceAll(query, "greatest(", "max(")
+	return pgParam.ReplaceAllString(query, "?${1}")
+}
+
+// sqliteArgs converts the arguments of a query into the values SQLite stores
+func sqliteArgs(args []any) []any {
+	converted := make([]any, len(args))
+	for i, arg := range args {
+		switch v := arg.(type) {
+		case time.Time:
+			converted[i] = v.UTC().Format(sqliteTimeLayout)
+		case sql.NullTime:
+			if v.Valid {
+				converted[i] = v.Time.UTC().Format(sqliteTimeLayout)
+			}
+		case []string:
+			array, _ := json.Marshal(v)
+			converted[i] = string(array)
+		default:
+			converted[i] = arg
+		}
+	}
+	return converted
+}
+
+// sqliteConn runs the Postgres queries of DataQuery on a SQLite database or transaction
+type sqliteConn struct {
+	DBTX
+}
+
+func (c sqliteConn) ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	return c.DBTX.ExecContext(ctx, sqliteQuery(query), sqliteArgs(args)...)
+}
+
+func (c sqliteConn) PrepareContext(ctx context.Context, query string) (*sql.Stmt, error) {
+	return c.DBTX.PrepareContext(ctx, sqliteQuery(query))
+}
+
+func (c sqliteConn) QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error) {
+	return c.DBTX.QueryContext(ctx, sqliteQuery(query), sqliteArgs(args)...)
+}
+
+func (c sqliteConn) QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row {
+	return c.DBTX.QueryRowContext(ctx, sqliteQuery(query), sqliteArgs(args)...)
+}
+
+// tryLock takes an advisory lock of the process. A SQLite database belongs to one process,
+// so there are no other replicas to share the lock with.
+func (s *sqliteDB) tryLock(key int64) (release func() error, ok bool, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.locks[key] {
+		return nil, false, nil
+	}
+	s.locks[key] = true
+
+	release = func() error {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		delete(s.locks, key)
+		return nil
+	}
+	return release, true, nil
+}
+
+// C: SQLite has no arrays to unnest, the batch is sent as one row of values per delivery
+func (dq *DataQuery) sqliteInsertDishDeliveryBatch(ctx context.Context, batch []DishDelivery) ([]string, error) {
+	var query strings.Builder
+	query.WriteString(`insert into dish_delivery ("id", "subscription_dish_id", "status", "expected_time", "note") values `)
+	args := make([]any, 0, 5*len(batch))
+	for i, arg := range batch {
+		if i > 0 {
+			query.WriteString(", ")
+		}
+		n := len(args)
+		fmt.Fprintf(&query, "($%d, $%d, $%d, $%d, $%d)", n+1, n+2, n+3, n+4, n+5)
+		args = append(args, arg.ID, arg.SubscriptionDishID, arg.Status, arg.ExpectedTime, arg.Note)
+	}
+	query.WriteString(" returning id")
+
+	rows, err := dq.conn().QueryContext(ctx, query.String(), args...)
+	if err != nil {
+		return nil, constraintError(err)
+	}
+	defer rows.Close()
+	inserted := make([]string, 0, len(batch))
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		inserted = append(inserted, id)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, constraintError(err)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, constraintError(err)
+	}
+	return inserted, nil
+}
+
+// C: SQLite cannot write in a with clause, so the update and its log are two statements of
+// C: one transaction
+const sqliteUpdateSubscriptionStatus = `update subscription set status = $3 where id = $2 and status = $1
+returning id, user_id, playlist_id, customized, status, frequency, start_date, end_date, receiver_name, receiver_contact, auto_renew, term_days, delivery_instructions, time_zone, postal_code`
+
+const sqliteInsertStatusTransition = `insert into subscription_status_transition ("id", "subscription_id",
+  "from_status", "to_status", "triggered_by", "triggered_at") values ($1, $2, $3, $4, $5, $6)`
+
+func (dq *DataQuery) sqliteChangeSubscriptionStatus(ctx context.Context, arg StatusTransition) (Subscription, error) {
+	var sub Subscription
+	err := dq.execTx(ctx, func(q *DataQuery) error {
+		row := q.conn().QueryRowContext(ctx, sqliteUpdateSubscriptionStatus, arg.FromStatus, arg.SubscriptionID, arg.ToStatus)
+		err := scanSubscription(row, &sub)
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrUpdateFailed
+		}
+		if err != nil {
+			return err
+		}
+		_, err = q.conn().ExecContext(ctx, sqliteInsertStatusTransition,
+			arg.ID, arg.SubscriptionID, arg.FromStatus, arg.ToStatus, arg.TriggeredBy, arg.TriggeredAt)
+		return constraintError(err)
+	})
+	return sub, err
+}
+
+const sqliteInsertReceiverHistory = `insert into subscription_receiver_history ("id", "subscription_id",
+  "receiver_name", "receiver_contact", "delivery_instructions", "valid_until", "changed_by", "changed_at")
+  select $2, id, receiver_name, receiver_contact, delivery_instructions, $3, $4, $5 from subscription where id = $1`
+
+const sqliteUpdateSubscriptionReceiver = `update subscription set receiver_name = $2, receiver_contact = $3,
+delivery_instructions = $4 where id = $1
+returning id, user_id, playlist_id, customized, status, frequency, start_date, end_date, receiver_name, receiver_contact, auto_renew, term_days, delivery_instructions, time_zone, postal_code`
+
+func (dq *DataQuery) sqliteChangeSubscriptionReceiver(ctx context.Context, subscriptionID string, receiver ReceiverDetails, history ReceiverHistory) (Subscription, error) {
+	var sub Subscription
+	err := dq.execTx(ctx, func(q *DataQuery) error {
+		res, err := q.conn().ExecContext(ctx, sqliteInsertReceiverHistory,
+			subscriptionID, history.ID, history.ValidUntil, history.ChangedBy, history.ChangedAt)
+		if err != nil {
+			return constraintError(err)
+		}
+		if n, err := res.RowsAffected(); err != nil || n == 0 {
+			if err != nil {
+				return err
+			}
+			return ErrNotExist
+		}
+
+		row := q.conn().QueryRowContext(ctx, sqliteUpdateSubscriptionReceiver,
+			subscriptionID, receiver.ReceiverName, receiver.ReceiverContact, receiver.DeliveryInstructions)
+		return scanSubscription(row, &sub)
+	})
+	return sub, err
+}
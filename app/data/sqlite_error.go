@@ -0,0 +1,25 @@
+//go:build cgo
+
+package data
+
+import (
+	"errors"
+	"fmt"
+
+	sqlite3 "github.com/mattn/go-sqlite3"
+)
+
+// sqliteConstraintError is constraintError for the errors of SQLite
+func sqliteConstraintError(err error) error {
+	var sqliteErr sqlite3.Error
+	if !errors.As(err, &sqliteErr) {
+		return err
+	}
+	switch sqliteErr.ExtendedCode {
+	case sqlite3.ErrConstraintUnique, sqlite3.ErrConstraintPrimaryKey:
+		return fmt.Errorf("%w: %s", ErrDuplicate, sqliteErr.Error())
+	case sqlite3.ErrConstraintForeignKey:
+		return fmt.Errorf("%w: %s", ErrNotExist, sqliteErr.Error())
+	}
+	return err
+}
@@ -0,0 +1,8 @@
+//go:build !cgo
+
+package data
+
+// C: the SQLite driver needs cgo, without it no SQLite database can be opened
+func sqliteConstraintError(err error) error {
+	return err
+}
@@ -0,0 +1,203 @@
+package domain
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/ClaudiaYao/CapstoneSubscriptionService/app/data"
+	"github.com/go-chi/chi/middleware"
+	"github.com/lithammer/shortuuid"
+)
+
+// AuditEntity is the kind of record an audit entry is about.
+type AuditEntity string
+
+const (
+	AuditSubscription AuditEntity = "subscription"
+	AuditDish         AuditEntity = "subscription_dish"
+	AuditDelivery     AuditEntity = "dish_delivery"
+)
+
+// AuditAction is what a change did to the record.
+type AuditAction string
+
+const (
+	AuditCreated             AuditAction = "created"
+	AuditUpdated             AuditAction = "updated"
+	AuditRemoved             AuditAction = "removed"
+	AuditStatusChanged       AuditAction = "status_changed"
+	AuditReceiverChanged     AuditAction = "receiver_changed"
+	AuditAutoRenewChanged    AuditAction = "auto_renew_changed"
+	AuditTermChanged         AuditAction = "term_changed"
+	AuditRescheduled         AuditAction = "rescheduled"
+	AuditDeliveriesScheduled AuditAction = "deliveries_scheduled"
+	AuditDeliveriesDeleted   AuditAction = "deliveries_deleted"
+)
+
+// audit appends a change to the audit log. It is called on the service of the transaction
+// making the change, so the change is never kept without its entry. A nil value is left
+// empty, e.g. the old value of a created record.
+func (service *SubscriptionService) audit(ctx context.Context, subscriptionID string, entity AuditEntity, entityID string, action AuditAction, oldValue, newValue any) error {
+	entry := data.AuditEntry{
+		ID:             "AL" + shortuuid.New(),
+		SubscriptionID: subscriptionID,
+		Entity:         string(entity),
+		EntityID:       entityID,
+		Action:         string(action),
+		Actor:          auditActor(ctx),
+		RequestID:      middleware.GetReqID(ctx),
+		CreatedAt:      service.now(),
+	}
+
+	var err error
+	if entry.OldValue, err = auditValue(oldValue); err != nil {
+		return err
+	}
+	if entry.NewValue, err = auditValue(newValue); err != nil {
+		return err
+	}
+	if err := service.DBConnection.InsertAuditEntry(ctx, entry); err != nil {
+		return fmt.Errorf("error when recording the change: %w", err)
+	}
+	return nil
+}
+
+func auditValue(value any) (json.RawMessage, error) {
+	if value == nil {
+		return nil, nil
+	}
+	return json.Marshal(value)
+}
+
+// auditActor is the user of the request, or the system for the background jobs
+func auditActor(ctx context.Context) string {
+	if userID := userIDFromContext(ctx); userID != "" {
+		return userID
+	}
+	return systemActor
+}
+
+// auditStatus is the old value of the records changed by a query which only knows their
+// previous status
+type auditStatus struct {
+	Status string `json:"status"`
+}
+
+// auditDelivery records the change of one delivery, which is found in the log of the
+// subscription of its dish.
+func (service *SubscriptionService) auditDelivery(ctx context.Context, action AuditAction, before, after data.DishDelivery) error {
+	dish, err := service.DBConnection.GetSubscriptionDishByID(ctx, after.SubscriptionDishID)
+	if err != nil {
+		return err
+	}
+	return service.audit(ctx, dish.SubscriptionID, AuditDelivery, after.ID, action, before, after)
+}
+
+// changeDeliveryStatusInWindow moves the deliveries of the window to a new status and
+// records the change of every delivery moved.
+func (service *SubscriptionService) changeDeliveryStatusInWindow(ctx context.Context, window data.DeliveryWindowUpdate) ([]data.DishDelivery, error) {
+	deliveries, err := service.DBConnection.ChangeDeliveryStatusInWindow(ctx, window)
+	if err != nil {
+		return nil, err
+	}
+	for _, delivery := range deliveries {
+		err := service.audit(ctx, window.SubscriptionID, AuditDelivery, delivery.ID, AuditStatusChanged, auditStatus{Status: window.FromStatus}, delivery)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return deliveries, nil
+}
+
+// insertDishDeliveries stores new deliveries of the subscription's dishes, reserves their
+// slot places and records them as one entry.
+func (service *SubscriptionService) insertDishDeliveries(ctx context.Context, sub data.Subscription, dishes []data.SubscriptionDish, deliveries []data.DishDelivery) error {
+	if _, err := service.DBConnection.InsertDishDeliveries(ctx, deliveries); err != nil {
+		return err
+	}
+	if len(deliveries) == 0 {
+		return nil
+	}
+	if err := service.moveSlotPlaces(ctx, sub, dishes, nil, deliveries); err != nil {
+		return err
+	}
+	return service.audit(ctx, sub.ID, AuditSubscription, sub.ID, AuditDeliveriesScheduled, nil, deliveries)
+}
+
+// deleteDishDeliveries deletes the deliveries of the dish in the status from the given time
+// on, gives their slot places back and records how many were deleted.
+func (service *SubscriptionService) deleteDishDeliveries(ctx context.Context, sub data.Subscription, dish data.SubscriptionDish, status DeliveryStatus, from time.Time) (int64, error) {
+	deleted, err := service.DBConnection.DeleteDishDeliveriesFrom(ctx, dish.ID, string(status), from)
+	if err != nil || len(deleted) == 0 {
+		return 0, err
+	}
+	if err := service.releaseDeletedDeliveries(ctx, sub, dish, deleted); err != nil {
+		return 0, err
+	}
+	n := int64(len(deleted))
+	record := struct {
+		Status string    `json:"status"`
+		From   time.Time `json:"from"`
+		Count  int64     `json:"count"`
+	}{string(status), from, n}
+	return n, service.audit(ctx, dish.SubscriptionID, AuditDish, dish.ID, AuditDeliveriesDeleted, record, nil)
+}
+
+// deleteListedDeliveries deletes the given deliveries of the dish which are still in the
+// status, gives their slot places back and records them.
+func (service *SubscriptionService) deleteListedDeliveries(ctx context.Context, sub data.Subscription, dish data.SubscriptionDish, status DeliveryStatus, deliveries []data.DishDelivery) (int64, error) {
+	ids := make([]string, 0, len(deliveries))
+	for _, delivery := range deliveries {
+		ids = append(ids, delivery.ID)
+	}
+	deleted, err := service.DBConnection.DeleteDishDeliveries(ctx, string(status), ids)
+	if err != nil || len(deleted) == 0 {
+		return 0, err
+	}
+	if err := service.releaseDeletedDeliveries(ctx, sub, dish, deleted); err != nil {
+		return 0, err
+	}
+	return int64(len(deleted)), service.audit(ctx, dish.SubscriptionID, AuditDish, dish.ID, AuditDeliveriesDeleted, deleted, nil)
+}
+
+func (service *SubscriptionService) releaseDeletedDeliveries(ctx context.Context, sub data.Subscription, dish data.SubscriptionDish, deleted []data.DishDelivery) error {
+	released := make([]data.DishDelivery, 0, len(deleted))
+	for _, delivery := range deleted {
+		if holdsSlotPlace(delivery) {
+			released = append(released, delivery)
+		}
+	}
+	return service.moveSlotPlaces(ctx, sub, []data.SubscriptionDish{dish}, released, nil)
+}
+
+// updateSubscriptionEndDate moves the end date of the subscription and records the change
+func (service *SubscriptionService) updateSubscriptionEndDate(ctx context.Context, sub data.Subscription, endDate time.Time) (data.Subscription, error) {
+	updated, err := service.DBConnection.UpdateSubscriptionEndDate(ctx, endDate, sub.ID)
+	if err != nil {
+		return updated, err
+	}
+	return updated, service.audit(ctx, sub.ID, AuditSubscription, sub.ID, AuditTermChanged, sub, updated)
+}
+
+// SubscriptionHistory returns every recorded change of the subscription, its dishes and
+// deliveries, oldest first.
+func (service *SubscriptionService) SubscriptionHistory(ctx context.Context, subscriptionID string) ([]data.AuditEntry, error) {
+	sub, err := service.DBConnection.GetSubscriptionByID(ctx, subscriptionID)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := service.DBConnection.GetAuditLog(ctx, subscriptionID)
+	if err != nil {
+		return nil, fmt.Errorf("error when querying the audit log: %w", err)
+	}
+
+	loc := subscriptionLocation(sub)
+	history := make([]data.AuditEntry, 0, len(entries))
+	for _, entry := range entries {
+		entry.CreatedAt = localTime(entry.CreatedAt, loc)
+		history = append(history, entry)
+	}
+	return history, nil
+}
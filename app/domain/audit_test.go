@@ -0,0 +1,62 @@
+package domain
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/ClaudiaYao/CapstoneSubscriptionService/app/data"
+	"github.com/go-chi/chi/middleware"
+)
+
+func TestStatusChangesAreAudited(t *testing.T) {
+	repo := data.NewMemoryRepository()
+	sub := data.Subscription{ID: "Sub1", UserID: "U1", Status: string(StatusActive), Frequency: "FREQ=DAILY", StartDate: day(2023, 3, 6), TimeZone: "UTC"}
+	if _, err := repo.InsertSubscription(context.Background(), sub); err != nil {
+		t.Fatal(err)
+	}
+	service := &SubscriptionService{DBConnection: repo}
+
+	ctx := context.WithValue(context.Background(), "userID", "U1")
+	ctx = context.WithValue(ctx, middleware.RequestIDKey, "req-1")
+	if _, err := service.ChangeSubscriptionStatus(ctx, sub.ID, StatusPaused, "U1"); err != nil {
+		t.Fatal(err)
+	}
+
+	// a change whose transaction fails leaves no entry
+	failed := errors.New("failed")
+	err := service.inTransaction(context.Background(), func(tx *SubscriptionService) error {
+		if _, err := tx.ChangeSubscriptionStatus(context.Background(), sub.ID, StatusActive, systemActor); err != nil {
+			return err
+		}
+		return failed
+	})
+	if !errors.Is(err, failed) {
+		t.Fatalf("got %v, want the transaction to fail", err)
+	}
+
+	history, err := service.SubscriptionHistory(ctx, sub.ID)
+	if err != nil || len(history) != 1 {
+		t.Fatalf("got %+v %v, want one entry", history, err)
+	}
+	entry := history[0]
+	if entry.Entity != string(AuditSubscription) || entry.Action != string(AuditStatusChanged) || entry.Actor != "U1" || entry.RequestID != "req-1" {
+		t.Errorf("got %+v", entry)
+	}
+	var before, after data.Subscription
+	if json.Unmarshal(entry.OldValue, &before) != nil || json.Unmarshal(entry.NewValue, &after) != nil ||
+		before.Status != string(StatusActive) || after.Status != string(StatusPaused) {
+		t.Errorf("got %s to %s, want the subscription before and after the change", entry.OldValue, entry.NewValue)
+	}
+
+	if _, err := service.SubscriptionHistory(ctx, "SubMissing"); !errors.Is(err, data.ErrNotExist) {
+		t.Errorf("got %v for a missing subscription, want ErrNotExist", err)
+	}
+}
+
+func TestAuditActorOfBackgroundJobs(t *testing.T) {
+	if got := auditActor(context.Background()); got != systemActor {
+		t.Errorf("got %q without a user, want %q", got, systemActor)
+	}
+}
@@ -45,8 +45,14 @@ func NewJWTMaker(tokenPath string) (*JWTMaker, error) {
 
 // CreateToken creates a new token for a specific username and duration
 func (maker *JWTMaker) CreateJWTToken(userID string, duration time.Duration) (string, error) {
+	return maker.CreateRoleJWTToken(userID, "", duration)
+}
+
+// CreateRoleJWTToken creates a new token for a user acting in a specific role, e.g. a courier
+func (maker *JWTMaker) CreateRoleJWTToken(userID string, role string, duration time.Duration) (string, error) {
 	payload := Payload{
 		UserID:    userID,
+		Role:      role,
 		IssuedAt:  time.Now(),
 		ExpiredAt: time.Now().Add(duration),
 	}
@@ -44,3 +44,28 @@ func TestVerifyJWT(t *testing.T) {
 		fmt.Println("invalid token")
 	}
 }
+
+func TestCreateRoleJWT(t *testing.T) {
+
+	jwtMaker, err := NewJWTMaker("tokenData")
+	if err != nil {
+		log.Panic(err)
+	}
+	jwtVerifier, err := NewJWTVerifier("tokenData")
+	if err != nil {
+		log.Panic(err)
+	}
+
+	token, err := jwtMaker.CreateRoleJWTToken("courier1", RoleCourier, time.Duration(time.Second)*3600)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	payload, err := jwtVerifier.GetMetaData(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if payload.UserID != "courier1" || payload.Role != RoleCourier {
+		t.Errorf("unexpected payload %+v", payload)
+	}
+}
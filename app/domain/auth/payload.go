@@ -13,9 +13,16 @@ var (
 	ErrExpiredToken = errors.New("token has expired")
 )
 
+// Roles a token can be issued for, users without a role are plain customers
+const (
+	RoleCourier = "courier"
+	RoleAdmin   = "admin"
+)
+
 // Payload contains the payload data of the token
 type Payload struct {
 	UserID    string    `json:"id"`
+	Role      string    `json:"role,omitempty"`
 	IssuedAt  time.Time `json:"issued_at"`
 	ExpiredAt time.Time `json:"expired_at"`
 }
@@ -0,0 +1,344 @@
+package domain
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ClaudiaYao/CapstoneSubscriptionService/app/data"
+	"github.com/ClaudiaYao/CapstoneSubscriptionService/app/domain/auth"
+	"github.com/ClaudiaYao/CapstoneSubscriptionService/app/recurrence"
+	"github.com/lithammer/shortuuid"
+)
+
+// BlackoutScope says whose deliveries a blackout applies to.
+type BlackoutScope string
+
+const (
+	BlackoutGlobal     BlackoutScope = "global"
+	BlackoutRestaurant BlackoutScope = "restaurant"
+	BlackoutUser       BlackoutScope = "user"
+)
+
+// BlackoutAction is what happens to a delivery which lands on a blackout date.
+type BlackoutAction string
+
+const (
+	BlackoutSkip        BlackoutAction = "skip"
+	BlackoutPreviousDay BlackoutAction = "previous"
+	BlackoutNextDay     BlackoutAction = "next"
+)
+
+// blackoutActionProperty lets an imported calendar event choose its own action
+const blackoutActionProperty = "X-BLACKOUT-ACTION"
+
+// maxBlackoutShift is how many days a delivery is moved at most to get past a blackout.
+// Deliveries which cannot be moved that far are skipped.
+const maxBlackoutShift = 7
+
+var (
+	ErrInvalidBlackout    = errors.New("invalid blackout")
+	ErrBlackoutForbidden  = errors.New("not allowed to manage blackouts of this scope")
+	ErrDeliveryOnBlackout = errors.New("deliveries cannot be moved onto a blackout date")
+)
+
+type BlackoutRequested struct {
+	Scope     BlackoutScope  `json:"scope"`
+	ScopeID   string         `json:"scopeID,omitempty"`
+	StartDate time.Time      `json:"startDate"`
+	EndDate   time.Time      `json:"endDate,omitempty"`
+	Action    BlackoutAction `json:"action"`
+	Summary   string         `json:"summary,omitempty"`
+}
+
+func (a BlackoutAction) IsValid() bool {
+	return a == BlackoutSkip || a == BlackoutPreviousDay || a == BlackoutNextDay
+}
+
+// validateBlackoutScope checks the scope and that only global blackouts come without an id.
+func validateBlackoutScope(scope BlackoutScope, scopeID string) error {
+	switch scope {
+	case BlackoutGlobal:
+		if scopeID != "" {
+			return fmt.Errorf("%w: global blackouts have no scope id", ErrInvalidBlackout)
+		}
+	case BlackoutRestaurant, BlackoutUser:
+		if scopeID == "" {
+			return fmt.Errorf("%w: %s blackouts need a scope id", ErrInvalidBlackout, scope)
+		}
+	default:
+		return fmt.Errorf("%w: unknown scope %q", ErrInvalidBlackout, scope)
+	}
+	return nil
+}
+
+// canManageBlackouts reports whether a caller may change the blackouts of a scope. Admins
+// manage every calendar, customers only their own.
+func canManageBlackouts(scope BlackoutScope, scopeID, userID, role string) bool {
+	if role == auth.RoleAdmin {
+		return true
+	}
+	return scope == BlackoutUser && scopeID == userID && userID != ""
+}
+
+// CreateBlackout adds a blackout for the given dates. A zero end date blocks the start date only.
+func (service *SubscriptionService) CreateBlackout(ctx context.Context, request BlackoutRequested, userID, role string) (data.Blackout, error) {
+	if err := validateBlackoutScope(request.Scope, request.ScopeID); err != nil {
+		return data.Blackout{}, err
+	}
+	if !canManageBlackouts(request.Scope, request.ScopeID, userID, role) {
+		return data.Blackout{}, ErrBlackoutForbidden
+	}
+	if !request.Action.IsValid() {
+		return data.Blackout{}, fmt.Errorf("%w: unknown action %q", ErrInvalidBlackout, request.Action)
+	}
+	if request.StartDate.IsZero() {
+		return data.Blackout{}, fmt.Errorf("%w: start date is required", ErrInvalidBlackout)
+	}
+
+	// C: blackout dates are calendar days, they apply in the time zone of each subscription
+	start := civilDate(request.StartDate, request.StartDate.Location())
+	end := start
+	if !request.EndDate.IsZero() {
+		end = civilDate(request.EndDate, request.EndDate.Location())
+	}
+	if end.Before(start) {
+		return data.Blackout{}, fmt.Errorf("%w: end date is before the start date", ErrInvalidBlackout)
+	}
+
+	return service.DBConnection.InsertBlackout(ctx, data.Blackout{
+		ID:        "BD" + shortuuid.New(),
+		Scope:     string(request.Scope),
+		ScopeID:   request.ScopeID,
+		StartDate: start,
+		EndDate:   end,
+		Action:    string(request.Action),
+		Summary:   request.Summary,
+		CreatedBy: userID,
+		CreatedAt: service.now(),
+	})
+}
+
+// ImportBlackouts adds every event of an iCalendar file as a blackout of the scope. Events
+// use the given action unless they carry their own X-BLACKOUT-ACTION. Events are matched on
+// their UID, so importing an updated calendar again changes the existing blackouts.
+func (service *SubscriptionService) ImportBlackouts(ctx context.Context, calendar io.Reader, scope BlackoutScope, scopeID string, action BlackoutAction, userID, role string) ([]data.Blackout, error) {
+	if err := validateBlackoutScope(scope, scopeID); err != nil {
+		return nil, err
+	}
+	if !canManageBlackouts(scope, scopeID, userID, role) {
+		return nil, ErrBlackoutForbidden
+	}
+	if action == "" {
+		action = BlackoutSkip
+	}
+	if !action.IsValid() {
+		return nil, fmt.Errorf("%w: unknown action %q", ErrInvalidBlackout, action)
+	}
+
+	events, err := parseICalEvents(calendar)
+	if err != nil {
+		return nil, err
+	}
+
+	// C: a calendar is imported as a whole, a bad event leaves the blackouts as they were
+	imported := []data.Blackout{}
+	err = service.inTransaction(ctx, func(tx *SubscriptionService) error {
+		for _, event := range events {
+			eventAction := action
+			if value, ok := event.Properties[blackoutActionProperty]; ok {
+				eventAction = BlackoutAction(strings.ToLower(value))
+				if !eventAction.IsValid() {
+					return fmt.Errorf("%w: event %s has unknown action %q", ErrInvalidBlackout, event.UID, value)
+				}
+			}
+
+			uid := event.UID
+			if uid == "" {
+				uid = event.Start.Format(icalDateLayout) + "-" + event.Summary
+			}
+
+			blackout, err := tx.DBConnection.UpsertBlackout(ctx, data.Blackout{
+				ID:        "BD" + shortuuid.New(),
+				Scope:     string(scope),
+				ScopeID:   scopeID,
+				StartDate: event.Start,
+				EndDate:   event.End,
+				Action:    string(eventAction),
+				Summary:   event.Summary,
+				SourceUID: uid,
+				CreatedBy: userID,
+				CreatedAt: service.now(),
+			})
+			if err != nil {
+				return fmt.Errorf("error when importing the blackout %s: %w", uid, err)
+			}
+			imported = append(imported, blackout)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return imported, nil
+}
+
+// GetBlackouts lists the blackouts of one scope.
+func (service *SubscriptionService) GetBlackouts(ctx context.Context, scope BlackoutScope, scopeID string) ([]data.Blackout, error) {
+	if err := validateBlackoutScope(scope, scopeID); err != nil {
+		return nil, err
+	}
+	return service.DBConnection.GetBlackoutsByScope(ctx, string(scope), scopeID)
+}
+
+// DeleteBlackout removes a blackout. Deliveries already created around it are not changed.
+func (service *SubscriptionService) DeleteBlackout(ctx context.Context, blackoutID, userID, role string) error {
+	blackout, err := service.DBConnection.GetBlackoutByID(ctx, blackoutID)
+	if err != nil {
+		return err
+	}
+	if !canManageBlackouts(BlackoutScope(blackout.Scope), blackout.ScopeID, userID, role) {
+		return ErrBlackoutForbidden
+	}
+
+	err = service.DBConnection.DeleteBlackout(ctx, blackoutID)
+	if errors.Is(err, data.ErrDeleteFailed) {
+		return data.ErrNotExist
+	}
+	return err
+}
+
+// blackoutCalendar holds the blackouts which apply to the deliveries of one dish.
+type blackoutCalendar struct {
+	blackouts []data.Blackout
+}
+
+// loadBlackoutCalendar loads the global blackouts, those of the subscriber and those of the
+// restaurant preparing the dish, for deliveries between from and until.
+func (service *SubscriptionService) loadBlackoutCalendar(ctx context.Context, sub data.Subscription, dish data.SubscriptionDish, from, until time.Time) (blackoutCalendar, error) {
+	loc := subscriptionLocation(sub)
+	first := civilDate(from, loc).AddDate(0, 0, -maxBlackoutShift)
+	last := civilDate(until, loc).AddDate(0, 0, maxBlackoutShift)
+
+	blackouts, err := service.DBConnection.GetBlackoutsForUser(ctx, sub.UserID, first, last)
+	if err != nil {
+		return blackoutCalendar{}, fmt.Errorf("error when querying the blackouts: %w", err)
+	}
+
+	restaurantID := ""
+	if service.Restaurants != nil {
+		restaurantID, err = service.Restaurants.RestaurantOfDish(ctx, dish.DishID)
+		if err != nil {
+			// without its restaurant only the global and user blackouts apply to the dish
+			log.Printf("blackout: no restaurant for dish %s: %v", dish.DishID, err)
+		}
+	}
+
+	calendar := blackoutCalendar{}
+	for _, blackout := range blackouts {
+		if BlackoutScope(blackout.Scope) == BlackoutRestaurant && blackout.ScopeID != restaurantID {
+			continue
+		}
+		calendar.blackouts = append(calendar.blackouts, blackout)
+	}
+	return calendar, nil
+}
+
+// blackoutLookahead is how many days of blackouts are loaded for each delivery appended
+// after the last one, with room for the occurrences which get skipped.
+func blackoutLookahead(rule recurrence.Rule) int {
+	days := 1
+	switch rule.Freq {
+	case recurrence.FreqWeekly:
+		days = 7
+	case recurrence.FreqMonthly:
+		days = 31
+	}
+	interval := rule.Interval
+	if interval < 1 {
+		interval = 1
+	}
+	return 2 * days * interval
+}
+
+// blackoutScopeRank orders overlapping blackouts, the most specific one decides
+var blackoutScopeRank = map[BlackoutScope]int{
+	BlackoutGlobal:     0,
+	BlackoutRestaurant: 1,
+	BlackoutUser:       2,
+}
+
+// blackoutOn returns the blackout covering the civil date, if any.
+func (c blackoutCalendar) blackoutOn(date time.Time) (data.Blackout, bool) {
+	var found data.Blackout
+	ok := false
+	for _, blackout := range c.blackouts {
+		if date.Before(blackout.StartDate) || date.After(blackout.EndDate) {
+			continue
+		}
+		if !ok || blackoutScopeRank[BlackoutScope(blackout.Scope)] > blackoutScopeRank[BlackoutScope(found.Scope)] {
+			found, ok = blackout, true
+		}
+	}
+	return found, ok
+}
+
+// apply returns when a delivery planned at t goes out, keeping its local time of day when
+// it is moved. It returns false when the delivery is skipped.
+func (c blackoutCalendar) apply(t time.Time, loc *time.Location) (time.Time, bool) {
+	blackout, blocked := c.blackoutOn(civilDate(t, loc))
+	if !blocked {
+		return t, true
+	}
+
+	step := 0
+	switch BlackoutAction(blackout.Action) {
+	case BlackoutPreviousDay:
+		step = -1
+	case BlackoutNextDay:
+		step = 1
+	default:
+		return t, false
+	}
+
+	local := t.In(loc)
+	year, month, day := local.Date()
+	hour, min, sec := local.Clock()
+	for shift := 1; shift <= maxBlackoutShift; shift++ {
+		moved := time.Date(year, month, day+step*shift, hour, min, sec, local.Nanosecond(), loc)
+		if _, blocked := c.blackoutOn(civilDate(moved, loc)); !blocked {
+			return moved, true
+		}
+	}
+	return t, false
+}
+
+// plan applies the blackouts to the planned delivery times of one dish. Moved deliveries
+// have to stay within [notBefore, until], and a delivery moved onto a day the dish is
+// already delivered on is dropped.
+func (c blackoutCalendar) plan(times []time.Time, loc *time.Location, notBefore, until time.Time) []time.Time {
+	planned := []time.Time{}
+	days := map[time.Time]bool{}
+	for _, t := range times {
+		moved, ok := c.apply(t, loc)
+		if !ok {
+			continue
+		}
+		if !moved.Equal(t) && (moved.Before(notBefore) || moved.After(until)) {
+			continue
+		}
+		day := civilDate(moved, loc)
+		if days[day] {
+			continue
+		}
+		days[day] = true
+		planned = append(planned, moved)
+	}
+	sort.Slice(planned, func(i, j int) bool { return planned[i].Before(planned[j]) })
+	return planned
+}
@@ -0,0 +1,93 @@
+package domain
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ClaudiaYao/CapstoneSubscriptionService/app/data"
+)
+
+func day(year int, month time.Month, d int) time.Time {
+	return time.Date(year, month, d, 0, 0, 0, 0, time.UTC)
+}
+
+func TestBlackoutCalendarApply(t *testing.T) {
+	loc, _ := loadLocation("Asia/Singapore")
+	calendar := blackoutCalendar{blackouts: []data.Blackout{
+		{Scope: string(BlackoutGlobal), StartDate: day(2023, 1, 22), EndDate: day(2023, 1, 23), Action: string(BlackoutNextDay)},
+		{Scope: string(BlackoutUser), StartDate: day(2023, 1, 23), EndDate: day(2023, 1, 23), Action: string(BlackoutSkip)},
+		{Scope: string(BlackoutRestaurant), StartDate: day(2023, 5, 1), EndDate: day(2023, 5, 1), Action: string(BlackoutPreviousDay)},
+	}}
+
+	tests := []struct {
+		name    string
+		planned time.Time
+		want    time.Time
+		ok      bool
+	}{
+		{"free day", time.Date(2023, 1, 20, 12, 0, 0, 0, loc), time.Date(2023, 1, 20, 12, 0, 0, 0, loc), true},
+		{"moved past the whole blackout", time.Date(2023, 1, 22, 12, 0, 0, 0, loc), time.Date(2023, 1, 24, 12, 0, 0, 0, loc), true},
+		{"user blackout wins", time.Date(2023, 1, 23, 12, 0, 0, 0, loc), time.Time{}, false},
+		{"moved to the day before", time.Date(2023, 5, 1, 8, 30, 0, 0, loc), time.Date(2023, 4, 30, 8, 30, 0, 0, loc), true},
+	}
+	for _, tt := range tests {
+		got, ok := calendar.apply(tt.planned, loc)
+		if ok != tt.ok || (ok && !got.Equal(tt.want)) {
+			t.Errorf("%s: got %v %v, want %v %v", tt.name, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestBlackoutCalendarPlanDropsDuplicateDays(t *testing.T) {
+	loc, _ := loadLocation("Asia/Singapore")
+	calendar := blackoutCalendar{blackouts: []data.Blackout{
+		{Scope: string(BlackoutGlobal), StartDate: day(2023, 1, 2), EndDate: day(2023, 1, 2), Action: string(BlackoutNextDay)},
+	}}
+
+	times := []time.Time{
+		time.Date(2023, 1, 2, 12, 0, 0, 0, loc),
+		time.Date(2023, 1, 3, 12, 0, 0, 0, loc),
+	}
+	planned := calendar.plan(times, loc, times[0], times[1])
+	if len(planned) != 1 || !planned[0].Equal(times[1]) {
+		t.Errorf("got %v, want only the delivery on 3 January", planned)
+	}
+}
+
+func TestParseICalEvents(t *testing.T) {
+	calendar := strings.Join([]string{
+		"BEGIN:VCALENDAR",
+		"BEGIN:VEVENT",
+		"UID:cny-2023",
+		"SUMMARY:Chinese New Year",
+		"DTSTART;VALUE=DATE:20230122",
+		"DTEND;VALUE=DATE:20230124",
+		"X-BLACKOUT-ACTION:next",
+		"END:VEVENT",
+		"BEGIN:VEVENT",
+		"UID:labour-",
+		" day-2023",
+		"SUMMARY:Labour Day",
+		"DTSTART:20230501T090000Z",
+		"END:VEVENT",
+		"END:VCALENDAR",
+	}, "\r\n")
+
+	events, err := parseICalEvents(strings.NewReader(calendar))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 2 {
+		t.Fatalf("got %d events, want 2", len(events))
+	}
+	if !events[0].Start.Equal(day(2023, 1, 22)) || !events[0].End.Equal(day(2023, 1, 23)) {
+		t.Errorf("event %s covers %v to %v", events[0].UID, events[0].Start, events[0].End)
+	}
+	if events[0].Properties[blackoutActionProperty] != "next" {
+		t.Errorf("missing %s", blackoutActionProperty)
+	}
+	if events[1].UID != "labour-day-2023" || !events[1].End.Equal(day(2023, 5, 1)) {
+		t.Errorf("got %+v", events[1])
+	}
+}
@@ -0,0 +1,269 @@
+package domain
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ClaudiaYao/CapstoneSubscriptionService/app/data"
+)
+
+var ErrInvalidCalendarToken = errors.New("invalid calendar token")
+
+// calendarEventDuration is how long a delivery is shown in calendars when its dish has no
+// delivery slot to take the end from
+const calendarEventDuration = 30 * time.Minute
+
+// calendarFeedEnd bounds the deliveries read for the feed, which has no end of its own.
+// No delivery is planned this far ahead.
+var calendarFeedEnd = time.Date(9999, 12, 31, 0, 0, 0, 0, time.UTC)
+
+// calendarUIDDomain makes the UIDs of the delivery events globally unique
+const calendarUIDDomain = "subscription-service"
+
+// CalendarToken is the secret of a user's calendar feed, with the path the feed is read from.
+type CalendarToken struct {
+	Token string `json:"token"`
+	Path  string `json:"path"`
+}
+
+// NewCalendarToken creates the secret of the user's calendar feed. It replaces the previous
+// one, so a leaked feed URL can be revoked by creating a new token.
+func (service *SubscriptionService) NewCalendarToken(ctx context.Context, userID string) (CalendarToken, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return CalendarToken{}, err
+	}
+	token := base64.RawURLEncoding.EncodeToString(secret)
+
+	if err := service.DBConnection.UpsertCalendarToken(ctx, userID, hashCalendarToken(token), service.now()); err != nil {
+		return CalendarToken{}, fmt.Errorf("error when storing the calendar token: %w", err)
+	}
+	return CalendarToken{
+		Token: token,
+		Path:  fmt.Sprintf("/calendar/%s/deliveries.ics?token=%s", userID, token),
+	}, nil
+}
+
+func hashCalendarToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func (service *SubscriptionService) verifyCalendarToken(ctx context.Context, userID, token string) error {
+	stored, err := service.DBConnection.GetCalendarTokenHash(ctx, userID)
+	if errors.Is(err, data.ErrNotExist) {
+		return ErrInvalidCalendarToken
+	}
+	if err != nil {
+		return err
+	}
+	if token == "" || subtle.ConstantTimeCompare([]byte(stored), []byte(hashCalendarToken(token))) != 1 {
+		return ErrInvalidCalendarToken
+	}
+	return nil
+}
+
+// calendarDelivery is one delivery of the feed together with what is shown about it.
+type calendarDelivery struct {
+	Delivery       data.DishDelivery
+	DishName       string
+	SubscriptionID string
+	Receiver       data.ReceiverDetails
+	End            time.Time
+	// Sequence is the revision of the delivery, it grows with every reschedule or status change
+	Sequence int
+}
+
+// WriteDeliveryCalendar writes the user's deliveries from now on as an iCalendar feed.
+// Cancelled and skipped deliveries stay in the feed as cancelled events, so calendar
+// clients update the events they already have instead of keeping them.
+func (service *SubscriptionService) WriteDeliveryCalendar(ctx context.Context, w io.Writer, userID, token string, now time.Time) error {
+	if err := service.verifyCalendarToken(ctx, userID, token); err != nil {
+		return err
+	}
+
+	deliveries, err := service.upcomingCalendarDeliveries(ctx, userID, now)
+	if err != nil {
+		return err
+	}
+	return writeDeliveryCalendar(w, deliveries, now)
+}
+
+func (service *SubscriptionService) upcomingCalendarDeliveries(ctx context.Context, userID string, now time.Time) ([]calendarDelivery, error) {
+	subscriptions, err := service.DBConnection.GetSubscriptionByUserID(ctx, userID)
+	if err != nil {
+		return nil, fmt.Errorf("error when querying the subscriptions: %w", err)
+	}
+
+	slots := map[string]data.DeliverySlot{}
+	activeSlots, err := service.DBConnection.GetActiveDeliverySlots(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("error when querying the delivery slots: %w", err)
+	}
+	for _, slot := range activeSlots {
+		slots[slot.ID] = slot
+	}
+
+	// C: removed dishes are left out of the feed, only the dishes still subscribed to are kept
+	dishes, err := service.dishesOfSubscriptions(ctx, subscriptions)
+	if err != nil {
+		return nil, fmt.Errorf("error when querying the dishes: %w", err)
+	}
+	dishByID := map[string]data.SubscriptionDish{}
+	for _, subDishes := range dishes {
+		for _, dish := range subDishes {
+			dishByID[dish.ID] = dish
+		}
+	}
+
+	ids := make([]string, 0, len(subscriptions))
+	subscriptionByID := make(map[string]data.Subscription, len(subscriptions))
+	for _, sub := range subscriptions {
+		ids = append(ids, sub.ID)
+		subscriptionByID[sub.ID] = sub
+	}
+	histories, err := service.DBConnection.GetReceiverHistories(ctx, ids)
+	if err != nil {
+		return nil, fmt.Errorf("error when querying the receiver history: %w", err)
+	}
+	history := map[string][]data.ReceiverHistory{}
+	for _, h := range histories {
+		history[h.SubscriptionID] = append(history[h.SubscriptionID], h)
+	}
+
+	upcoming, err := service.DBConnection.GetDeliveriesByUserInRange(ctx, userID, now, calendarFeedEnd)
+	if err != nil {
+		return nil, fmt.Errorf("error when querying the dish deliveries: %w", err)
+	}
+
+	dishNames := map[string]string{}
+	deliveries := []calendarDelivery{}
+	for _, delivery := range upcoming {
+		dish, ok := dishByID[delivery.SubscriptionDishID]
+		if !ok {
+			continue
+		}
+		sub := subscriptionByID[delivery.SubscriptionID]
+		loc := subscriptionLocation(sub)
+		name, ok := dishNames[dish.DishID]
+		if !ok {
+			name = service.dishName(ctx, dish.DishID)
+			dishNames[dish.DishID] = name
+		}
+
+		deliveries = append(deliveries, calendarDelivery{
+			Delivery:       localDelivery(delivery.DishDelivery, loc),
+			DishName:       name,
+			SubscriptionID: sub.ID,
+			Receiver:       receiverAt(sub, history[sub.ID], delivery.ExpectedTime),
+			End:            deliveryEnd(delivery.ExpectedTime, slots[dish.SlotID], loc),
+			Sequence:       delivery.Sequence,
+		})
+	}
+
+	sort.Slice(deliveries, func(i, j int) bool {
+		return deliveries[i].Delivery.ExpectedTime.Before(deliveries[j].Delivery.ExpectedTime)
+	})
+	return deliveries, nil
+}
+
+// dishName is the name of the dish to show, or its id when the name is not known.
+func (service *SubscriptionService) dishName(ctx context.Context, dishID string) string {
+	if service.Dishes == nil {
+		return dishID
+	}
+	name, err := service.Dishes.DishName(ctx, dishID)
+	if err != nil || name == "" {
+		return dishID
+	}
+	return name
+}
+
+// deliveryEnd is the end of the slot the delivery is expected in, or a short while after
+// the expected time when there is no slot.
+func deliveryEnd(expected time.Time, slot data.DeliverySlot, loc *time.Location) time.Time {
+	end := expected.Add(calendarEventDuration)
+	if slot.ID == "" {
+		return end
+	}
+	clock, err := time.Parse(slotClockLayout, slot.EndTime)
+	if err != nil {
+		return end
+	}
+	local := expected.In(loc)
+	slotEnd := time.Date(local.Year(), local.Month(), local.Day(), clock.Hour(), clock.Minute(), 0, 0, loc)
+	if slotEnd.After(expected) {
+		return slotEnd
+	}
+	return end
+}
+
+// deliveryEventUID is the UID of a delivery's event. It only depends on the delivery id, so
+// the event keeps its UID when the delivery is rescheduled or cancelled.
+func deliveryEventUID(deliveryID string) string {
+	return deliveryID + "@" + calendarUIDDomain
+}
+
+// deliveryEventStatus is the STATUS of a delivery's event.
+func deliveryEventStatus(status DeliveryStatus) string {
+	switch status {
+	case DeliveryCancelled, DeliverySkipped:
+		return "CANCELLED"
+	case DeliverySuspended:
+		return "TENTATIVE"
+	default:
+		return "CONFIRMED"
+	}
+}
+
+func writeDeliveryCalendar(out io.Writer, deliveries []calendarDelivery, now time.Time) error {
+	w := &icalWriter{w: out}
+	w.property("BEGIN", "VCALENDAR")
+	w.property("VERSION", "2.0")
+	w.property("PRODID", "-//CapstoneSubscriptionService//Deliveries//EN")
+	w.property("CALSCALE", "GREGORIAN")
+	w.property("METHOD", "PUBLISH")
+	w.text("X-WR-CALNAME", "Meal deliveries")
+
+	for _, item := range deliveries {
+		delivery := item.Delivery
+
+		description := []string{
+			"Dish: " + item.DishName,
+			"Receiver: " + item.Receiver.ReceiverName + " (" + item.Receiver.ReceiverContact + ")",
+		}
+		if item.Receiver.DeliveryInstructions != "" {
+			description = append(description, "Instructions: "+item.Receiver.DeliveryInstructions)
+		}
+		if delivery.Note != "" {
+			description = append(description, "Note: "+delivery.Note)
+		}
+		description = append(description, "Subscription: "+item.SubscriptionID, "Status: "+delivery.Status)
+
+		w.property("BEGIN", "VEVENT")
+		w.text("UID", deliveryEventUID(delivery.ID))
+		w.time("DTSTAMP", now)
+		// C: clients replace an event they already have when its SEQUENCE has grown
+		w.property("SEQUENCE", strconv.Itoa(item.Sequence))
+		w.time("DTSTART", delivery.ExpectedTime)
+		w.time("DTEND", item.End)
+		w.text("SUMMARY", item.DishName+" delivery")
+		w.text("DESCRIPTION", strings.Join(description, "\n"))
+		w.property("STATUS", deliveryEventStatus(DeliveryStatus(delivery.Status)))
+		w.property("END", "VEVENT")
+	}
+
+	w.property("END", "VCALENDAR")
+	return w.Err()
+}
@@ -0,0 +1,151 @@
+package domain
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ClaudiaYao/CapstoneSubscriptionService/app/data"
+	"github.com/go-chi/chi/middleware"
+)
+
+func TestWriteDeliveryCalendar(t *testing.T) {
+	loc, _ := loadLocation("Asia/Singapore")
+	expected := time.Date(2023, 3, 6, 11, 30, 0, 0, loc)
+	deliveries := []calendarDelivery{
+		{
+			Delivery:       data.DishDelivery{ID: "DDone", Status: string(DeliveryScheduled), ExpectedTime: expected, Note: "no chili, extra rice; thanks"},
+			DishName:       "Chicken Rice",
+			SubscriptionID: "Sub1",
+			Receiver:       data.ReceiverDetails{ReceiverName: "Tan", ReceiverContact: "91234567", DeliveryInstructions: strings.Repeat("leave at the door ", 6)},
+			End:            expected.Add(30 * time.Minute),
+		},
+		{
+			Delivery:       data.DishDelivery{ID: "DDtwo", Status: string(DeliveryCancelled), ExpectedTime: expected.AddDate(0, 0, 7)},
+			DishName:       "Laksa",
+			SubscriptionID: "Sub1",
+			End:            expected.AddDate(0, 0, 7).Add(30 * time.Minute),
+			Sequence:       2,
+		},
+	}
+
+	var out bytes.Buffer
+	if err := writeDeliveryCalendar(&out, deliveries, expected.AddDate(0, 0, -1)); err != nil {
+		t.Fatal(err)
+	}
+	calendar := out.String()
+
+	for _, line := range strings.Split(strings.TrimSuffix(calendar, "\r\n"), "\r\n") {
+		if len(line) > icalMaxLineOctets {
+			t.Errorf("line is not folded: %q", line)
+		}
+	}
+	for _, want := range []string{
+		"UID:DDone@subscription-service\r\n",
+		"DTSTART:20230306T033000Z\r\n",
+		`no chili\, extra rice\; thanks`,
+		"STATUS:CANCELLED\r\n",
+		"SEQUENCE:2\r\n",
+	} {
+		if !strings.Contains(strings.ReplaceAll(calendar, "\r\n ", ""), want) {
+			t.Errorf("calendar does not contain %q", want)
+		}
+	}
+
+	// the feed can be read back by the parser used for blackout imports
+	events, err := parseICalEvents(strings.NewReader(calendar))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 2 || events[0].UID != "DDone@subscription-service" || events[1].Properties["STATUS"] != "CANCELLED" {
+		t.Errorf("got %+v", events)
+	}
+}
+
+func TestDeliveryEndUsesTheSlot(t *testing.T) {
+	loc, _ := loadLocation("Asia/Singapore")
+	expected := time.Date(2023, 3, 6, 11, 15, 0, 0, loc)
+	slot := data.DeliverySlot{ID: "SL1", StartTime: "11:00", EndTime: "12:00"}
+
+	if end := deliveryEnd(expected, slot, loc); !end.Equal(time.Date(2023, 3, 6, 12, 0, 0, 0, loc)) {
+		t.Errorf("got %v, want the end of the slot", end)
+	}
+	if end := deliveryEnd(expected, data.DeliverySlot{}, loc); !end.Equal(expected.Add(calendarEventDuration)) {
+		t.Errorf("got %v", end)
+	}
+}
+
+func TestFeedTokenIsNotLogged(t *testing.T) {
+	var logged bytes.Buffer
+	logger := middleware.RequestLogger(&middleware.DefaultLogFormatter{Logger: log.New(&logged, "", 0), NoColor: true})
+	var token string
+	handler := withoutQueryInLog(logger(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token = r.URL.Query().Get("token")
+	})))
+
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/calendar/U1/deliveries.ics?token=secret", nil))
+	if token != "secret" {
+		t.Errorf("got token %q, want the handler to read it", token)
+	}
+	if !strings.Contains(logged.String(), "/calendar/U1/deliveries.ics") || strings.Contains(logged.String(), "secret") {
+		t.Errorf("got %q in the log", logged.String())
+	}
+}
+
+func TestUpcomingCalendarDeliveries(t *testing.T) {
+	ctx := context.Background()
+	repo := data.NewMemoryRepository()
+	sub := data.Subscription{ID: "Sub1", UserID: "U1", Status: string(StatusActive), Frequency: "FREQ=DAILY", StartDate: day(2023, 3, 1), TimeZone: "UTC", ReceiverName: "Tan"}
+	if _, err := repo.InsertSubscription(ctx, sub); err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []string{"SD1", "SDRemoved"} {
+		dish := data.SubscriptionDish{ID: id, SubscriptionID: sub.ID, DishID: "D1", Frequency: "FREQ=DAILY", ScheduleTime: day(2023, 3, 1).Add(9 * time.Hour)}
+		if _, err := repo.InsertDishes(ctx, dish); err != nil {
+			t.Fatal(err)
+		}
+	}
+	now := day(2023, 3, 6)
+	for _, delivery := range []data.DishDelivery{
+		{ID: "DDpast", SubscriptionDishID: "SD1", ExpectedTime: now.Add(-15 * time.Hour)},
+		{ID: "DDone", SubscriptionDishID: "SD1", ExpectedTime: now.Add(9 * time.Hour)},
+		{ID: "DDtwo", SubscriptionDishID: "SD1", ExpectedTime: now.Add(33 * time.Hour)},
+		{ID: "DDremoved", SubscriptionDishID: "SDRemoved", ExpectedTime: now.Add(9 * time.Hour)},
+	} {
+		delivery.Status = string(DeliveryScheduled)
+		if _, err := repo.InsertDishDelivery(ctx, delivery); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := repo.RemoveSubscriptionDish(ctx, now, "SDRemoved"); err != nil {
+		t.Fatal(err)
+	}
+	history := data.ReceiverHistory{ID: "RH1", ValidUntil: day(2023, 3, 7), ChangedBy: "U1", ChangedAt: now}
+	if _, err := repo.ChangeSubscriptionReceiver(ctx, sub.ID, data.ReceiverDetails{ReceiverName: "Lim"}, history); err != nil {
+		t.Fatal(err)
+	}
+	rescheduled := data.DishDelivery{ID: "DDtwo", SubscriptionDishID: "SD1", Status: string(DeliveryScheduled), ExpectedTime: now.Add(34 * time.Hour)}
+	if _, err := repo.UpdateDishDelivery(ctx, rescheduled); err != nil {
+		t.Fatal(err)
+	}
+	service := &SubscriptionService{DBConnection: repo, AppConfig: &AppConfiguration{}}
+
+	deliveries, err := service.upcomingCalendarDeliveries(ctx, sub.UserID, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deliveries) != 2 || deliveries[0].Delivery.ID != "DDone" || deliveries[1].Delivery.ID != "DDtwo" {
+		t.Fatalf("got %+v, want the upcoming deliveries of the dish still subscribed to", deliveries)
+	}
+	if deliveries[0].Receiver.ReceiverName != "Tan" || deliveries[1].Receiver.ReceiverName != "Lim" {
+		t.Errorf("got %v and %v, want the receiver before and after the change", deliveries[0].Receiver, deliveries[1].Receiver)
+	}
+	if deliveries[0].Sequence != 0 || deliveries[1].Sequence != 1 {
+		t.Errorf("got sequences %d and %d, want only the rescheduled delivery revised", deliveries[0].Sequence, deliveries[1].Sequence)
+	}
+}
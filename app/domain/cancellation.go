@@ -0,0 +1,265 @@
+package domain
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/ClaudiaYao/CapstoneSubscriptionService/app/data"
+	"github.com/lithammer/shortuuid"
+)
+
+// CancellationReason is the reason code a customer picks when cancelling.
+type CancellationReason string
+
+const (
+	ReasonTooExpensive   CancellationReason = "too_expensive"
+	ReasonNotSatisfied   CancellationReason = "not_satisfied"
+	ReasonMoving         CancellationReason = "moving"
+	ReasonNoLongerNeeded CancellationReason = "no_longer_needed"
+	ReasonOther          CancellationReason = "other"
+)
+
+var (
+	ErrInvalidCancellationReason = errors.New("unknown cancellation reason code")
+	ErrUndoWindowClosed          = errors.New("the cancellation can no longer be undone")
+)
+
+type CancellationRequested struct {
+	ReasonCode CancellationReason `json:"reasonCode,omitempty"`
+	Comment    string             `json:"comment,omitempty"`
+}
+
+type CancellationResult struct {
+	Subscription        data.Subscription             `json:"subscription"`
+	Cancellation        data.SubscriptionCancellation `json:"cancellation"`
+	CancelledDeliveries []data.DishDelivery           `json:"cancelledDeliveries"`
+	// deliveries inside the cut-off which still go out
+	RemainingDeliveries []data.DishDelivery `json:"remainingDeliveries,omitempty"`
+}
+
+type UndoCancellationResult struct {
+	Subscription       data.Subscription   `json:"subscription"`
+	RestoredDeliveries []data.DishDelivery `json:"restoredDeliveries"`
+}
+
+// IsValid reports whether the reason is one of the known reason codes. No reason at all is valid too.
+func (r CancellationReason) IsValid() bool {
+	switch r {
+	case "", ReasonTooExpensive, ReasonNotSatisfied, ReasonMoving, ReasonNoLongerNeeded, ReasonOther:
+		return true
+	}
+	return false
+}
+
+// CancelSubscriptionRelatedRecords cancels the subscription and its upcoming deliveries.
+// Scheduled deliveries within the configured cut-off still go out, suspended ones are
+// cancelled whenever they are. The cancellation can be undone within the undo window.
+func (service *SubscriptionService) CancelSubscriptionRelatedRecords(ctx context.Context, subscriptionID string, request CancellationRequested, actor string) (*CancellationResult, error) {
+	if !request.ReasonCode.IsValid() {
+		return nil, ErrInvalidCancellationReason
+	}
+
+	var result *CancellationResult
+	err := service.inTransaction(ctx, func(tx *SubscriptionService) (err error) {
+		result, err = tx.cancelSubscription(ctx, subscriptionID, request, actor)
+		return err
+	})
+	return result, err
+}
+
+func (service *SubscriptionService) cancelSubscription(ctx context.Context, subscriptionID string, request CancellationRequested, actor string) (*CancellationResult, error) {
+	before, err := service.DBConnection.GetSubscriptionByID(ctx, subscriptionID)
+	if err != nil {
+		return nil, err
+	}
+
+	sub, err := service.ChangeSubscriptionStatus(ctx, subscriptionID, StatusCancelled, actor)
+	if err != nil {
+		return nil, fmt.Errorf("error when changing the subscription: %w", err)
+	}
+
+	now := service.now()
+	cutoff := now.Add(service.AppConfig.CancellationCutoff)
+	cancellation := data.SubscriptionCancellation{
+		ID:             "SC" + shortuuid.New(),
+		SubscriptionID: subscriptionID,
+		ReasonCode:     string(request.ReasonCode),
+		Comment:        request.Comment,
+		PreviousStatus: before.Status,
+		CancelledBy:    actor,
+		CancelledAt:    now,
+		UndoUntil:      now.Add(service.AppConfig.CancellationUndoWindow),
+	}
+	if err := service.DBConnection.InsertSubscriptionCancellation(ctx, cancellation); err != nil {
+		return nil, fmt.Errorf("error when inserting the cancellation: %w", err)
+	}
+
+	windows := []data.DeliveryWindowUpdate{
+		{SubscriptionID: subscriptionID, FromStatus: string(DeliveryScheduled), ToStatus: string(DeliveryCancelled), From: cutoff},
+		{SubscriptionID: subscriptionID, FromStatus: string(DeliverySuspended), ToStatus: string(DeliveryCancelled)},
+	}
+
+	cancelled := []data.DishDelivery{}
+	for _, window := range windows {
+		deliveries, err := service.changeDeliveryStatusInWindow(ctx, window)
+		if err != nil {
+			return nil, fmt.Errorf("error when updating the dish delivery status: %w", err)
+		}
+		for _, delivery := range deliveries {
+			err := service.DBConnection.InsertCancelledDelivery(ctx, data.CancelledDelivery{
+				CancellationID: cancellation.ID,
+				DishDeliveryID: delivery.ID,
+				PreviousStatus: window.FromStatus,
+			})
+			if err != nil {
+				return nil, fmt.Errorf("error when recording the cancelled delivery: %w", err)
+			}
+		}
+		cancelled = append(cancelled, deliveries...)
+	}
+
+	// C: cancelled deliveries give their place in the delivery slot back
+	dishes, err := service.DBConnection.GetDishBySubscriptionID(ctx, subscriptionID)
+	if err != nil {
+		return nil, fmt.Errorf("error when querying the dishes: %w", err)
+	}
+	if err := service.moveSlotPlaces(ctx, sub, dishes, cancelled, nil); err != nil {
+		return nil, err
+	}
+
+	remaining, err := service.remainingDeliveries(ctx, subscriptionID, now)
+	if err != nil {
+		return nil, err
+	}
+
+	loc := subscriptionLocation(sub)
+	cancellation.CancelledAt = localTime(cancellation.CancelledAt, loc)
+	cancellation.UndoUntil = localTime(cancellation.UndoUntil, loc)
+	return &CancellationResult{
+		Subscription:        sub,
+		Cancellation:        cancellation,
+		CancelledDeliveries: localDeliveries(cancelled, loc),
+		RemainingDeliveries: localDeliveries(remaining, loc),
+	}, nil
+}
+
+// UndoCancellation restores the latest cancellation of the subscription, as long as its
+// undo window is still open: the subscription and every delivery the cancellation touched
+// get their previous status back.
+func (service *SubscriptionService) UndoCancellation(ctx context.Context, subscriptionID string, actor string) (*UndoCancellationResult, error) {
+	var result *UndoCancellationResult
+	err := service.inTransaction(ctx, func(tx *SubscriptionService) (err error) {
+		result, err = tx.undoCancellation(ctx, subscriptionID, actor)
+		return err
+	})
+	return result, err
+}
+
+func (service *SubscriptionService) undoCancellation(ctx context.Context, subscriptionID string, actor string) (*UndoCancellationResult, error) {
+	cancellation, err := service.DBConnection.GetLatestSubscriptionCancellation(ctx, subscriptionID)
+	if err != nil {
+		return nil, err
+	}
+
+	now := service.now()
+	if now.After(cancellation.UndoUntil) {
+		return nil, ErrUndoWindowClosed
+	}
+
+	cancelled, err := service.DBConnection.GetCancelledDeliveries(ctx, cancellation.ID)
+	if err != nil {
+		return nil, fmt.Errorf("error when querying the cancelled deliveries: %w", err)
+	}
+
+	sub, err := service.reopenSubscription(ctx, subscriptionID, SubscriptionStatus(cancellation.PreviousStatus), actor)
+	if err != nil {
+		return nil, fmt.Errorf("error when restoring the subscription: %w", err)
+	}
+
+	restored := []data.DishDelivery{}
+	for _, delivery := range cancelled {
+		updated, err := service.DBConnection.ChangeDishDeliveryStatusByID(ctx, string(DeliveryCancelled), delivery.PreviousStatus, delivery.DishDeliveryID)
+		if errors.Is(err, data.ErrUpdateFailed) {
+			// the delivery was changed after the cancellation, leave it as it is
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("error when restoring the dish delivery: %w", err)
+		}
+		err = service.audit(ctx, subscriptionID, AuditDelivery, updated.ID, AuditStatusChanged, auditStatus{Status: string(DeliveryCancelled)}, updated)
+		if err != nil {
+			return nil, err
+		}
+		restored = append(restored, updated)
+	}
+
+	// the deliveries need their delivery slots back, which may have been taken meanwhile
+	dishes, err := service.DBConnection.GetDishBySubscriptionID(ctx, subscriptionID)
+	if err != nil {
+		return nil, fmt.Errorf("error when querying the dishes: %w", err)
+	}
+	if err := service.moveSlotPlaces(ctx, sub, dishes, nil, restored); err != nil {
+		return nil, err
+	}
+
+	if err := service.DBConnection.MarkCancellationUndone(ctx, now, cancellation.ID); err != nil {
+		return nil, fmt.Errorf("error when closing the cancellation: %w", err)
+	}
+
+	return &UndoCancellationResult{
+		Subscription:       sub,
+		RestoredDeliveries: localDeliveries(restored, subscriptionLocation(sub)),
+	}, nil
+}
+
+// remainingDeliveries lists the deliveries of the subscription which still go out after now.
+func (service *SubscriptionService) remainingDeliveries(ctx context.Context, subscriptionID string, now time.Time) ([]data.DishDelivery, error) {
+	dishes, err := service.DBConnection.GetDishBySubscriptionID(ctx, subscriptionID)
+	if err != nil {
+		return nil, fmt.Errorf("error when querying the dishes: %w", err)
+	}
+
+	remaining := []data.DishDelivery{}
+	for _, dish := range dishes {
+		deliveries, err := service.DBConnection.GetDishDeliveryCondition(ctx, dish.ID)
+		if err != nil {
+			return nil, fmt.Errorf("error when querying the dish deliveries: %w", err)
+		}
+		for _, delivery := range deliveries {
+			status := DeliveryStatus(delivery.Status)
+			if delivery.ExpectedTime.After(now) && (status == DeliveryScheduled || status == DeliveryPreparing || status == DeliveryOutForDelivery) {
+				remaining = append(remaining, delivery)
+			}
+		}
+	}
+	return remaining, nil
+}
+
+// cancellationMessage is the body of the cancellation confirmation mail. The times of the
+// result are already in the receiver's time zone.
+func cancellationMessage(result *CancellationResult) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Your subscription %s is cancelled.\n", result.Subscription.ID)
+
+	if len(result.CancelledDeliveries) == 0 {
+		b.WriteString("No upcoming deliveries had to be cancelled.\n")
+	} else {
+		b.WriteString("The following deliveries are cancelled:\n")
+		for _, delivery := range result.CancelledDeliveries {
+			fmt.Fprintf(&b, "- %s (dish %s) on %s\n", delivery.ID, delivery.SubscriptionDishID, delivery.ExpectedTime.Format(mailTimeLayout))
+		}
+	}
+
+	if len(result.RemainingDeliveries) > 0 {
+		b.WriteString("These deliveries are too close to be cancelled and will still arrive:\n")
+		for _, delivery := range result.RemainingDeliveries {
+			fmt.Fprintf(&b, "- %s (dish %s) on %s\n", delivery.ID, delivery.SubscriptionDishID, delivery.ExpectedTime.Format(mailTimeLayout))
+		}
+	}
+
+	fmt.Fprintf(&b, "You can undo the cancellation until %s.\n", result.Cancellation.UndoUntil.Format(mailTimeLayout))
+	return b.String()
+}
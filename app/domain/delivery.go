@@ -0,0 +1,222 @@
+package domain
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/ClaudiaYao/CapstoneSubscriptionService/app/data"
+	"github.com/ClaudiaYao/CapstoneSubscriptionService/app/recurrence"
+)
+
+var (
+	ErrDeliveryNotScheduled   = errors.New("only scheduled deliveries of an active subscription can be changed")
+	ErrDeliveryInPast         = errors.New("delivery time has already passed")
+	ErrDeliveryOutOfRange     = errors.New("delivery time is outside the subscription period")
+	ErrDeliveryOutOfFrequency = errors.New("delivery can only be moved between its previous and next delivery")
+)
+
+// SkipDishDelivery skips one upcoming delivery without touching the rest of the dish's schedule.
+func (service *SubscriptionService) SkipDishDelivery(ctx context.Context, deliveryID string) (data.DishDelivery, error) {
+	delivery, dish, sub, err := service.loadScheduledDelivery(ctx, deliveryID)
+	if err != nil {
+		return delivery, err
+	}
+	if delivery.ExpectedTime.Before(service.now()) {
+		return delivery, ErrDeliveryInPast
+	}
+
+	before := delivery
+	delivery.Status = string(DeliverySkipped)
+	updated, err := service.updateDishDelivery(ctx, sub, dish, AuditStatusChanged, before, delivery)
+	if errors.Is(err, data.ErrUpdateFailed) {
+		return updated, ErrDeliveryNotScheduled
+	}
+	return localDelivery(updated, subscriptionLocation(sub)), err
+}
+
+// RescheduleDishDelivery moves one upcoming delivery to another date/time. An empty note
+// keeps the current note of the delivery.
+func (service *SubscriptionService) RescheduleDishDelivery(ctx context.Context, deliveryID string, expectedTime time.Time, note string) (data.DishDelivery, error) {
+	delivery, dish, sub, err := service.loadScheduledDelivery(ctx, deliveryID)
+	if err != nil {
+		return delivery, err
+	}
+
+	siblings, err := service.DBConnection.GetDishDeliveryCondition(ctx, dish.ID)
+	if err != nil {
+		return delivery, fmt.Errorf("error when querying the dish deliveries: %w", err)
+	}
+
+	if err := validateReschedule(sub, dish, delivery, siblings, expectedTime, service.now()); err != nil {
+		return delivery, err
+	}
+
+	calendar, err := service.loadBlackoutCalendar(ctx, sub, dish, expectedTime, expectedTime)
+	if err != nil {
+		return delivery, err
+	}
+	if blackout, blocked := calendar.blackoutOn(civilDate(expectedTime, subscriptionLocation(sub))); blocked {
+		return delivery, fmt.Errorf("%w: %s", ErrDeliveryOnBlackout, blackout.Summary)
+	}
+
+	moved := delivery
+	moved.ExpectedTime = expectedTime
+	conflicts, err := service.openingHoursConflicts(ctx, dish, []data.DishDelivery{moved}, subscriptionLocation(sub))
+	if err != nil {
+		return delivery, err
+	}
+	if len(conflicts) > 0 {
+		return delivery, &OpeningHoursError{Conflicts: conflicts}
+	}
+
+	before := delivery
+	delivery.ExpectedTime = expectedTime
+	if note != "" {
+		delivery.Note = note
+	}
+	updated, err := service.updateDishDelivery(ctx, sub, dish, AuditRescheduled, before, delivery)
+	if errors.Is(err, data.ErrUpdateFailed) {
+		return updated, ErrDeliveryNotScheduled
+	}
+	return localDelivery(updated, subscriptionLocation(sub)), err
+}
+
+// ProgressDishDelivery moves a delivery forward in the courier flow. Reaching Delivered
+// or Failed stamps the delivery time; a non empty note replaces the delivery note.
+func (service *SubscriptionService) ProgressDishDelivery(ctx context.Context, deliveryID string, toStatus DeliveryStatus, note string) (data.DishDelivery, error) {
+	delivery, err := service.DBConnection.GetDishDeliveryByID(ctx, deliveryID)
+	if err != nil {
+		return delivery, err
+	}
+
+	fromStatus := DeliveryStatus(delivery.Status)
+	if err := fromStatus.ValidateProgress(toStatus); err != nil {
+		return delivery, err
+	}
+	before := delivery
+
+	delivery.Status = string(toStatus)
+	if toStatus.IsFinal() {
+		delivery.DeliveryTime = service.now()
+	}
+	if note != "" {
+		delivery.Note = note
+	}
+
+	var updated data.DishDelivery
+	err = service.inTransaction(ctx, func(tx *SubscriptionService) (err error) {
+		updated, err = tx.DBConnection.UpdateDeliveryProgress(ctx, string(fromStatus), delivery)
+		if err != nil {
+			return err
+		}
+		return tx.auditDelivery(ctx, AuditStatusChanged, before, updated)
+	})
+	if errors.Is(err, data.ErrUpdateFailed) {
+		// another courier update won the race
+		return updated, &DeliveryProgressError{From: fromStatus, To: toStatus}
+	}
+	if err != nil {
+		return updated, err
+	}
+
+	loc, err := service.dishLocation(ctx, updated.SubscriptionDishID)
+	if err != nil {
+		return updated, err
+	}
+	return localDelivery(updated, loc), nil
+}
+
+// updateDishDelivery stores the changed delivery together with the record of its change.
+// A delivery moved to another time takes its slot place along.
+func (service *SubscriptionService) updateDishDelivery(ctx context.Context, sub data.Subscription, dish data.SubscriptionDish, action AuditAction, before, delivery data.DishDelivery) (updated data.DishDelivery, err error) {
+	err = service.inTransaction(ctx, func(tx *SubscriptionService) error {
+		updated, err = tx.DBConnection.UpdateDishDelivery(ctx, delivery)
+		if err != nil {
+			return err
+		}
+		if !updated.ExpectedTime.Equal(before.ExpectedTime) && holdsSlotPlace(before) {
+			err := tx.moveSlotPlaces(ctx, sub, []data.SubscriptionDish{dish}, []data.DishDelivery{before}, []data.DishDelivery{updated})
+			if err != nil {
+				return err
+			}
+		}
+		return tx.audit(ctx, sub.ID, AuditDelivery, updated.ID, action, before, updated)
+	})
+	return updated, err
+}
+
+// loadScheduledDelivery returns the delivery together with its dish and subscription,
+// and makes sure the delivery is still waiting to be delivered.
+func (service *SubscriptionService) loadScheduledDelivery(ctx context.Context, deliveryID string) (data.DishDelivery, data.SubscriptionDish, data.Subscription, error) {
+	var dish data.SubscriptionDish
+	var sub data.Subscription
+
+	delivery, err := service.DBConnection.GetDishDeliveryByID(ctx, deliveryID)
+	if err != nil {
+		return delivery, dish, sub, err
+	}
+	if delivery.Status != string(DeliveryScheduled) {
+		return delivery, dish, sub, fmt.Errorf("%w: delivery is %s", ErrDeliveryNotScheduled, delivery.Status)
+	}
+
+	dish, err = service.DBConnection.GetSubscriptionDishByID(ctx, delivery.SubscriptionDishID)
+	if err != nil {
+		return delivery, dish, sub, err
+	}
+
+	sub, err = service.DBConnection.GetSubscriptionByID(ctx, dish.SubscriptionID)
+	if err != nil {
+		return delivery, dish, sub, err
+	}
+	if SubscriptionStatus(sub.Status) != StatusActive {
+		return delivery, dish, sub, fmt.Errorf("%w: subscription is %s", ErrDeliveryNotScheduled, sub.Status)
+	}
+
+	return delivery, dish, sub, nil
+}
+
+// validateReschedule checks that the new time is in the future, inside the subscription
+// period, and still between the previous and next delivery of the same dish, so moving
+// one delivery never breaks the dish's frequency.
+func validateReschedule(sub data.Subscription, dish data.SubscriptionDish, delivery data.DishDelivery, siblings []data.DishDelivery, expectedTime time.Time, now time.Time) error {
+	if !expectedTime.After(now) {
+		return ErrDeliveryInPast
+	}
+	// C: start and end are dates in the subscription's zone, deliveries on the last day
+	// C: are still inside the period
+	loc := subscriptionLocation(sub)
+	if expectedTime.Before(startOfDay(sub.StartDate, loc)) {
+		return ErrDeliveryOutOfRange
+	}
+	if !sub.EndDate.IsZero() && expectedTime.After(endOfDay(sub.EndDate, loc)) {
+		return ErrDeliveryOutOfRange
+	}
+
+	rule, err := recurrence.Parse(dish.Frequency)
+	if err != nil {
+		return err
+	}
+
+	// the first delivery has no previous one and the last one of a rule with COUNT or
+	// UNTIL no next one
+	dtstart := dish.ScheduleTime.In(loc)
+	lower, _ := rule.Before(dtstart, delivery.ExpectedTime)
+	upper, bounded := rule.After(dtstart, delivery.ExpectedTime)
+	for _, sibling := range siblings {
+		if sibling.ID == delivery.ID || sibling.Status == string(DeliverySkipped) || sibling.Status == string(DeliveryCancelled) {
+			continue
+		}
+		if !sibling.ExpectedTime.After(delivery.ExpectedTime) && sibling.ExpectedTime.After(lower) {
+			lower = sibling.ExpectedTime
+		}
+		if !sibling.ExpectedTime.Before(delivery.ExpectedTime) && (!bounded || sibling.ExpectedTime.Before(upper)) {
+			upper, bounded = sibling.ExpectedTime, true
+		}
+	}
+	if !expectedTime.After(lower) || (bounded && !expectedTime.Before(upper)) {
+		return ErrDeliveryOutOfFrequency
+	}
+	return nil
+}
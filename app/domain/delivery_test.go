@@ -0,0 +1,45 @@
+package domain
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/ClaudiaYao/CapstoneSubscriptionService/app/data"
+)
+
+func TestValidateReschedule(t *testing.T) {
+	start := time.Date(2023, 4, 1, 0, 0, 0, 0, time.UTC)
+	sub := data.Subscription{StartDate: start, EndDate: start.AddDate(0, 0, 13)}
+	dish := data.SubscriptionDish{ID: "SDish1", ScheduleTime: start.Add(12 * time.Hour), Frequency: "FREQ=WEEKLY"}
+
+	siblings := []data.DishDelivery{}
+	for i := 0; i < 2; i++ {
+		siblings = append(siblings, data.DishDelivery{
+			ID:           "DD" + string(rune('a'+i)),
+			Status:       string(DeliveryScheduled),
+			ExpectedTime: start.Add(12*time.Hour).AddDate(0, 0, 7*i),
+		})
+	}
+	delivery := siblings[0]
+	now := start.Add(-24 * time.Hour)
+
+	tests := []struct {
+		name string
+		to   time.Time
+		want error
+	}{
+		{"within the same week", delivery.ExpectedTime.AddDate(0, 0, 3), nil},
+		{"before now", now.Add(-time.Hour), ErrDeliveryInPast},
+		{"before the start date", start.Add(-time.Hour), ErrDeliveryOutOfRange},
+		{"after the end date", sub.EndDate.AddDate(0, 0, 2), ErrDeliveryOutOfRange},
+		{"on the next delivery", siblings[1].ExpectedTime, ErrDeliveryOutOfFrequency},
+	}
+
+	for _, tt := range tests {
+		err := validateReschedule(sub, dish, delivery, siblings, tt.to, now)
+		if !errors.Is(err, tt.want) {
+			t.Errorf("%s: expected %v, got %v", tt.name, tt.want, err)
+		}
+	}
+}
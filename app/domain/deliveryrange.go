@@ -0,0 +1,160 @@
+package domain
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/ClaudiaYao/CapstoneSubscriptionService/app/data"
+)
+
+// deliveryDateLayout is how the days of a delivery range are written
+const deliveryDateLayout = "2006-01-02"
+
+// maxDeliveryRangeDays limits how many days of deliveries are read at once
+const maxDeliveryRangeDays = 92
+
+// defaultDeliveryRangeDays is the length of the range when no end is given
+const defaultDeliveryRangeDays = 7
+
+var ErrInvalidDateRange = errors.New("invalid date range")
+
+// DeliveryRange is a range of civil dates, both included. The dates are read in the time
+// zone of each delivery's subscription, so a day means the same to every customer.
+type DeliveryRange struct {
+	From time.Time
+	To   time.Time
+}
+
+// ParseDeliveryRange reads the from and to query parameters, written as 2006-01-02. The
+// range starts today when from is empty and lasts a week when to is empty.
+func ParseDeliveryRange(from, to string, now time.Time) (DeliveryRange, error) {
+	r := DeliveryRange{From: civilDate(now, time.UTC)}
+	if from != "" {
+		date, err := time.Parse(deliveryDateLayout, from)
+		if err != nil {
+			return r, fmt.Errorf("%w: from must be written as YYYY-MM-DD", ErrInvalidDateRange)
+		}
+		r.From = date
+	}
+
+	r.To = r.From.AddDate(0, 0, defaultDeliveryRangeDays-1)
+	if to != "" {
+		date, err := time.Parse(deliveryDateLayout, to)
+		if err != nil {
+			return r, fmt.Errorf("%w: to must be written as YYYY-MM-DD", ErrInvalidDateRange)
+		}
+		r.To = date
+	}
+
+	if r.To.Before(r.From) {
+		return r, fmt.Errorf("%w: to is before from", ErrInvalidDateRange)
+	}
+	if r.To.Sub(r.From) >= maxDeliveryRangeDays*24*time.Hour {
+		return r, fmt.Errorf("%w: at most %d days can be read at once", ErrInvalidDateRange, maxDeliveryRangeDays)
+	}
+	return r, nil
+}
+
+// bounds are the instants to query between. They are wide enough for the range to start
+// and end in every time zone, the exact days are picked per delivery.
+func (r DeliveryRange) bounds() (time.Time, time.Time) {
+	return r.From.Add(-14 * time.Hour), r.To.AddDate(0, 0, 1).Add(12 * time.Hour)
+}
+
+// ScheduledDelivery is a delivery of a delivery calendar, with the dish it brings.
+type ScheduledDelivery struct {
+	data.DeliveryInRange
+	DishName string `json:"dishName"`
+}
+
+// DeliveryDay holds the deliveries of one day, in the order they are expected.
+type DeliveryDay struct {
+	Date       string              `json:"date"`
+	Deliveries []ScheduledDelivery `json:"deliveries"`
+}
+
+// UserDeliveries returns the deliveries of all subscriptions of the user within the range.
+func (service *SubscriptionService) UserDeliveries(ctx context.Context, userID string, r DeliveryRange) ([]DeliveryDay, error) {
+	from, until := r.bounds()
+	deliveries, err := service.DBConnection.GetDeliveriesByUserInRange(ctx, userID, from, until)
+	if err != nil {
+		return nil, fmt.Errorf("error when querying the deliveries: %w", err)
+	}
+	return service.deliveryDays(ctx, deliveries, r), nil
+}
+
+// SubscriptionDeliveries returns the deliveries of the subscription within the range.
+func (service *SubscriptionService) SubscriptionDeliveries(ctx context.Context, subscriptionID string, r DeliveryRange) ([]DeliveryDay, error) {
+	if _, err := service.DBConnection.GetSubscriptionByID(ctx, subscriptionID); err != nil {
+		return nil, err
+	}
+
+	from, until := r.bounds()
+	deliveries, err := service.DBConnection.GetDeliveriesBySubscriptionInRange(ctx, subscriptionID, from, until)
+	if err != nil {
+		return nil, fmt.Errorf("error when querying the deliveries: %w", err)
+	}
+	return service.deliveryDays(ctx, deliveries, r), nil
+}
+
+// RestaurantDeliveries returns the deliveries of the restaurant's dishes within the range,
+// for the restaurant to plan its cooking.
+func (service *SubscriptionService) RestaurantDeliveries(ctx context.Context, restaurantID string, r DeliveryRange) ([]DeliveryDay, error) {
+	if service.Restaurants == nil {
+		return nil, errors.New("the restaurants of dishes are not known")
+	}
+	dishIDs, err := service.Restaurants.DishesOfRestaurant(ctx, restaurantID)
+	if err != nil {
+		return nil, err
+	}
+
+	from, until := r.bounds()
+	deliveries, err := service.DBConnection.GetDeliveriesByDishesInRange(ctx, dishIDs, from, until)
+	if err != nil {
+		return nil, fmt.Errorf("error when querying the deliveries: %w", err)
+	}
+	return service.deliveryDays(ctx, deliveries, r), nil
+}
+
+func (service *SubscriptionService) deliveryDays(ctx context.Context, deliveries []data.DeliveryInRange, r DeliveryRange) []DeliveryDay {
+	names := map[string]string{}
+	for _, delivery := range deliveries {
+		if _, ok := names[delivery.DishID]; !ok {
+			names[delivery.DishID] = service.dishName(ctx, delivery.DishID)
+		}
+	}
+	return groupDeliveriesByDay(deliveries, names, r)
+}
+
+// groupDeliveriesByDay puts the deliveries within the range under their local day. Days
+// without deliveries are left out.
+func groupDeliveriesByDay(deliveries []data.DeliveryInRange, names map[string]string, r DeliveryRange) []DeliveryDay {
+	days := map[time.Time][]ScheduledDelivery{}
+	for _, delivery := range deliveries {
+		loc, err := loadLocation(delivery.TimeZone)
+		if err != nil {
+			loc = time.UTC
+		}
+		date := civilDate(delivery.ExpectedTime, loc)
+		if date.Before(r.From) || date.After(r.To) {
+			continue
+		}
+		delivery.DishDelivery = localDelivery(delivery.DishDelivery, loc)
+		days[date] = append(days[date], ScheduledDelivery{DeliveryInRange: delivery, DishName: names[delivery.DishID]})
+	}
+
+	grouped := make([]DeliveryDay, 0, len(days))
+	for date, scheduled := range days {
+		sort.SliceStable(scheduled, func(i, j int) bool {
+			return scheduled[i].ExpectedTime.Before(scheduled[j].ExpectedTime)
+		})
+		grouped = append(grouped, DeliveryDay{Date: date.Format(deliveryDateLayout), Deliveries: scheduled})
+	}
+	sort.Slice(grouped, func(i, j int) bool {
+		return grouped[i].Date < grouped[j].Date
+	})
+	return grouped
+}
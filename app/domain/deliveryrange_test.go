@@ -0,0 +1,55 @@
+package domain
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/ClaudiaYao/CapstoneSubscriptionService/app/data"
+)
+
+func TestParseDeliveryRange(t *testing.T) {
+	now := time.Date(2023, 3, 6, 15, 0, 0, 0, time.UTC)
+
+	r, err := ParseDeliveryRange("", "", now)
+	if err != nil || !r.From.Equal(day(2023, 3, 6)) || !r.To.Equal(day(2023, 3, 12)) {
+		t.Errorf("got %v %v, want the coming week", r, err)
+	}
+
+	for _, tt := range [][2]string{{"2023-03-06", "2023-03-05"}, {"06/03/2023", ""}, {"2023-01-01", "2023-12-31"}} {
+		if _, err := ParseDeliveryRange(tt[0], tt[1], now); !errors.Is(err, ErrInvalidDateRange) {
+			t.Errorf("%v: got %v, want ErrInvalidDateRange", tt, err)
+		}
+	}
+}
+
+func TestGroupDeliveriesByDayUsesTheLocalDay(t *testing.T) {
+	singapore, _ := loadLocation("Asia/Singapore")
+	delivery := func(id, timeZone string, expected time.Time) data.DeliveryInRange {
+		return data.DeliveryInRange{
+			DishDelivery: data.DishDelivery{ID: id, Status: string(DeliveryScheduled), ExpectedTime: expected.UTC()},
+			DishID:       "Dish1",
+			TimeZone:     timeZone,
+		}
+	}
+	deliveries := []data.DeliveryInRange{
+		// 7 March in Singapore but still 6 March in UTC
+		delivery("DDsgp", "Asia/Singapore", time.Date(2023, 3, 7, 7, 0, 0, 0, singapore)),
+		delivery("DDutc", "UTC", time.Date(2023, 3, 6, 23, 30, 0, 0, time.UTC)),
+		delivery("DDearly", "UTC", time.Date(2023, 3, 6, 8, 0, 0, 0, time.UTC)),
+		// the query bounds are wider than the range
+		delivery("DDbefore", "Asia/Singapore", time.Date(2023, 3, 5, 23, 0, 0, 0, singapore)),
+	}
+
+	days := groupDeliveriesByDay(deliveries, map[string]string{"Dish1": "Laksa"}, DeliveryRange{From: day(2023, 3, 6), To: day(2023, 3, 7)})
+	if len(days) != 2 || days[0].Date != "2023-03-06" || days[1].Date != "2023-03-07" {
+		t.Fatalf("got %+v", days)
+	}
+	if len(days[0].Deliveries) != 2 || days[0].Deliveries[0].ID != "DDearly" || days[0].Deliveries[1].ID != "DDutc" {
+		t.Errorf("got %+v on the first day", days[0].Deliveries)
+	}
+	got := days[1].Deliveries[0]
+	if got.ID != "DDsgp" || got.DishName != "Laksa" || got.ExpectedTime.Location() != singapore {
+		t.Errorf("got %+v on the second day", got)
+	}
+}
@@ -0,0 +1,359 @@
+package domain
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/ClaudiaYao/CapstoneSubscriptionService/app/data"
+	"github.com/ClaudiaYao/CapstoneSubscriptionService/app/recurrence"
+	"github.com/lithammer/shortuuid"
+)
+
+var (
+	ErrSubscriptionNotActive = errors.New("dishes can only be changed on an active subscription")
+	ErrScheduleTimeRequired  = errors.New("a dish needs a schedule time")
+)
+
+// removedStatuses are the statuses of future deliveries deleted together with their dish.
+// Deliveries already being prepared are left alone.
+var removedStatuses = []DeliveryStatus{
+	DeliveryScheduled,
+	DeliverySuspended,
+	DeliverySkipped,
+}
+
+type DishChangeResult struct {
+	Dish              data.SubscriptionDishDTO `json:"dish"`
+	RemovedDeliveries int64                    `json:"removedDeliveries"`
+	Deliveries        []data.DishDelivery      `json:"deliveries,omitempty"`
+}
+
+// SubscriptionDishChangeRequested holds the fields of a subscription dish that can be
+// changed. Fields which are left out keep their current value.
+type SubscriptionDishChangeRequested struct {
+	Frequency   string     `json:"frequency,omitempty"`
+	DishOptions [][]string `json:"dishOptions,omitempty"`
+	Note        *string    `json:"note,omitempty"`
+}
+
+// AddSubscriptionDish adds a dish to a live subscription and creates its upcoming deliveries.
+func (service *SubscriptionService) AddSubscriptionDish(ctx context.Context, subscriptionID string, dishInfo SubscriptionDishRequested) (*DishChangeResult, error) {
+	var result *DishChangeResult
+	err := service.inTransaction(ctx, func(tx *SubscriptionService) (err error) {
+		result, err = tx.addSubscriptionDish(ctx, subscriptionID, dishInfo)
+		return err
+	})
+	return result, err
+}
+
+func (service *SubscriptionService) addSubscriptionDish(ctx context.Context, subscriptionID string, dishInfo SubscriptionDishRequested) (*DishChangeResult, error) {
+	if dishInfo.ScheduleTime.IsZero() {
+		return nil, ErrScheduleTimeRequired
+	}
+	sub, err := service.activeSubscription(ctx, subscriptionID)
+	if err != nil {
+		return nil, err
+	}
+
+	frequency := dishInfo.Frequency
+	if frequency == "" {
+		frequency = sub.Frequency
+	}
+	frequency, err = recurrence.NormalizeFrequency(frequency)
+	if err != nil {
+		return nil, err
+	}
+
+	optionB, err := json.Marshal(dishInfo.DishOptions)
+	if err != nil {
+		return nil, err
+	}
+
+	dish := data.SubscriptionDish{
+		ID:             "SDish" + shortuuid.New(),
+		DishID:         dishInfo.DishID,
+		SubscriptionID: subscriptionID,
+		ScheduleTime:   dishInfo.ScheduleTime,
+		Frequency:      frequency,
+		DishOptions:    string(optionB),
+		Note:           dishInfo.Note,
+	}
+
+	// C: an added dish is delivered in a slot like the dishes the subscription started with
+	slots, err := service.DBConnection.GetActiveDeliverySlots(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("error when querying the delivery slots: %w", err)
+	}
+	if len(slots) > 0 {
+		if sub.PostalCode == "" {
+			return nil, ErrPostalCodeRequired
+		}
+		slot, err := assignSlot(slots, dishInfo.DishID, dishInfo.SlotID, dishInfo.ScheduleTime.In(subscriptionLocation(sub)))
+		if err != nil {
+			return nil, err
+		}
+		dish.SlotID = slot.ID
+	}
+
+	// like a new subscription, the dish is only stored when its restaurant is open for
+	// every planned delivery
+	now := service.now()
+	loc := subscriptionLocation(sub)
+	planned, err := service.planDeliveries(ctx, sub, dish, service.deliveryHorizon(sub, now), now)
+	if err != nil {
+		return nil, fmt.Errorf("error when planning the dish deliveries: %w", err)
+	}
+	if err := service.checkPlannedOpeningHours(ctx, []data.SubscriptionDish{dish}, map[string][]time.Time{dish.ID: planned}, loc); err != nil {
+		return nil, err
+	}
+
+	if _, err := service.DBConnection.InsertDishes(ctx, dish); err != nil {
+		return nil, fmt.Errorf("error when inserting the subscription dish: %w", err)
+	}
+	if err := service.audit(ctx, subscriptionID, AuditDish, dish.ID, AuditCreated, nil, dish); err != nil {
+		return nil, err
+	}
+
+	deliveries, err := service.insertPlannedDeliveries(ctx, sub, dish, planned)
+	if err != nil {
+		return nil, fmt.Errorf("error when inserting the dish deliveries: %w", err)
+	}
+
+	return &DishChangeResult{
+		Dish:       (*convertDishToDTO(&[]data.SubscriptionDish{localDish(dish, loc)}))[0],
+		Deliveries: localDeliveries(deliveries, loc),
+	}, nil
+}
+
+// UpdateSubscriptionDish changes the frequency, options or note of a dish. A new frequency
+// replans its future deliveries, see replanDeliveries, and a new note is given to the
+// scheduled deliveries which still had the previous one.
+func (service *SubscriptionService) UpdateSubscriptionDish(ctx context.Context, subscriptionID, subscriptionDishID string, change SubscriptionDishChangeRequested) (*DishChangeResult, error) {
+	var result *DishChangeResult
+	err := service.inTransaction(ctx, func(tx *SubscriptionService) (err error) {
+		result, err = tx.updateSubscriptionDish(ctx, subscriptionID, subscriptionDishID, change)
+		return err
+	})
+	return result, err
+}
+
+func (service *SubscriptionService) updateSubscriptionDish(ctx context.Context, subscriptionID, subscriptionDishID string, change SubscriptionDishChangeRequested) (*DishChangeResult, error) {
+	sub, err := service.activeSubscription(ctx, subscriptionID)
+	if err != nil {
+		return nil, err
+	}
+
+	dish, err := service.subscriptionDish(ctx, subscriptionID, subscriptionDishID)
+	if err != nil {
+		return nil, err
+	}
+	before := dish
+
+	if change.Frequency != "" {
+		frequency, err := recurrence.NormalizeFrequency(change.Frequency)
+		if err != nil {
+			return nil, err
+		}
+		dish.Frequency = frequency
+	}
+	if change.DishOptions != nil {
+		optionB, err := json.Marshal(change.DishOptions)
+		if err != nil {
+			return nil, err
+		}
+		dish.DishOptions = string(optionB)
+	}
+	if change.Note != nil {
+		dish.Note = *change.Note
+	}
+
+	dish, err = service.DBConnection.UpdateSubscriptionDish(ctx, dish)
+	if errors.Is(err, data.ErrUpdateFailed) {
+		return nil, data.ErrNotExist
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error when updating the subscription dish: %w", err)
+	}
+	if err := service.audit(ctx, subscriptionID, AuditDish, dish.ID, AuditUpdated, before, dish); err != nil {
+		return nil, err
+	}
+
+	now := service.now()
+	loc := subscriptionLocation(sub)
+	result := &DishChangeResult{Dish: (*convertDishToDTO(&[]data.SubscriptionDish{localDish(dish, loc)}))[0]}
+	if dish.Frequency != before.Frequency {
+		removed, deliveries, err := service.replanDeliveries(ctx, sub, before, dish, now)
+		if err != nil {
+			return nil, err
+		}
+		result.RemovedDeliveries = removed
+		result.Deliveries = localDeliveries(deliveries, loc)
+	}
+	if dish.Note != before.Note {
+		if err := service.updateDeliveryNotes(ctx, sub, before.Note, dish, now); err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+// replanDeliveries moves the future deliveries of a dish from its previous recurrence rule
+// to the new one. Only the deliveries still scheduled at the time the previous rule gave
+// them are replaced. Skipped, rescheduled and started deliveries are the subscriber's or
+// the kitchen's, they are kept and no new delivery is planned on their day.
+func (service *SubscriptionService) replanDeliveries(ctx context.Context, sub data.Subscription, previous, dish data.SubscriptionDish, now time.Time) (int64, []data.DishDelivery, error) {
+	until := service.deliveryHorizon(sub, now)
+	ruleTimes, err := service.planDeliveries(ctx, sub, previous, until, now)
+	if err != nil {
+		return 0, nil, err
+	}
+	atRuleTime := make(map[int64]bool, len(ruleTimes))
+	for _, t := range ruleTimes {
+		atRuleTime[t.UnixNano()] = true
+	}
+
+	existing, err := service.DBConnection.GetDishDeliveryCondition(ctx, dish.ID)
+	if err != nil {
+		return 0, nil, fmt.Errorf("error when querying the dish deliveries: %w", err)
+	}
+	loc := subscriptionLocation(sub)
+	var replaced []data.DishDelivery
+	keptDays := map[time.Time]bool{}
+	for _, delivery := range existing {
+		if delivery.ExpectedTime.Before(now) {
+			continue
+		}
+		if delivery.Status == string(DeliveryScheduled) && atRuleTime[delivery.ExpectedTime.UnixNano()] {
+			replaced = append(replaced, delivery)
+			continue
+		}
+		keptDays[civilDate(delivery.ExpectedTime, loc)] = true
+	}
+
+	planned, err := service.planDeliveries(ctx, sub, dish, until, now)
+	if err != nil {
+		return 0, nil, err
+	}
+	free := make([]time.Time, 0, len(planned))
+	for _, t := range planned {
+		if !keptDays[civilDate(t, loc)] {
+			free = append(free, t)
+		}
+	}
+	if err := service.checkPlannedOpeningHours(ctx, []data.SubscriptionDish{dish}, map[string][]time.Time{dish.ID: free}, loc); err != nil {
+		return 0, nil, err
+	}
+
+	removed, err := service.deleteListedDeliveries(ctx, sub, dish, DeliveryScheduled, replaced)
+	if err != nil {
+		return 0, nil, fmt.Errorf("error when deleting the dish deliveries: %w", err)
+	}
+	deliveries, err := service.insertPlannedDeliveries(ctx, sub, dish, free)
+	if err != nil {
+		return 0, nil, fmt.Errorf("error when inserting the dish deliveries: %w", err)
+	}
+	return removed, deliveries, nil
+}
+
+// updateDeliveryNotes gives the new note of the dish to its future scheduled deliveries,
+// except those which were given a note of their own when rescheduled.
+func (service *SubscriptionService) updateDeliveryNotes(ctx context.Context, sub data.Subscription, previousNote string, dish data.SubscriptionDish, now time.Time) error {
+	deliveries, err := service.DBConnection.GetDishDeliveryCondition(ctx, dish.ID)
+	if err != nil {
+		return fmt.Errorf("error when querying the dish deliveries: %w", err)
+	}
+	for _, delivery := range deliveries {
+		if delivery.ExpectedTime.Before(now) || delivery.Status != string(DeliveryScheduled) || delivery.Note != previousNote {
+			continue
+		}
+		before := delivery
+		delivery.Note = dish.Note
+		if _, err := service.updateDishDelivery(ctx, sub, dish, AuditUpdated, before, delivery); err != nil {
+			return fmt.Errorf("error when updating the delivery note: %w", err)
+		}
+	}
+	return nil
+}
+
+// RemoveSubscriptionDish takes a dish off a live subscription. Its future deliveries are
+// deleted while the dish row stays for the history of the deliveries already made.
+func (service *SubscriptionService) RemoveSubscriptionDish(ctx context.Context, subscriptionID, subscriptionDishID string) (*DishChangeResult, error) {
+	var result *DishChangeResult
+	err := service.inTransaction(ctx, func(tx *SubscriptionService) (err error) {
+		result, err = tx.removeSubscriptionDish(ctx, subscriptionID, subscriptionDishID)
+		return err
+	})
+	return result, err
+}
+
+func (service *SubscriptionService) removeSubscriptionDish(ctx context.Context, subscriptionID, subscriptionDishID string) (*DishChangeResult, error) {
+	sub, err := service.activeSubscription(ctx, subscriptionID)
+	if err != nil {
+		return nil, err
+	}
+
+	before, err := service.subscriptionDish(ctx, subscriptionID, subscriptionDishID)
+	if err != nil {
+		return nil, err
+	}
+
+	now := service.now()
+	dish, err := service.DBConnection.RemoveSubscriptionDish(ctx, now, subscriptionDishID)
+	if errors.Is(err, data.ErrDeleteFailed) {
+		return nil, data.ErrNotExist
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error when removing the subscription dish: %w", err)
+	}
+	if err := service.audit(ctx, subscriptionID, AuditDish, dish.ID, AuditRemoved, before, dish); err != nil {
+		return nil, err
+	}
+
+	removed, err := service.deleteFutureDeliveries(ctx, sub, dish, now)
+	if err != nil {
+		return nil, err
+	}
+
+	return &DishChangeResult{
+		Dish:              (*convertDishToDTO(&[]data.SubscriptionDish{localDish(dish, subscriptionLocation(sub))}))[0],
+		RemovedDeliveries: removed,
+	}, nil
+}
+
+func (service *SubscriptionService) activeSubscription(ctx context.Context, subscriptionID string) (data.Subscription, error) {
+	sub, err := service.DBConnection.GetSubscriptionByID(ctx, subscriptionID)
+	if err != nil {
+		return sub, err
+	}
+	if SubscriptionStatus(sub.Status) != StatusActive {
+		return sub, fmt.Errorf("%w: subscription is %s", ErrSubscriptionNotActive, sub.Status)
+	}
+	return sub, nil
+}
+
+// subscriptionDish returns the dish, making sure it belongs to the subscription and was not removed
+func (service *SubscriptionService) subscriptionDish(ctx context.Context, subscriptionID, subscriptionDishID string) (data.SubscriptionDish, error) {
+	dish, err := service.DBConnection.GetSubscriptionDishByID(ctx, subscriptionDishID)
+	if err != nil {
+		return dish, err
+	}
+	if dish.SubscriptionID != subscriptionID || !dish.RemovedAt.IsZero() {
+		return dish, data.ErrNotExist
+	}
+	return dish, nil
+}
+
+func (service *SubscriptionService) deleteFutureDeliveries(ctx context.Context, sub data.Subscription, dish data.SubscriptionDish, from time.Time) (int64, error) {
+	var removed int64
+	for _, status := range removedStatuses {
+		n, err := service.deleteDishDeliveries(ctx, sub, dish, status, from)
+		if err != nil {
+			return removed, fmt.Errorf("error when deleting the dish deliveries: %w", err)
+		}
+		removed += n
+	}
+	return removed, nil
+}
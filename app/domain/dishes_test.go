@@ -0,0 +1,136 @@
+package domain
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/ClaudiaYao/CapstoneSubscriptionService/app/data"
+)
+
+// testNow is the time the tests which plan deliveries run at, a Monday noon in UTC
+var testNow = time.Date(2023, 3, 6, 12, 0, 0, 0, time.UTC)
+
+// newTestSubscription stores a subscription which started the day before testNow, with one
+// dish at 09:00 on the given rule, and creates its deliveries from testNow on. A zero end
+// date makes the subscription open ended, with deliveries horizonDays ahead. The dish is
+// given a slot when repo has active delivery slots.
+func newTestSubscription(t *testing.T, repo data.SubscriptionRepository, frequency string, endDate time.Time, horizonDays int) (*SubscriptionService, data.Subscription, data.SubscriptionDish, []data.DishDelivery) {
+	t.Helper()
+	ctx := context.Background()
+	start := civilDate(testNow, time.UTC).AddDate(0, 0, -1)
+	sub := data.Subscription{ID: "Sub1", UserID: "U1", Status: string(StatusActive), Frequency: frequency, StartDate: start, EndDate: endDate, TimeZone: "UTC", PostalCode: "520123"}
+	if _, err := repo.InsertSubscription(ctx, sub); err != nil {
+		t.Fatal(err)
+	}
+	dish := data.SubscriptionDish{ID: "SD1", SubscriptionID: sub.ID, DishID: "D1", Frequency: frequency, DishOptions: "[]", ScheduleTime: start.Add(9 * time.Hour)}
+	slots, err := repo.GetActiveDeliverySlots(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(slots) > 0 {
+		slot, err := assignSlot(slots, dish.DishID, "", dish.ScheduleTime)
+		if err != nil {
+			t.Fatal(err)
+		}
+		dish.SlotID = slot.ID
+	}
+	if _, err := repo.InsertDishes(ctx, dish); err != nil {
+		t.Fatal(err)
+	}
+	service := &SubscriptionService{
+		DBConnection: repo,
+		AppConfig:    &AppConfiguration{DeliveryHorizonDays: horizonDays, PostalZoneDigits: 2},
+		Clock:        func() time.Time { return testNow },
+	}
+
+	deliveries, err := service.materializeHorizon(ctx, sub, testNow)
+	if err != nil || len(deliveries) == 0 {
+		t.Fatalf("got %d deliveries %v, want the deliveries from now on", len(deliveries), err)
+	}
+	return service, sub, dish, deliveries
+}
+
+func TestUpdateDishFrequencyKeepsChangedDeliveries(t *testing.T) {
+	ctx := context.Background()
+	service, _, dish, deliveries := newTestSubscription(t, data.NewMemoryRepository(), "FREQ=DAILY", time.Time{}, 14)
+	repo := service.DBConnection
+
+	skipped, rescheduled, preparing := deliveries[1], deliveries[2], deliveries[3]
+	if _, err := repo.ChangeDishDeliveryStatusByID(ctx, string(DeliveryScheduled), string(DeliverySkipped), skipped.ID); err != nil {
+		t.Fatal(err)
+	}
+	rescheduled.ExpectedTime = rescheduled.ExpectedTime.Add(3 * time.Hour)
+	if _, err := repo.UpdateDishDelivery(ctx, rescheduled); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.ChangeDishDeliveryStatusByID(ctx, string(DeliveryScheduled), string(DeliveryPreparing), preparing.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	// every day of the week is the same days as daily, the changed deliveries stay in them
+	result, err := service.UpdateSubscriptionDish(ctx, dish.SubscriptionID, dish.ID, SubscriptionDishChangeRequested{Frequency: "FREQ=WEEKLY;BYDAY=MO,TU,WE,TH,FR,SA,SU"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := int64(len(deliveries) - 3); result.RemovedDeliveries != want || len(result.Deliveries) != len(deliveries)-3 {
+		t.Errorf("got %d removed and %d created, want the %d untouched deliveries replaced", result.RemovedDeliveries, len(result.Deliveries), want)
+	}
+
+	after, err := repo.GetDishDeliveryCondition(ctx, dish.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	days := map[time.Time]int{}
+	statuses := map[string]string{}
+	for _, delivery := range after {
+		days[civilDate(delivery.ExpectedTime, time.UTC)]++
+		statuses[delivery.ID] = delivery.Status
+	}
+	for date, n := range days {
+		if n != 1 {
+			t.Errorf("got %d deliveries on %v, want one", n, date)
+		}
+	}
+	for _, kept := range []data.DishDelivery{skipped, rescheduled, preparing} {
+		if statuses[kept.ID] == "" {
+			t.Errorf("delivery %s was deleted, want it kept", kept.ID)
+		}
+	}
+	if statuses[skipped.ID] != string(DeliverySkipped) || statuses[preparing.ID] != string(DeliveryPreparing) {
+		t.Errorf("got %v, want the skipped and preparing deliveries unchanged", statuses)
+	}
+}
+
+func TestUpdateDishNoteKeepsTheSchedule(t *testing.T) {
+	ctx := context.Background()
+	service, _, dish, deliveries := newTestSubscription(t, data.NewMemoryRepository(), "FREQ=DAILY", time.Time{}, 14)
+	repo := service.DBConnection
+
+	if _, err := repo.ChangeDishDeliveryStatusByID(ctx, string(DeliveryScheduled), string(DeliverySkipped), deliveries[1].ID); err != nil {
+		t.Fatal(err)
+	}
+
+	note := "no peanuts"
+	result, err := service.UpdateSubscriptionDish(ctx, dish.SubscriptionID, dish.ID, SubscriptionDishChangeRequested{Note: &note})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.RemovedDeliveries != 0 || len(result.Deliveries) != 0 {
+		t.Errorf("got %d removed and %d created, want the schedule kept", result.RemovedDeliveries, len(result.Deliveries))
+	}
+
+	after, err := repo.GetDishDeliveryCondition(ctx, dish.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(after) != len(deliveries) {
+		t.Fatalf("got %d deliveries, want %d", len(after), len(deliveries))
+	}
+	for _, delivery := range after {
+		scheduled := delivery.Status == string(DeliveryScheduled)
+		if scheduled != (delivery.Note == note) {
+			t.Errorf("%s is %s with note %q, want only the scheduled deliveries to get the new note", delivery.ID, delivery.Status, delivery.Note)
+		}
+	}
+}
@@ -7,9 +7,11 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/ClaudiaYao/CapstoneSubscriptionService/app/data"
+	"github.com/ClaudiaYao/CapstoneSubscriptionService/app/recurrence"
 	"github.com/lithammer/shortuuid"
 )
 
@@ -45,21 +47,96 @@ func (service *SubscriptionService) SendEmail(ctx context.Context, msg data.Mail
 
 }
 
+// notifySubscriber mails the owner of a subscription. The login service does not share
+// email addresses yet, so the same placeholder addresses as CreateSubscription are used.
+func (service *SubscriptionService) notifySubscriber(ctx context.Context, subject, message string) (string, error) {
+	return service.notify(ctx, subscriberAddress, subject, message)
+}
+
+// notifyReceiver mails the receiver of the deliveries, addressed by name.
+func (service *SubscriptionService) notifyReceiver(ctx context.Context, receiver data.ReceiverDetails, subject, message string) (string, error) {
+	if receiver.ReceiverName != "" {
+		message = "Dear " + receiver.ReceiverName + ",\n\n" + message
+	}
+	return service.notify(ctx, receiverAddress(receiver), subject, message)
+}
+
+func (service *SubscriptionService) notify(ctx context.Context, to, subject, message string) (string, error) {
+	mailMsg := data.MailPayload{
+		From:    "test@example.com",
+		To:      to,
+		Subject: subject,
+		Message: message,
+	}
+	return service.SendEmail(ctx, mailMsg)
+}
+
+// subscriberAddress is the placeholder address of every subscriber
+const subscriberAddress = "user@example.com"
+
+// receiverAddress is the mail address of a receiver. The receiver contact is mostly a phone
+// number, which the mail service cannot deliver to, so the mail goes to the subscriber then.
+func receiverAddress(receiver data.ReceiverDetails) string {
+	if strings.Contains(receiver.ReceiverContact, "@") {
+		return receiver.ReceiverContact
+	}
+	return subscriberAddress
+}
+
 func (service *SubscriptionService) InsertNewSubscriptionRecord(ctx context.Context, payload SubscriptionServiceRequestDataDTO) (*SubscriptionServiceResponseDataDTO, error) {
 	subReq := payload.SubscriptionRequest
 
+	if subReq.AutoRenew && subReq.EndDate.IsZero() {
+		return nil, ErrRenewalNeedsEndDate
+	}
+
+	timeZone := subReq.TimeZone
+	if timeZone == "" {
+		timeZone = service.AppConfig.DefaultTimeZone
+	}
+	loc, err := loadLocation(timeZone)
+	if err != nil {
+		return nil, err
+	}
+
+	// dishes without their own frequency follow the one of the subscription
+	frequency := subReq.Frequency
+	if frequency != "" {
+		normalized, err := recurrence.NormalizeFrequency(frequency)
+		if err != nil {
+			return nil, err
+		}
+		frequency = normalized
+	}
+
 	// this ensures that every time posting the request to create subscription, the id will be different.
 	subInfo := data.Subscription{
-		ID:              "Sub" + shortuuid.New(),
-		UserID:          subReq.UserID,
-		PlaylistID:      subReq.PlaylistID,
-		Customized:      subReq.Customized,
-		Status:          "Active",
-		Frequency:       subReq.Frequency,
-		StartDate:       subReq.StartDate,
-		EndDate:         subReq.EndDate,
-		ReceiverName:    subReq.ReceiverName,
-		ReceiverContact: subReq.ReceiverContact,
+		ID:                   "Sub" + shortuuid.New(),
+		UserID:               subReq.UserID,
+		PlaylistID:           subReq.PlaylistID,
+		Customized:           subReq.Customized,
+		Status:               string(StatusActive),
+		Frequency:            frequency,
+		StartDate:            civilDate(subReq.StartDate, loc),
+		ReceiverName:         subReq.ReceiverName,
+		ReceiverContact:      subReq.ReceiverContact,
+		AutoRenew:            subReq.AutoRenew,
+		DeliveryInstructions: subReq.DeliveryInstructions,
+		TimeZone:             loc.String(),
+		PostalCode:           strings.TrimSpace(subReq.PostalCode),
+	}
+	if !subReq.EndDate.IsZero() {
+		subInfo.EndDate = civilDate(subReq.EndDate, loc)
+		subInfo.TermDays = termDays(subInfo.StartDate, subInfo.EndDate)
+	}
+
+	// C: once operations have set up delivery slots, every dish has to be delivered in one
+	slots, err := service.DBConnection.GetActiveDeliverySlots(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("error when querying the delivery slots: %w", err)
+	}
+	if len(slots) > 0 && subInfo.PostalCode == "" {
+		return nil, ErrPostalCodeRequired
 	}
 
 	dishIncluded := payload.DishIncluded
@@ -71,26 +148,71 @@ func (service *SubscriptionService) InsertNewSubscriptionRecord(ctx context.Cont
 			return nil, err
 		}
 
+		dishFrequency := frequency
+		if dishInfo.Frequency != "" {
+			dishFrequency = dishInfo.Frequency
+		}
+		dishFrequency, err = recurrence.NormalizeFrequency(dishFrequency)
+		if err != nil {
+			return nil, err
+		}
+
 		fmt.Println(string(optionB))
 		dish := data.SubscriptionDish{
 			ID:             "SDish" + shortuuid.New(),
 			DishID:         dishInfo.DishID,
 			SubscriptionID: subInfo.ID,
 			ScheduleTime:   dishInfo.ScheduleTime,
-			Frequency:      dishInfo.Frequency,
+			Frequency:      dishFrequency,
 			DishOptions:    string(optionB),
 			Note:           dishInfo.Note,
 		}
+		if len(slots) > 0 {
+			slot, err := assignSlot(slots, dishInfo.DishID, dishInfo.SlotID, dishInfo.ScheduleTime.In(loc))
+			if err != nil {
+				return nil, err
+			}
+			dish.SlotID = slot.ID
+		}
 		dishes = append(dishes, dish)
 	}
 
+	// the deliveries are checked before anything is written, so nothing is stored when
+	// a restaurant is closed
+	until := service.deliveryHorizon(subInfo, service.now())
+	planned := map[string][]time.Time{}
+	for _, dish := range dishes {
+		planned[dish.ID], err = service.planDeliveries(ctx, subInfo, dish, until, time.Time{})
+		if err != nil {
+			return nil, fmt.Errorf("error when planning the dish deliveries: %w", err)
+		}
+	}
+	if err := service.checkPlannedOpeningHours(ctx, dishes, planned, loc); err != nil {
+		return nil, err
+	}
+
+	// C: the slots, the subscription, its dishes and deliveries are stored together or not at all
+	var response *SubscriptionServiceResponseDataDTO
+	err = service.inTransaction(ctx, func(tx *SubscriptionService) error {
+		response, err = tx.insertSubscriptionRecords(ctx, subInfo, dishes, planned)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return response, nil
+}
+
+func (service *SubscriptionService) insertSubscriptionRecords(ctx context.Context, subInfo data.Subscription, dishes []data.SubscriptionDish, planned map[string][]time.Time) (*SubscriptionServiceResponseDataDTO, error) {
 	_, err := service.DBConnection.InsertSubscription(ctx, subInfo)
 	if err != nil {
 		return nil, errors.New(fmt.Sprint("error when inserting the subscription: ", err))
 	}
+	if err := service.audit(ctx, subInfo.ID, AuditSubscription, subInfo.ID, AuditCreated, nil, subInfo); err != nil {
+		return nil, err
+	}
 
-	// dishesDelivery := []data.DishDelivery{}
-
+	deliveries := []data.DishDelivery{}
 	for _, dish := range dishes {
 		dishID, err := service.DBConnection.InsertDishes(ctx, dish)
 
@@ -98,22 +220,19 @@ func (service *SubscriptionService) InsertNewSubscriptionRecord(ctx context.Cont
 			return nil, errors.New(fmt.Sprint("error when inserting the subscription dishes:", err))
 		}
 
-		nextTime := dish.ScheduleTime
-		for !nextTime.After(subInfo.EndDate) {
-			dishDelivery := data.DishDelivery{
-				ID:                 "DD" + shortuuid.New(),
-				SubscriptionDishID: dishID,
-				Status:             "Active",
-				ExpectedTime:       nextTime,
-				Note:               dish.Note,
-			}
-			service.DBConnection.InsertDishDelivery(ctx, dishDelivery)
-			nextTime = nextDelivery(dish.Frequency, nextTime)
-			// dishesDelivery = append(dishesDelivery, dishDelivery)
+		dish.ID = dishID
+		if err := service.audit(ctx, subInfo.ID, AuditDish, dish.ID, AuditCreated, nil, dish); err != nil {
+			return nil, err
 		}
+		deliveries = append(deliveries, newDeliveries(dish, planned[dish.ID])...)
+	}
 
+	// C: the deliveries of every dish go to the database in one call
+	if err := service.insertDishDeliveries(ctx, subInfo, dishes, deliveries); err != nil {
+		return nil, fmt.Errorf("error when inserting the dish deliveries: %w", err)
 	}
 
+	dishes = localDishes(dishes, subscriptionLocation(subInfo))
 	dishesDTO := convertDishToDTO(&dishes)
 	response := &SubscriptionServiceResponseDataDTO{
 		Subscription: subInfo,
@@ -123,44 +242,173 @@ func (service *SubscriptionService) InsertNewSubscriptionRecord(ctx context.Cont
 
 }
 
-func (service *SubscriptionService) CancelSubscriptionRelatedRecords(ctx context.Context, subscriptionID string) (map[string][]data.DishDelivery, error) {
+// inTransaction runs fn on a copy of the service whose queries all run in one database
+// transaction, nothing fn writes is kept when it returns an error. Called on the copy
+// given to fn, inTransaction joins the transaction which is already open.
+func (service *SubscriptionService) inTransaction(ctx context.Context, fn func(tx *SubscriptionService) error) error {
+	return service.DBConnection.ExecTx(ctx, func(q data.SubscriptionRepository) error {
+		tx := *service
+		tx.DBConnection = q
+		return fn(&tx)
+	})
+}
+
+// ChangeSubscriptionStatus moves a subscription to a new lifecycle status.
+// Every status change goes through here, so illegal transitions are rejected and
+// the actor who triggered the change is recorded together with the time.
+func (service *SubscriptionService) ChangeSubscriptionStatus(ctx context.Context, subscriptionID string, toStatus SubscriptionStatus, actor string) (data.Subscription, error) {
+	return service.changeSubscriptionStatus(ctx, subscriptionID, toStatus, actor, SubscriptionStatus.ValidateTransition)
+}
+
+// reopenSubscription moves a cancelled subscription back into the lifecycle. It bypasses
+// the normal transitions, so callers must check they are allowed to reopen it.
+func (service *SubscriptionService) reopenSubscription(ctx context.Context, subscriptionID string, toStatus SubscriptionStatus, actor string) (data.Subscription, error) {
+	return service.changeSubscriptionStatus(ctx, subscriptionID, toStatus, actor, SubscriptionStatus.ValidateReopen)
+}
 
-	sub, err := service.DBConnection.ChangeSubscriptionStatus(ctx, "Cancelled", subscriptionID)
+func (service *SubscriptionService) changeSubscriptionStatus(ctx context.Context, subscriptionID string, toStatus SubscriptionStatus, actor string, validate func(SubscriptionStatus, SubscriptionStatus) error) (sub data.Subscription, err error) {
+	err = service.inTransaction(ctx, func(tx *SubscriptionService) error {
+		sub, err = tx.recordSubscriptionStatus(ctx, subscriptionID, toStatus, actor, validate)
+		return err
+	})
+	return sub, err
+}
 
+func (service *SubscriptionService) recordSubscriptionStatus(ctx context.Context, subscriptionID string, toStatus SubscriptionStatus, actor string, validate func(SubscriptionStatus, SubscriptionStatus) error) (data.Subscription, error) {
+	sub, err := service.DBConnection.GetSubscriptionByID(ctx, subscriptionID)
 	if err != nil {
-		return nil, errors.New(fmt.Sprint("error when changing the subscription: ", err))
+		return sub, err
+	}
+	before := sub
+
+	fromStatus := SubscriptionStatus(sub.Status)
+	if err := validate(fromStatus, toStatus); err != nil {
+		return sub, err
+	}
+
+	transition := data.StatusTransition{
+		ID:             "ST" + shortuuid.New(),
+		SubscriptionID: subscriptionID,
+		FromStatus:     string(fromStatus),
+		ToStatus:       string(toStatus),
+		TriggeredBy:    actor,
+		TriggeredAt:    service.now(),
 	}
 
-	dishes, err := service.DBConnection.GetDishBySubscriptionID(ctx, subscriptionID)
+	sub, err = service.DBConnection.ChangeSubscriptionStatus(ctx, transition)
+	if errors.Is(err, data.ErrUpdateFailed) {
+		// the status was changed between reading and updating the row
+		return sub, ErrStatusConflict
+	}
 	if err != nil {
-		return nil, errors.New(fmt.Sprint("error when querying the dishes: ", err))
+		return sub, err
 	}
+	return sub, service.audit(ctx, subscriptionID, AuditSubscription, subscriptionID, AuditStatusChanged, before, sub)
+}
 
-	dishDeliveryInfo := map[string][]data.DishDelivery{}
+// deliveryHorizon returns up to when deliveries are created. Fixed-term subscriptions are
+// materialized until their end date, open ended ones only for the rolling horizon.
+func (service *SubscriptionService) deliveryHorizon(sub data.Subscription, now time.Time) time.Time {
+	if !sub.EndDate.IsZero() {
+		return endOfDay(sub.EndDate, subscriptionLocation(sub))
+	}
+	return now.AddDate(0, 0, service.AppConfig.DeliveryHorizonDays)
+}
 
-	for _, dish := range dishes {
-		DeliveryInfo, err := service.DBConnection.ChangeDishDeliveryStatus(ctx, sub.Status, dish.ID)
-		fmt.Println(dish.DishID, DeliveryInfo)
-		if err != nil {
-			return nil, errors.New(fmt.Sprint("error when updating the dish delivery status: ", err))
-		}
-		dishDeliveryInfo[dish.ID] = DeliveryInfo
+// planDeliveries returns when the deliveries of a dish are expected between notBefore and
+// until. The rule is applied in the subscription's time zone, so deliveries keep their local
+// time of day across DST changes, and deliveries on blackout dates are skipped or moved.
+func (service *SubscriptionService) planDeliveries(ctx context.Context, sub data.Subscription, dish data.SubscriptionDish, until, notBefore time.Time) ([]time.Time, error) {
+	rule, err := recurrence.Parse(dish.Frequency)
+	if err != nil {
+		return nil, err
 	}
 
-	return dishDeliveryInfo, err
+	loc := subscriptionLocation(sub)
+	dtstart := dish.ScheduleTime.In(loc)
+	from := notBefore
+	if from.Before(dtstart) {
+		from = dtstart
+	}
+	calendar, err := service.loadBlackoutCalendar(ctx, sub, dish, from, until)
+	if err != nil {
+		return nil, err
+	}
 
+	// deliveries moved by a blackout still have to fall within the subscription
+	lower := notBefore
+	if start := startOfDay(sub.StartDate, loc); start.After(lower) {
+		lower = start
+	}
+	return calendar.plan(rule.Between(dtstart, notBefore, until), loc, lower, until), nil
 }
 
-func nextDelivery(frequency string, thisDelivery time.Time) time.Time {
-	if frequency == "daily" {
-		return thisDelivery.AddDate(0, 0, 1)
-	} else if frequency == "weekly" {
-		return thisDelivery.AddDate(0, 0, 7)
+func (service *SubscriptionService) insertPlannedDeliveries(ctx context.Context, sub data.Subscription, dish data.SubscriptionDish, planned []time.Time) ([]data.DishDelivery, error) {
+	deliveries := newDeliveries(dish, planned)
+	if err := service.insertDishDeliveries(ctx, sub, []data.SubscriptionDish{dish}, deliveries); err != nil {
+		return nil, err
+	}
+	return deliveries, nil
+}
+
+// newDeliveries are the scheduled deliveries of the dish at the planned times.
+func newDeliveries(dish data.SubscriptionDish, planned []time.Time) []data.DishDelivery {
+	deliveries := make([]data.DishDelivery, 0, len(planned))
+	for _, nextTime := range planned {
+		deliveries = append(deliveries, data.DishDelivery{
+			ID:                 "DD" + shortuuid.New(),
+			SubscriptionDishID: dish.ID,
+			Status:             string(DeliveryScheduled),
+			ExpectedTime:       nextTime,
+			Note:               dish.Note,
+		})
+	}
+	return deliveries
+}
+
+// materializeHorizon tops up the deliveries of a subscription until its delivery horizon,
+// e.g. the rolling horizon of an open ended subscription or the end of a renewed term.
+// New deliveries stay on the dish's original schedule and start after the latest existing
+// one. Past deliveries and deliveries before the start date are never created.
+func (service *SubscriptionService) materializeHorizon(ctx context.Context, sub data.Subscription, now time.Time) ([]data.DishDelivery, error) {
+	loc := subscriptionLocation(sub)
+	until := service.deliveryHorizon(sub, now)
+	floor := now
+	if start := startOfDay(sub.StartDate, loc); start.After(floor) {
+		floor = start
+	}
+
+	dishes, err := service.DBConnection.GetDishBySubscriptionID(ctx, sub.ID)
+	if err != nil {
+		return nil, fmt.Errorf("error when querying the dishes: %w", err)
+	}
 
-	} else if frequency == "monthly" {
-		return thisDelivery.AddDate(0, 1, 0)
+	created := []data.DishDelivery{}
+	for _, dish := range dishes {
+		latest, err := service.DBConnection.GetLatestDeliveryTime(ctx, dish.ID)
+		if err != nil {
+			return nil, fmt.Errorf("error when querying the latest delivery: %w", err)
+		}
+
+		notBefore := floor
+		if latest.After(floor) {
+			notBefore = latest.Add(time.Nanosecond)
+		}
+		planned, err := service.planDeliveries(ctx, sub, dish, until, notBefore)
+		if err != nil {
+			return nil, err
+		}
+		planned, err = service.openPlannedTimes(ctx, dish, planned, loc)
+		if err != nil {
+			return nil, err
+		}
+		deliveries, err := service.insertPlannedDeliveries(ctx, sub, dish, planned)
+		if err != nil {
+			return nil, fmt.Errorf("error when inserting the dish deliveries: %w", err)
+		}
+		created = append(created, deliveries...)
 	}
-	return thisDelivery.AddDate(0, 0, 1)
+	return created, nil
 }
 
 func convertDishToDTO(dishes *[]data.SubscriptionDish) *[]data.SubscriptionDishDTO {
@@ -177,6 +425,7 @@ func convertDishToDTO(dishes *[]data.SubscriptionDish) *[]data.SubscriptionDishD
 			Frequency:      dish.Frequency,
 			DishOptions:    options,
 			Note:           dish.Note,
+			SlotID:         dish.SlotID,
 		}
 		dishesDTO = append(dishesDTO, dishDTO)
 	}
@@ -1,6 +1,7 @@
 package domain
 
 import (
+	"bytes"
 	"context"
 	"errors"
 	"fmt"
@@ -14,10 +15,23 @@ import (
 )
 
 type SubscriptionService struct {
-	DBConnection *data.DataQuery
-	JwtMaker     *auth.JWTMaker
-	JwtVerifier  *auth.JWTVerifier
-	AppConfig    *AppConfiguration
+	DBConnection    data.SubscriptionRepository
+	JwtMaker        *auth.JWTMaker
+	JwtVerifier     *auth.JWTVerifier
+	AppConfig       *AppConfiguration
+	Restaurants     RestaurantLookup
+	RestaurantHours RestaurantHoursProvider
+	Dishes          DishCatalogue
+	// Clock returns the current time, it is time.Now when nil
+	Clock func() time.Time
+}
+
+// now is the current time by the service's clock
+func (service *SubscriptionService) now() time.Time {
+	if service.Clock == nil {
+		return time.Now()
+	}
+	return service.Clock()
 }
 
 type AppConfiguration struct {
@@ -27,6 +41,21 @@ type AppConfiguration struct {
 	PlaylistServiceContainerName     string
 	SubscriptionServiceContainerName string
 	LoginServiceContainerName        string
+	ExpiryJobInterval                time.Duration
+	HorizonJobInterval               time.Duration
+	RenewalJobInterval               time.Duration
+	PauseJobInterval                 time.Duration
+	DeliveryHorizonDays              int
+	CancellationCutoff               time.Duration
+	CancellationUndoWindow           time.Duration
+	DefaultTimeZone                  string
+	DishDataFile                     string
+	BlackoutCalendarFile             string
+	PostalZoneDigits                 int
+	RestaurantSource                 string
+	RestaurantDataFile               string
+	DataBackend                      string
+	SQLitePath                       string
 }
 
 // this SubscriptionServiceDataDTO represents the data returned to the client
@@ -49,6 +78,13 @@ type SubscriptionRequested struct {
 	EndDate         time.Time `json:"endDate,omitempty"`
 	ReceiverName    string    `json:"receiverName"`
 	ReceiverContact string    `json:"receiverContact"`
+	AutoRenew       bool      `json:"autoRenew,omitempty"`
+	// free text for the courier, e.g. a gate code or "leave at door"
+	DeliveryInstructions string `json:"deliveryInstructions,omitempty"`
+	// IANA time zone of the receiver, e.g. "Asia/Singapore"
+	TimeZone string `json:"timeZone,omitempty"`
+	// postal code of the receiver, its zone decides which slot capacity is used
+	PostalCode string `json:"postalCode,omitempty"`
 }
 
 type SubscriptionDishRequested struct {
@@ -57,6 +93,36 @@ type SubscriptionDishRequested struct {
 	Frequency    string     `json:"frequency"`
 	DishOptions  [][]string `json:"dishOptions"`
 	Note         string     `json:"Note,omitempty"`
+	// optional, without it the slot is found from the time of day of ScheduleTime
+	SlotID string `json:"slotID,omitempty"`
+}
+
+type PauseRequested struct {
+	PauseFrom  time.Time `json:"pauseFrom,omitempty"`
+	PauseUntil time.Time `json:"pauseUntil,omitempty"`
+}
+
+type ResumeRequested struct {
+	Policy ResumePolicy `json:"policy"`
+}
+
+type RescheduleRequested struct {
+	ExpectedTime time.Time `json:"expectedTime"`
+	Note         string    `json:"note,omitempty"`
+}
+
+type DeliveryProgressRequested struct {
+	Status DeliveryStatus `json:"status"`
+	Note   string         `json:"note,omitempty"`
+}
+
+type RenewalRequested struct {
+	AutoRenew bool `json:"autoRenew"`
+}
+
+type ReactivationRequested struct {
+	StartDate time.Time `json:"startDate,omitempty"`
+	EndDate   time.Time `json:"endDate,omitempty"`
 }
 
 // C: this PlaylistService is responsible for transfering information request/response
@@ -76,6 +142,11 @@ func (service *SubscriptionService) CreateSubscription(w http.ResponseWriter, r
 
 	subResServiceDTO, err := service.InsertNewSubscriptionRecord(r.Context(), requestPayload)
 
+	// C: when the slot is full or does not fit, the client is offered the slots it can pick instead
+	if details := errorDetails(err); details != nil {
+		service.writeJSON(w, errorStatus(err), jsonResponse{Error: true, Message: err.Error(), Data: details})
+		return
+	}
 	if err != nil {
 		service.errorJSON(w, err, http.StatusBadRequest)
 		return
@@ -111,17 +182,296 @@ func (service *SubscriptionService) Welcome(w http.ResponseWriter, r *http.Reque
 func (service *SubscriptionService) CancelSubscription(w http.ResponseWriter, r *http.Request) {
 	subscriptionID := chi.URLParam(r, "subscription_id")
 
-	CancelledDishes, err := service.CancelSubscriptionRelatedRecords(r.Context(), subscriptionID)
+	// the reason is optional, so an empty body is accepted as well
+	var requestPayload CancellationRequested
+	if r.ContentLength != 0 {
+		err := service.readJSON(w, r, &requestPayload)
+		if err != nil {
+			service.errorJSON(w, err, http.StatusBadRequest)
+			return
+		}
+	}
+
+	result, err := service.CancelSubscriptionRelatedRecords(r.Context(), subscriptionID, requestPayload, userIDFromContext(r.Context()))
+
+	if err != nil {
+		service.errorJSON(w, err, errorStatus(err))
+		return
+	}
+
+	responsePayload := jsonResponse{
+		Error: false,
+		Data:  result,
+	}
+
+	toAddress, err := service.notifySubscriber(r.Context(), "subscription is cancelled.", cancellationMessage(result))
+
+	if err != nil {
+		responsePayload.Message = fmt.Sprintf("subscription %s is cancelled but mail fail to sent", subscriptionID)
+	} else {
+		responsePayload.Message = fmt.Sprintf("subscription %s is cancelled and mail sent to %s", subscriptionID, toAddress)
+	}
+
+	service.writeJSON(w, http.StatusAccepted, responsePayload)
+}
+
+func (service *SubscriptionService) UndoCancellationHandler(w http.ResponseWriter, r *http.Request) {
+	subscriptionID := chi.URLParam(r, "subscription_id")
+
+	result, err := service.UndoCancellation(r.Context(), subscriptionID, userIDFromContext(r.Context()))
+	if err != nil {
+		service.errorJSON(w, err, errorStatus(err))
+		return
+	}
+
+	responsePayload := jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("cancellation of subscription %s is undone", subscriptionID),
+		Data:    result,
+	}
+
+	service.writeJSON(w, http.StatusAccepted, responsePayload)
+}
+
+func (service *SubscriptionService) PauseSubscriptionHandler(w http.ResponseWriter, r *http.Request) {
+	subscriptionID := chi.URLParam(r, "subscription_id")
+
+	var requestPayload PauseRequested
+	err := service.readJSON(w, r, &requestPayload)
+	if err != nil {
+		service.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	result, err := service.PauseSubscription(r.Context(), subscriptionID, requestPayload.PauseFrom, requestPayload.PauseUntil, userIDFromContext(r.Context()))
+	if err != nil {
+		service.errorJSON(w, err, errorStatus(err))
+		return
+	}
+
+	responsePayload := jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("subscription %s is paused successfully", subscriptionID),
+		Data:    result,
+	}
+
+	service.writeJSON(w, http.StatusAccepted, responsePayload)
+}
+
+func (service *SubscriptionService) ResumeSubscriptionHandler(w http.ResponseWriter, r *http.Request) {
+	subscriptionID := chi.URLParam(r, "subscription_id")
+
+	var requestPayload ResumeRequested
+	err := service.readJSON(w, r, &requestPayload)
+	if err != nil {
+		service.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	result, err := service.ResumeSubscription(r.Context(), subscriptionID, requestPayload.Policy, userIDFromContext(r.Context()))
+	if err != nil {
+		service.errorJSON(w, err, errorStatus(err))
+		return
+	}
+
+	responsePayload := jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("subscription %s is resumed successfully", subscriptionID),
+		Data:    result,
+	}
+
+	service.writeJSON(w, http.StatusAccepted, responsePayload)
+}
+
+func (service *SubscriptionService) SetAutoRenewHandler(w http.ResponseWriter, r *http.Request) {
+	subscriptionID := chi.URLParam(r, "subscription_id")
+
+	var requestPayload RenewalRequested
+	err := service.readJSON(w, r, &requestPayload)
+	if err != nil {
+		service.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	sub, err := service.SetAutoRenew(r.Context(), subscriptionID, requestPayload.AutoRenew)
+	if err != nil {
+		service.errorJSON(w, err, errorStatus(err))
+		return
+	}
+
+	message := fmt.Sprintf("subscription %s will not renew automatically", subscriptionID)
+	if sub.AutoRenew {
+		message = fmt.Sprintf("subscription %s will renew automatically", subscriptionID)
+	}
+	responsePayload := jsonResponse{
+		Error:   false,
+		Message: message,
+		Data:    sub,
+	}
+
+	service.writeJSON(w, http.StatusAccepted, responsePayload)
+}
+
+func (service *SubscriptionService) ReactivateSubscriptionHandler(w http.ResponseWriter, r *http.Request) {
+	subscriptionID := chi.URLParam(r, "subscription_id")
+
+	var requestPayload ReactivationRequested
+	err := service.readJSON(w, r, &requestPayload)
+	if err != nil {
+		service.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	result, err := service.ReactivateSubscription(r.Context(), subscriptionID, requestPayload.StartDate, requestPayload.EndDate, userIDFromContext(r.Context()))
+	if err != nil {
+		service.errorJSON(w, err, errorStatus(err))
+		return
+	}
+
+	responsePayload := jsonResponse{
+		Error: false,
+		Data:  result,
+	}
+
+	message := fmt.Sprintf("Your subscription %s is active again from %s with %d upcoming deliveries.",
+		subscriptionID, result.Subscription.StartDate.Format("2006-01-02"), len(result.Deliveries))
+	toAddress, err := service.notifySubscriber(r.Context(), "subscription is reactivated.", message)
+
+	if err != nil {
+		responsePayload.Message = fmt.Sprintf("subscription %s is reactivated but mail fail to sent", subscriptionID)
+	} else {
+		responsePayload.Message = fmt.Sprintf("subscription %s is reactivated and mail sent to %s", subscriptionID, toAddress)
+	}
+
+	service.writeJSON(w, http.StatusAccepted, responsePayload)
+}
+
+func (service *SubscriptionService) AddSubscriptionDishHandler(w http.ResponseWriter, r *http.Request) {
+	subscriptionID := chi.URLParam(r, "subscription_id")
+
+	var requestPayload SubscriptionDishRequested
+	err := service.readJSON(w, r, &requestPayload)
+	if err != nil {
+		service.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	result, err := service.AddSubscriptionDish(r.Context(), subscriptionID, requestPayload)
+	if err != nil {
+		service.errorJSON(w, err, errorStatus(err))
+		return
+	}
+
+	responsePayload := jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("dish is added to subscription %s", subscriptionID),
+		Data:    result,
+	}
+
+	service.writeJSON(w, http.StatusAccepted, responsePayload)
+}
 
+func (service *SubscriptionService) UpdateSubscriptionDishHandler(w http.ResponseWriter, r *http.Request) {
+	subscriptionID := chi.URLParam(r, "subscription_id")
+	subscriptionDishID := chi.URLParam(r, "subscription_dish_id")
+
+	var requestPayload SubscriptionDishChangeRequested
+	err := service.readJSON(w, r, &requestPayload)
 	if err != nil {
 		service.errorJSON(w, err, http.StatusBadRequest)
 		return
 	}
 
+	result, err := service.UpdateSubscriptionDish(r.Context(), subscriptionID, subscriptionDishID, requestPayload)
+	if err != nil {
+		service.errorJSON(w, err, errorStatus(err))
+		return
+	}
+
 	responsePayload := jsonResponse{
 		Error:   false,
-		Message: fmt.Sprintf("subscription %s is cancelled successfully", subscriptionID),
-		Data:    CancelledDishes,
+		Message: fmt.Sprintf("dish %s is updated", subscriptionDishID),
+		Data:    result,
+	}
+
+	service.writeJSON(w, http.StatusAccepted, responsePayload)
+}
+
+func (service *SubscriptionService) RemoveSubscriptionDishHandler(w http.ResponseWriter, r *http.Request) {
+	subscriptionID := chi.URLParam(r, "subscription_id")
+	subscriptionDishID := chi.URLParam(r, "subscription_dish_id")
+
+	result, err := service.RemoveSubscriptionDish(r.Context(), subscriptionID, subscriptionDishID)
+	if err != nil {
+		service.errorJSON(w, err, errorStatus(err))
+		return
+	}
+
+	responsePayload := jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("dish %s is removed from subscription %s", subscriptionDishID, subscriptionID),
+		Data:    result,
+	}
+
+	service.writeJSON(w, http.StatusAccepted, responsePayload)
+}
+
+func (service *SubscriptionService) ChangeReceiverHandler(w http.ResponseWriter, r *http.Request) {
+	subscriptionID := chi.URLParam(r, "subscription_id")
+
+	var requestPayload ReceiverChangeRequested
+	err := service.readJSON(w, r, &requestPayload)
+	if err != nil {
+		service.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	sub, err := service.ChangeReceiver(r.Context(), subscriptionID, requestPayload, userIDFromContext(r.Context()))
+	if err != nil {
+		service.errorJSON(w, err, errorStatus(err))
+		return
+	}
+
+	responsePayload := jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("receiver of subscription %s is updated", subscriptionID),
+		Data:    sub,
+	}
+
+	service.writeJSON(w, http.StatusAccepted, responsePayload)
+}
+
+func (service *SubscriptionService) GetReceiverHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	subscriptionID := chi.URLParam(r, "subscription_id")
+
+	history, err := service.DBConnection.GetReceiverHistory(r.Context(), subscriptionID)
+	if err != nil {
+		service.errorJSON(w, errors.New("invalid query"), http.StatusBadRequest)
+		return
+	}
+
+	responsePayload := jsonResponse{
+		Error:   false,
+		Message: "receiver history is retrieved",
+		Data:    history,
+	}
+
+	service.writeJSON(w, http.StatusAccepted, responsePayload)
+}
+
+func (service *SubscriptionService) GetDeliveryReceiverHandler(w http.ResponseWriter, r *http.Request) {
+	deliveryID := chi.URLParam(r, "delivery_id")
+
+	receiver, err := service.ReceiverForDelivery(r.Context(), deliveryID)
+	if err != nil {
+		service.errorJSON(w, err, errorStatus(err))
+		return
+	}
+
+	responsePayload := jsonResponse{
+		Error:   false,
+		Message: "receiver is retrieved",
+		Data:    receiver,
 	}
 
 	service.writeJSON(w, http.StatusAccepted, responsePayload)
@@ -130,12 +480,19 @@ func (service *SubscriptionService) CancelSubscription(w http.ResponseWriter, r
 func (service *SubscriptionService) GetDishBySubscriptionID(w http.ResponseWriter, r *http.Request) {
 
 	subscriptionId := chi.URLParam(r, "subscription_id")
+	subscription, err := service.DBConnection.GetSubscriptionByID(r.Context(), subscriptionId)
+	if err != nil {
+		service.errorJSON(w, errors.New(fmt.Sprint("invalid query", err)), errorStatus(err))
+		return
+	}
+
 	subscriptionDishes, err := service.DBConnection.GetDishBySubscriptionID(r.Context(), subscriptionId)
 	if err != nil {
 		service.errorJSON(w, errors.New(fmt.Sprint("invalid query", err)), http.StatusBadRequest)
 		return
 	}
 
+	subscriptionDishes = localDishes(subscriptionDishes, subscriptionLocation(subscription))
 	dishesDTO := convertDishToDTO(&subscriptionDishes)
 
 	responsePayload := jsonResponse{
@@ -151,16 +508,22 @@ func (service *SubscriptionService) GetDishBySubscriptionID(w http.ResponseWrite
 func (service *SubscriptionService) GetDishDeliveryStatus(w http.ResponseWriter, r *http.Request) {
 
 	dishID := chi.URLParam(r, "dish_id")
-	dishDeliveryStatus, err := service.DBConnection.GetDishBySubscriptionID(r.Context(), dishID)
+	dishDeliveryStatus, err := service.DBConnection.GetDishDeliveryCondition(r.Context(), dishID)
 	if err != nil {
 		service.errorJSON(w, errors.New("invalid query"), http.StatusBadRequest)
 		return
 	}
 
+	loc, err := service.dishLocation(r.Context(), dishID)
+	if err != nil {
+		service.errorJSON(w, errors.New("invalid query"), errorStatus(err))
+		return
+	}
+
 	responsePayload := jsonResponse{
 		Error:   false,
 		Message: "dishes are retrieved",
-		Data:    dishDeliveryStatus,
+		Data:    localDeliveries(dishDeliveryStatus, loc),
 	}
 
 	// C: this means the success response
@@ -168,6 +531,78 @@ func (service *SubscriptionService) GetDishDeliveryStatus(w http.ResponseWriter,
 
 }
 
+func (service *SubscriptionService) SkipDishDeliveryHandler(w http.ResponseWriter, r *http.Request) {
+	deliveryID := chi.URLParam(r, "delivery_id")
+
+	delivery, err := service.SkipDishDelivery(r.Context(), deliveryID)
+	if err != nil {
+		service.errorJSON(w, err, errorStatus(err))
+		return
+	}
+
+	responsePayload := jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("delivery %s is skipped", deliveryID),
+		Data:    delivery,
+	}
+
+	service.writeJSON(w, http.StatusAccepted, responsePayload)
+}
+
+func (service *SubscriptionService) RescheduleDishDeliveryHandler(w http.ResponseWriter, r *http.Request) {
+	deliveryID := chi.URLParam(r, "delivery_id")
+
+	var requestPayload RescheduleRequested
+	err := service.readJSON(w, r, &requestPayload)
+	if err != nil {
+		service.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	delivery, err := service.RescheduleDishDelivery(r.Context(), deliveryID, requestPayload.ExpectedTime, requestPayload.Note)
+	if details := errorDetails(err); details != nil {
+		service.writeJSON(w, errorStatus(err), jsonResponse{Error: true, Message: err.Error(), Data: details})
+		return
+	}
+	if err != nil {
+		service.errorJSON(w, err, errorStatus(err))
+		return
+	}
+
+	responsePayload := jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("delivery %s is rescheduled", deliveryID),
+		Data:    delivery,
+	}
+
+	service.writeJSON(w, http.StatusAccepted, responsePayload)
+}
+
+func (service *SubscriptionService) ProgressDishDeliveryHandler(w http.ResponseWriter, r *http.Request) {
+	deliveryID := chi.URLParam(r, "delivery_id")
+
+	var requestPayload DeliveryProgressRequested
+	err := service.readJSON(w, r, &requestPayload)
+	if err != nil {
+		service.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	delivery, err := service.ProgressDishDelivery(r.Context(), deliveryID, requestPayload.Status, requestPayload.Note)
+	if err != nil {
+		service.errorJSON(w, err, errorStatus(err))
+		return
+	}
+
+	responsePayload := jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("delivery %s is %s", deliveryID, delivery.Status),
+		Data:    delivery,
+	}
+
+	service.writeJSON(w, http.StatusAccepted, responsePayload)
+}
+
 func (service *SubscriptionService) GetSubscriptionByID(w http.ResponseWriter, r *http.Request) {
 
 	// planType := entities.SourceB2C
@@ -187,6 +622,7 @@ func (service *SubscriptionService) GetSubscriptionByID(w http.ResponseWriter, r
 		return
 	}
 
+	dishes = localDishes(dishes, subscriptionLocation(subscription))
 	dishesDTO := convertDishToDTO(&dishes)
 	subResServiceDTO := SubscriptionServiceResponseDataDTO{
 		Subscription: subscription,
@@ -207,34 +643,277 @@ func (service *SubscriptionService) GetSubscriptionByUserID(w http.ResponseWrite
 
 	userID := chi.URLParam(r, "user_id")
 
-	subscriptions, err := service.DBConnection.GetSubscriptionByUserID(r.Context(), userID)
+	query, err := ParseSubscriptionListQuery(userID, r.URL.Query())
+	if err != nil {
+		service.errorJSON(w, err, errorStatus(err))
+		return
+	}
+
+	page, err := service.ListSubscriptions(r.Context(), query)
 	if err != nil {
 		service.errorJSON(w, errors.New("invalid query"), http.StatusBadRequest)
 		return
 	}
 
-	subResponseDTOs := []SubscriptionServiceResponseDataDTO{}
+	subResponseDTOs, err := service.subscriptionsWithDishes(r.Context(), page.Subscriptions)
+	if err != nil {
+		service.errorJSON(w, errors.New("invalid query for the dish subscription table"), http.StatusBadRequest)
+		return
+	}
 
-	for _, sub := range subscriptions {
-		dishes, err := service.DBConnection.GetDishBySubscriptionID(r.Context(), sub.ID)
+	responsePayload := jsonResponse{
+		Error:      false,
+		Message:    "subscriptions are retrieved",
+		Data:       subResponseDTOs,
+		NextCursor: page.NextCursor,
+		PrevCursor: page.PrevCursor,
+	}
 
-		if err != nil {
-			service.errorJSON(w, errors.New("invalid query for the dish subscription table"), http.StatusBadRequest)
-			return
-		}
+	service.writeJSON(w, http.StatusAccepted, responsePayload)
+}
 
-		dishesDTO := convertDishToDTO(&dishes)
-		subResServiceDTO := SubscriptionServiceResponseDataDTO{
-			Subscription: sub,
-			DishIncluded: *dishesDTO,
-		}
-		subResponseDTOs = append(subResponseDTOs, subResServiceDTO)
+func (service *SubscriptionService) CreateBlackoutHandler(w http.ResponseWriter, r *http.Request) {
+	var requestPayload BlackoutRequested
+	err := service.readJSON(w, r, &requestPayload)
+	if err != nil {
+		service.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	blackout, err := service.CreateBlackout(r.Context(), requestPayload, userIDFromContext(r.Context()), roleFromContext(r.Context()))
+	if err != nil {
+		service.errorJSON(w, err, errorStatus(err))
+		return
 	}
 
 	responsePayload := jsonResponse{
 		Error:   false,
-		Message: "subscriptions are retrieved",
-		Data:    subResponseDTOs,
+		Message: fmt.Sprintf("blackout %s is created", blackout.ID),
+		Data:    blackout,
+	}
+
+	service.writeJSON(w, http.StatusAccepted, responsePayload)
+}
+
+// ImportBlackoutsHandler reads an iCalendar file from the request body. The scope, scopeID
+// and default action are given as query parameters.
+func (service *SubscriptionService) ImportBlackoutsHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	calendar := http.MaxBytesReader(w, r.Body, 1048576)
+
+	blackouts, err := service.ImportBlackouts(r.Context(), calendar, BlackoutScope(query.Get("scope")), query.Get("scopeID"),
+		BlackoutAction(query.Get("action")), userIDFromContext(r.Context()), roleFromContext(r.Context()))
+	if err != nil {
+		service.errorJSON(w, err, errorStatus(err))
+		return
+	}
+
+	responsePayload := jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("%d blackouts are imported", len(blackouts)),
+		Data:    blackouts,
+	}
+
+	service.writeJSON(w, http.StatusAccepted, responsePayload)
+}
+
+func (service *SubscriptionService) GetBlackoutsHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	blackouts, err := service.GetBlackouts(r.Context(), BlackoutScope(query.Get("scope")), query.Get("scopeID"))
+	if err != nil {
+		service.errorJSON(w, err, errorStatus(err))
+		return
+	}
+
+	responsePayload := jsonResponse{
+		Error:   false,
+		Message: "blackouts are retrieved",
+		Data:    blackouts,
+	}
+
+	service.writeJSON(w, http.StatusAccepted, responsePayload)
+}
+
+func (service *SubscriptionService) DeleteBlackoutHandler(w http.ResponseWriter, r *http.Request) {
+	blackoutID := chi.URLParam(r, "blackout_id")
+
+	err := service.DeleteBlackout(r.Context(), blackoutID, userIDFromContext(r.Context()), roleFromContext(r.Context()))
+	if err != nil {
+		service.errorJSON(w, err, errorStatus(err))
+		return
+	}
+
+	responsePayload := jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("blackout %s is deleted", blackoutID),
+	}
+
+	service.writeJSON(w, http.StatusAccepted, responsePayload)
+}
+
+func (service *SubscriptionService) GetDeliverySlotsHandler(w http.ResponseWriter, r *http.Request) {
+	slots, err := service.DBConnection.GetActiveDeliverySlots(r.Context())
+	if err != nil {
+		service.errorJSON(w, errors.New("invalid query"), http.StatusBadRequest)
+		return
+	}
+
+	responsePayload := jsonResponse{
+		Error:   false,
+		Message: "delivery slots are retrieved",
+		Data:    slots,
+	}
+
+	service.writeJSON(w, http.StatusAccepted, responsePayload)
+}
+
+func (service *SubscriptionService) CreateDeliverySlotHandler(w http.ResponseWriter, r *http.Request) {
+	var requestPayload DeliverySlotRequested
+	err := service.readJSON(w, r, &requestPayload)
+	if err != nil {
+		service.errorJSON(w, err, http.StatusBadRequest)
+		return
+	}
+
+	slot, err := service.CreateDeliverySlot(r.Context(), requestPayload)
+	if err != nil {
+		service.errorJSON(w, err, errorStatus(err))
+		return
+	}
+
+	responsePayload := jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("delivery slot %s is created", slot.ID),
+		Data:    slot,
+	}
+
+	service.writeJSON(w, http.StatusAccepted, responsePayload)
+}
+
+func (service *SubscriptionService) CreateCalendarTokenHandler(w http.ResponseWriter, r *http.Request) {
+	token, err := service.NewCalendarToken(r.Context(), userIDFromContext(r.Context()))
+	if err != nil {
+		service.errorJSON(w, err, errorStatus(err))
+		return
+	}
+
+	responsePayload := jsonResponse{
+		Error:   false,
+		Message: "calendar token is created, the previous one no longer works",
+		Data:    token,
+	}
+
+	service.writeJSON(w, http.StatusAccepted, responsePayload)
+}
+
+// DeliveryCalendarHandler serves the iCalendar feed of a user's deliveries. Calendar clients
+// cannot log in, so the feed is protected by the token in its URL instead of a JWT.
+func (service *SubscriptionService) DeliveryCalendarHandler(w http.ResponseWriter, r *http.Request) {
+	userID := chi.URLParam(r, "user_id")
+
+	var calendar bytes.Buffer
+	err := service.WriteDeliveryCalendar(r.Context(), &calendar, userID, r.URL.Query().Get("token"), service.now())
+	if err != nil {
+		service.errorJSON(w, err, errorStatus(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+	w.Header().Set("Content-Disposition", `inline; filename="deliveries.ics"`)
+	w.WriteHeader(http.StatusOK)
+	w.Write(calendar.Bytes())
+}
+
+// GetUserDeliveriesHandler returns the deliveries of a user grouped by day. The days are
+// given as the from and to query parameters, e.g. ?from=2023-03-01&to=2023-03-31.
+func (service *SubscriptionService) GetUserDeliveriesHandler(w http.ResponseWriter, r *http.Request) {
+	userID := chi.URLParam(r, "user_id")
+
+	deliveryRange, err := ParseDeliveryRange(r.URL.Query().Get("from"), r.URL.Query().Get("to"), service.now())
+	if err != nil {
+		service.errorJSON(w, err, errorStatus(err))
+		return
+	}
+
+	days, err := service.UserDeliveries(r.Context(), userID, deliveryRange)
+	if err != nil {
+		service.errorJSON(w, err, errorStatus(err))
+		return
+	}
+
+	responsePayload := jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("deliveries of user %s are retrieved", userID),
+		Data:    days,
+	}
+
+	service.writeJSON(w, http.StatusAccepted, responsePayload)
+}
+
+func (service *SubscriptionService) GetSubscriptionDeliveriesHandler(w http.ResponseWriter, r *http.Request) {
+	subscriptionID := chi.URLParam(r, "subscription_id")
+
+	deliveryRange, err := ParseDeliveryRange(r.URL.Query().Get("from"), r.URL.Query().Get("to"), service.now())
+	if err != nil {
+		service.errorJSON(w, err, errorStatus(err))
+		return
+	}
+
+	days, err := service.SubscriptionDeliveries(r.Context(), subscriptionID, deliveryRange)
+	if err != nil {
+		service.errorJSON(w, err, errorStatus(err))
+		return
+	}
+
+	responsePayload := jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("deliveries of subscription %s are retrieved", subscriptionID),
+		Data:    days,
+	}
+
+	service.writeJSON(w, http.StatusAccepted, responsePayload)
+}
+
+func (service *SubscriptionService) GetRestaurantDeliveriesHandler(w http.ResponseWriter, r *http.Request) {
+	restaurantID := chi.URLParam(r, "restaurant_id")
+
+	deliveryRange, err := ParseDeliveryRange(r.URL.Query().Get("from"), r.URL.Query().Get("to"), service.now())
+	if err != nil {
+		service.errorJSON(w, err, errorStatus(err))
+		return
+	}
+
+	days, err := service.RestaurantDeliveries(r.Context(), restaurantID, deliveryRange)
+	if err != nil {
+		service.errorJSON(w, err, errorStatus(err))
+		return
+	}
+
+	responsePayload := jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("deliveries of restaurant %s are retrieved", restaurantID),
+		Data:    days,
+	}
+
+	service.writeJSON(w, http.StatusAccepted, responsePayload)
+}
+
+// GetSubscriptionHistoryHandler returns the audit log of the subscription, for support staff
+// to follow every change made to it, its dishes and deliveries.
+func (service *SubscriptionService) GetSubscriptionHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	subscriptionID := chi.URLParam(r, "id")
+
+	history, err := service.SubscriptionHistory(r.Context(), subscriptionID)
+	if err != nil {
+		service.errorJSON(w, err, errorStatus(err))
+		return
+	}
+
+	responsePayload := jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("history of subscription %s is retrieved", subscriptionID),
+		Data:    history,
 	}
 
 	service.writeJSON(w, http.StatusAccepted, responsePayload)
@@ -274,10 +953,74 @@ func (service *SubscriptionService) AuthenticateUser(next http.Handler) http.Han
 
 		// add userID to the header of the request
 		ctx := context.WithValue(r.Context(), "userID", payload.UserID)
+		ctx = context.WithValue(ctx, "role", payload.Role)
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
 
+// RequireRole only lets requests through whose token was issued for the given role.
+// It must run after AuthenticateUser.
+func (service *SubscriptionService) RequireRole(role string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			tokenRole, _ := r.Context().Value("role").(string)
+			if tokenRole != role {
+				err := fmt.Errorf("this operation requires the %s role", role)
+				service.errorJSON(w, err, http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// userIDFromContext returns the userID put into the request context by AuthenticateUser
+func userIDFromContext(ctx context.Context) string {
+	userID, _ := ctx.Value("userID").(string)
+	return userID
+}
+
+// roleFromContext returns the role put into the request context by AuthenticateUser
+func roleFromContext(ctx context.Context) string {
+	role, _ := ctx.Value("role").(string)
+	return role
+}
+
+// errorDetails returns what the client needs to fix a rejected request, such as the
+// deliveries outside the opening hours or the slots it can pick instead of a full one.
+func errorDetails(err error) any {
+	var unavailable *SlotUnavailableError
+	if errors.As(err, &unavailable) {
+		return unavailable
+	}
+	var outside *OpeningHoursError
+	if errors.As(err, &outside) {
+		return outside
+	}
+	return nil
+}
+
+// errorStatus maps the errors returned by the domain and data layer to a http status code
+func errorStatus(err error) int {
+	switch {
+	case errors.Is(err, ErrInvalidTransition), errors.Is(err, ErrStatusConflict),
+		errors.Is(err, ErrDeliveryNotScheduled), errors.Is(err, ErrInvalidDeliveryProgress),
+		errors.Is(err, ErrSubscriptionClosed), errors.Is(err, ErrSubscriptionNotActive),
+		errors.Is(err, ErrUndoWindowClosed), errors.Is(err, ErrSubscriptionNotClosed),
+		errors.Is(err, ErrDeliveryOnBlackout), errors.Is(err, data.ErrSlotFull),
+		errors.Is(err, ErrOutsideOpeningHours), errors.Is(err, ErrPauseScheduled):
+		return http.StatusConflict
+	case errors.Is(err, ErrBlackoutForbidden):
+		return http.StatusForbidden
+	case errors.Is(err, ErrInvalidCalendarToken):
+		return http.StatusUnauthorized
+	case errors.Is(err, data.ErrNotExist):
+		return http.StatusNotFound
+	default:
+		return http.StatusBadRequest
+	}
+}
+
 // 	// validate the user against the database
 // 	user, err := app.Models.User.GetByEmail(requestPayload.Email)
 // 	if err != nil {
@@ -0,0 +1,202 @@
+package domain
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+var ErrInvalidCalendar = errors.New("invalid iCalendar data")
+
+// icalEvent is one VEVENT of an iCalendar (RFC 5545) file, with the days it covers.
+// Start and End are civil dates and End is included.
+type icalEvent struct {
+	UID        string
+	Summary    string
+	Start      time.Time
+	End        time.Time
+	Properties map[string]string
+}
+
+// parseICalEvents reads the events of an iCalendar file. Only the date part of DTSTART and
+// DTEND is used, events without DTSTART are rejected.
+func parseICalEvents(r io.Reader) ([]icalEvent, error) {
+	lines, err := unfoldICalLines(r)
+	if err != nil {
+		return nil, err
+	}
+
+	events := []icalEvent{}
+	var event *icalEvent
+	var endValue string
+	for n, line := range lines {
+		name, value, ok := splitICalLine(line)
+		if !ok {
+			return nil, fmt.Errorf("%w: line %d is not a property", ErrInvalidCalendar, n+1)
+		}
+
+		switch {
+		case name == "BEGIN" && value == "VEVENT":
+			event = &icalEvent{Properties: map[string]string{}}
+			endValue = ""
+		case name == "END" && value == "VEVENT":
+			if event == nil || event.Start.IsZero() {
+				return nil, fmt.Errorf("%w: event without DTSTART", ErrInvalidCalendar)
+			}
+			event.End = event.Start
+			if endValue != "" {
+				end, err := icalEndDate(endValue)
+				if err != nil {
+					return nil, err
+				}
+				if end.After(event.Start) {
+					event.End = end
+				}
+			}
+			events = append(events, *event)
+			event = nil
+		case event == nil:
+			// properties of the calendar itself or of other components
+		case name == "DTSTART":
+			start, err := icalDate(value)
+			if err != nil {
+				return nil, err
+			}
+			event.Start = start
+		case name == "DTEND":
+			endValue = value
+		case name == "UID":
+			event.UID = value
+		case name == "SUMMARY":
+			event.Summary = unescapeICalText(value)
+		default:
+			event.Properties[name] = value
+		}
+	}
+	return events, nil
+}
+
+// unfoldICalLines joins the folded lines of an iCalendar file, continuation lines start
+// with a space or a tab.
+func unfoldICalLines(r io.Reader) ([]string, error) {
+	lines := []string{}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if line == "" {
+			continue
+		}
+		if (line[0] == ' ' || line[0] == '\t') && len(lines) > 0 {
+			lines[len(lines)-1] += line[1:]
+			continue
+		}
+		lines = append(lines, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidCalendar, err)
+	}
+	return lines, nil
+}
+
+// splitICalLine splits NAME;PARAM=VALUE:value into its name and value, the parameters
+// are not needed since only dates are read.
+func splitICalLine(line string) (string, string, bool) {
+	head, value, ok := strings.Cut(line, ":")
+	if !ok {
+		return "", "", false
+	}
+	name, _, _ := strings.Cut(head, ";")
+	return strings.ToUpper(name), value, true
+}
+
+// icalDate returns the calendar day of a DATE or DATE-TIME value as it is written,
+// e.g. 20230101 or 20230101T090000Z, as midnight UTC.
+func icalDate(value string) (time.Time, error) {
+	if len(value) < 8 {
+		return time.Time{}, fmt.Errorf("%w: %q is not a date", ErrInvalidCalendar, value)
+	}
+	date, err := time.Parse(icalDateLayout, value[:8])
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%w: %q is not a date", ErrInvalidCalendar, value)
+	}
+	return date, nil
+}
+
+// icalEndDate returns the last day covered by DTEND. DTEND is exclusive, so an end on
+// midnight or a DATE value ends the event on the day before.
+func icalEndDate(value string) (time.Time, error) {
+	date, err := icalDate(value)
+	if err != nil {
+		return date, err
+	}
+	clock := strings.TrimSuffix(value[8:], "Z")
+	if clock == "" || clock == "T000000" {
+		return date.AddDate(0, 0, -1), nil
+	}
+	return date, nil
+}
+
+var icalTextUnescaper = strings.NewReplacer(`\n`, "\n", `\N`, "\n", `\,`, ",", `\;`, ";", `\\`, `\`)
+
+func unescapeICalText(value string) string {
+	return icalTextUnescaper.Replace(value)
+}
+
+// icalDateTimeUTC is how times are written in the calendars this service produces
+const icalDateTimeUTC = "20060102T150405Z"
+
+// icalDateLayout is how a DATE value is written
+const icalDateLayout = "20060102"
+
+// icalMaxLineOctets is the longest line RFC 5545 allows before it has to be folded
+const icalMaxLineOctets = 75
+
+// icalWriter writes the lines of an iCalendar file, folded and ended with CRLF. The first
+// write error is kept and returned by Err.
+type icalWriter struct {
+	w   io.Writer
+	err error
+}
+
+// property writes NAME:value, the value has to be escaped already where needed.
+func (w *icalWriter) property(name, value string) {
+	if w.err != nil {
+		return
+	}
+	line := name + ":" + value
+	var b strings.Builder
+	width := 0
+	for _, r := range line {
+		size := len(string(r))
+		// continuation lines start with a space, which counts towards their length
+		if width+size > icalMaxLineOctets {
+			b.WriteString("\r\n ")
+			width = 1
+		}
+		b.WriteRune(r)
+		width += size
+	}
+	b.WriteString("\r\n")
+	_, w.err = io.WriteString(w.w, b.String())
+}
+
+func (w *icalWriter) text(name, value string) {
+	w.property(name, escapeICalText(value))
+}
+
+func (w *icalWriter) time(name string, t time.Time) {
+	w.property(name, t.UTC().Format(icalDateTimeUTC))
+}
+
+func (w *icalWriter) Err() error {
+	return w.err
+}
+
+var icalTextEscaper = strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\r\n", `\n`, "\n", `\n`)
+
+func escapeICalText(value string) string {
+	return icalTextEscaper.Replace(value)
+}
@@ -0,0 +1,155 @@
+package domain
+
+import (
+	"errors"
+	"fmt"
+)
+
+// SubscriptionStatus is the lifecycle state of a subscription.
+// A subscription starts as Pending or Active, can be paused and resumed,
+// and finally ends up Cancelled or Expired.
+type SubscriptionStatus string
+
+const (
+	StatusPending   SubscriptionStatus = "Pending"
+	StatusActive    SubscriptionStatus = "Active"
+	StatusPaused    SubscriptionStatus = "Paused"
+	StatusCancelled SubscriptionStatus = "Cancelled"
+	StatusExpired   SubscriptionStatus = "Expired"
+)
+
+// subscriptionTransitions lists, for every status, the statuses it is allowed to move to.
+var subscriptionTransitions = map[SubscriptionStatus][]SubscriptionStatus{
+	StatusPending:   {StatusActive, StatusCancelled},
+	StatusActive:    {StatusPaused, StatusCancelled, StatusExpired},
+	StatusPaused:    {StatusActive, StatusCancelled, StatusExpired},
+	StatusCancelled: {},
+	StatusExpired:   {},
+}
+
+// reopenTransitions are the only ways out of a final status. They are not part of the
+// normal lifecycle and are only used when a cancellation is undone within its undo window
+// or when a closed subscription is reactivated.
+var reopenTransitions = map[SubscriptionStatus][]SubscriptionStatus{
+	StatusCancelled: {StatusPending, StatusActive, StatusPaused},
+	StatusExpired:   {StatusActive},
+}
+
+var (
+	ErrInvalidTransition = errors.New("invalid subscription status transition")
+	ErrStatusConflict    = errors.New("subscription status was changed by another request")
+)
+
+// TransitionError is returned when a status change is not allowed by the lifecycle.
+type TransitionError struct {
+	From SubscriptionStatus
+	To   SubscriptionStatus
+}
+
+func (e *TransitionError) Error() string {
+	return fmt.Sprintf("subscription cannot change from %s to %s", e.From, e.To)
+}
+
+func (e *TransitionError) Is(target error) bool {
+	return target == ErrInvalidTransition
+}
+
+// IsValid reports whether the status is part of the lifecycle.
+func (s SubscriptionStatus) IsValid() bool {
+	_, ok := subscriptionTransitions[s]
+	return ok
+}
+
+// CanTransitionTo reports whether a subscription in status s may move to status to.
+func (s SubscriptionStatus) CanTransitionTo(to SubscriptionStatus) bool {
+	for _, next := range subscriptionTransitions[s] {
+		if next == to {
+			return true
+		}
+	}
+	return false
+}
+
+// ValidateTransition returns a *TransitionError when moving from s to the given status is illegal.
+func (s SubscriptionStatus) ValidateTransition(to SubscriptionStatus) error {
+	if !s.CanTransitionTo(to) {
+		return &TransitionError{From: s, To: to}
+	}
+	return nil
+}
+
+// ValidateReopen returns a *TransitionError unless s is a final status which may be
+// reopened to the given status.
+func (s SubscriptionStatus) ValidateReopen(to SubscriptionStatus) error {
+	for _, next := range reopenTransitions[s] {
+		if next == to {
+			return nil
+		}
+	}
+	return &TransitionError{From: s, To: to}
+}
+
+// DeliveryStatus is the status of a single dish_delivery row.
+type DeliveryStatus string
+
+const (
+	DeliveryScheduled      DeliveryStatus = "Scheduled"
+	DeliveryPreparing      DeliveryStatus = "Preparing"
+	DeliveryOutForDelivery DeliveryStatus = "Out for delivery"
+	DeliveryDelivered      DeliveryStatus = "Delivered"
+	DeliveryFailed         DeliveryStatus = "Failed"
+	DeliverySuspended      DeliveryStatus = "Suspended"
+	DeliverySkipped        DeliveryStatus = "Skipped"
+	DeliveryMissed         DeliveryStatus = "Missed"
+	DeliveryCancelled      DeliveryStatus = "Cancelled"
+)
+
+// undeliveredStatuses are the statuses of deliveries that may still go out
+var undeliveredStatuses = []DeliveryStatus{
+	DeliveryScheduled,
+	DeliveryPreparing,
+	DeliveryOutForDelivery,
+	DeliverySuspended,
+}
+
+// deliveryProgress ranks the statuses a courier moves a delivery through.
+// Deliveries only ever move forward, Delivered and Failed are final.
+var deliveryProgress = map[DeliveryStatus]int{
+	DeliveryScheduled:      0,
+	DeliveryPreparing:      1,
+	DeliveryOutForDelivery: 2,
+	DeliveryDelivered:      3,
+	DeliveryFailed:         3,
+}
+
+var ErrInvalidDeliveryProgress = errors.New("invalid delivery status transition")
+
+// DeliveryProgressError is returned when a courier update would move a delivery backwards.
+type DeliveryProgressError struct {
+	From DeliveryStatus
+	To   DeliveryStatus
+}
+
+func (e *DeliveryProgressError) Error() string {
+	return fmt.Sprintf("delivery cannot change from %s to %s", e.From, e.To)
+}
+
+func (e *DeliveryProgressError) Is(target error) bool {
+	return target == ErrInvalidDeliveryProgress
+}
+
+// IsFinal reports whether the delivery has been completed one way or another.
+func (s DeliveryStatus) IsFinal() bool {
+	return s == DeliveryDelivered || s == DeliveryFailed
+}
+
+// ValidateProgress returns a *DeliveryProgressError unless moving from s to the given
+// status is a step forward in the courier flow.
+func (s DeliveryStatus) ValidateProgress(to DeliveryStatus) error {
+	fromRank, fromOK := deliveryProgress[s]
+	toRank, toOK := deliveryProgress[to]
+	if !fromOK || !toOK || s.IsFinal() || toRank <= fromRank {
+		return &DeliveryProgressError{From: s, To: to}
+	}
+	return nil
+}
@@ -0,0 +1,83 @@
+package domain
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestSubscriptionStatusTransitions(t *testing.T) {
+	tests := []struct {
+		from    SubscriptionStatus
+		to      SubscriptionStatus
+		allowed bool
+	}{
+		{StatusPending, StatusActive, true},
+		{StatusPending, StatusPaused, false},
+		{StatusActive, StatusPaused, true},
+		{StatusActive, StatusCancelled, true},
+		{StatusActive, StatusExpired, true},
+		{StatusPaused, StatusActive, true},
+		{StatusCancelled, StatusCancelled, false},
+		{StatusCancelled, StatusActive, false},
+		{StatusExpired, StatusActive, false},
+	}
+
+	for _, tt := range tests {
+		err := tt.from.ValidateTransition(tt.to)
+		if tt.allowed && err != nil {
+			t.Errorf("%s -> %s: unexpected error %v", tt.from, tt.to, err)
+		}
+		if !tt.allowed && !errors.Is(err, ErrInvalidTransition) {
+			t.Errorf("%s -> %s: expected ErrInvalidTransition, got %v", tt.from, tt.to, err)
+		}
+	}
+}
+
+func TestSubscriptionStatusReopen(t *testing.T) {
+	if err := StatusCancelled.ValidateReopen(StatusActive); err != nil {
+		t.Errorf("Cancelled -> Active: unexpected error %v", err)
+	}
+	if err := StatusExpired.ValidateReopen(StatusActive); err != nil {
+		t.Errorf("Expired -> Active: unexpected error %v", err)
+	}
+	if err := StatusActive.ValidateReopen(StatusPaused); !errors.Is(err, ErrInvalidTransition) {
+		t.Errorf("Active -> Paused: expected ErrInvalidTransition, got %v", err)
+	}
+}
+
+func TestSubscriptionStatusIsValid(t *testing.T) {
+	if !StatusPaused.IsValid() {
+		t.Error("Paused should be a valid status")
+	}
+	if SubscriptionStatus("Completed").IsValid() {
+		t.Error("Completed should not be a valid subscription status")
+	}
+}
+
+func TestDeliveryProgress(t *testing.T) {
+	tests := []struct {
+		from    DeliveryStatus
+		to      DeliveryStatus
+		allowed bool
+	}{
+		{DeliveryScheduled, DeliveryPreparing, true},
+		{DeliveryPreparing, DeliveryOutForDelivery, true},
+		{DeliveryOutForDelivery, DeliveryDelivered, true},
+		{DeliveryOutForDelivery, DeliveryFailed, true},
+		{DeliveryScheduled, DeliveryOutForDelivery, true},
+		{DeliveryOutForDelivery, DeliveryPreparing, false},
+		{DeliveryDelivered, DeliveryFailed, false},
+		{DeliverySkipped, DeliveryPreparing, false},
+		{DeliveryPreparing, DeliverySkipped, false},
+	}
+
+	for _, tt := range tests {
+		err := tt.from.ValidateProgress(tt.to)
+		if tt.allowed && err != nil {
+			t.Errorf("%s -> %s: unexpected error %v", tt.from, tt.to, err)
+		}
+		if !tt.allowed && !errors.Is(err, ErrInvalidDeliveryProgress) {
+			t.Errorf("%s -> %s: expected ErrInvalidDeliveryProgress, got %v", tt.from, tt.to, err)
+		}
+	}
+}
@@ -0,0 +1,241 @@
+package domain
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ClaudiaYao/CapstoneSubscriptionService/app/data"
+)
+
+// defaultListLimit is the number of subscriptions on a page when no limit is given
+const defaultListLimit = 20
+
+// maxListLimit limits how many subscriptions are read at once
+const maxListLimit = 100
+
+var ErrInvalidListQuery = errors.New("invalid list query")
+
+// listCursor is the position a page starts from. It holds the sort order it was made for,
+// as a position has no meaning in another order.
+type listCursor struct {
+	SortBy     string               `json:"s"`
+	Descending bool                 `json:"d"`
+	Before     bool                 `json:"b,omitempty"`
+	Key        data.SubscriptionKey `json:"k"`
+}
+
+func (c listCursor) encode() string {
+	raw, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+func decodeListCursor(cursor string) (listCursor, error) {
+	var c listCursor
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return c, fmt.Errorf("%w: malformed cursor", ErrInvalidListQuery)
+	}
+	if err := json.Unmarshal(raw, &c); err != nil || c.Key.ID == "" {
+		return c, fmt.Errorf("%w: malformed cursor", ErrInvalidListQuery)
+	}
+	return c, nil
+}
+
+// ParseSubscriptionListQuery reads the query parameters of a listing of the user's
+// subscriptions:
+//
+//   - limit: the size of a page, 20 by default and at most 100
+//   - sort: start_date (the default) or status, the start date and id break ties
+//   - order: asc or desc (the default)
+//   - status: statuses separated by commas
+//   - from, to: the subscriptions running on some day between them, written as 2006-01-02
+//   - playlist: the playlist the subscriptions follow
+//   - customized: true or false
+//   - cursor: the nextCursor or prevCursor of a page, to read the page after or before it
+//
+// The filters are not kept in a cursor, they are given again with it.
+func ParseSubscriptionListQuery(userID string, values url.Values) (data.SubscriptionListQuery, error) {
+	q := data.SubscriptionListQuery{
+		UserID:     userID,
+		Limit:      defaultListLimit,
+		SortBy:     data.SortByStartDate,
+		Descending: true,
+		PlaylistID: values.Get("playlist"),
+	}
+
+	if limit := values.Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n < 1 || n > maxListLimit {
+			return q, fmt.Errorf("%w: limit must be between 1 and %d", ErrInvalidListQuery, maxListLimit)
+		}
+		q.Limit = n
+	}
+
+	switch sortBy := values.Get("sort"); sortBy {
+	case "":
+	case data.SortByStartDate, data.SortByStatus:
+		q.SortBy = sortBy
+	default:
+		return q, fmt.Errorf("%w: sort must be %s or %s", ErrInvalidListQuery, data.SortByStartDate, data.SortByStatus)
+	}
+
+	switch order := values.Get("order"); order {
+	case "", "desc":
+	case "asc":
+		q.Descending = false
+	default:
+		return q, fmt.Errorf("%w: order must be asc or desc", ErrInvalidListQuery)
+	}
+
+	if statuses := values.Get("status"); statuses != "" {
+		for _, status := range strings.Split(statuses, ",") {
+			status = strings.TrimSpace(status)
+			if !SubscriptionStatus(status).IsValid() {
+				return q, fmt.Errorf("%w: unknown status %q", ErrInvalidListQuery, status)
+			}
+			q.Statuses = append(q.Statuses, status)
+		}
+	}
+
+	var err error
+	if q.From, err = parseListDate(values.Get("from"), "from"); err != nil {
+		return q, err
+	}
+	if q.To, err = parseListDate(values.Get("to"), "to"); err != nil {
+		return q, err
+	}
+	if !q.From.IsZero() && !q.To.IsZero() && q.To.Before(q.From) {
+		return q, fmt.Errorf("%w: to is before from", ErrInvalidListQuery)
+	}
+
+	if customized := values.Get("customized"); customized != "" {
+		b, err := strconv.ParseBool(customized)
+		if err != nil {
+			return q, fmt.Errorf("%w: customized must be true or false", ErrInvalidListQuery)
+		}
+		q.Customized = &b
+	}
+
+	if cursor := values.Get("cursor"); cursor != "" {
+		c, err := decodeListCursor(cursor)
+		if err != nil {
+			return q, err
+		}
+		if c.SortBy != q.SortBy || c.Descending != q.Descending {
+			return q, fmt.Errorf("%w: the cursor belongs to another sort order", ErrInvalidListQuery)
+		}
+		if c.Before {
+			q.Before = &c.Key
+		} else {
+			q.After = &c.Key
+		}
+	}
+	return q, nil
+}
+
+func parseListDate(value, name string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	date, err := time.Parse(deliveryDateLayout, value)
+	if err != nil {
+		return date, fmt.Errorf("%w: %s must be written as YYYY-MM-DD", ErrInvalidListQuery, name)
+	}
+	return date, nil
+}
+
+// SubscriptionPage is one page of a listing. The cursors are empty when there is no page
+// after or before it.
+type SubscriptionPage struct {
+	Subscriptions []data.Subscription
+	NextCursor    string
+	PrevCursor    string
+}
+
+// ListSubscriptions returns the page of the user's subscriptions selected by q.
+func (service *SubscriptionService) ListSubscriptions(ctx context.Context, q data.SubscriptionListQuery) (SubscriptionPage, error) {
+	limit := q.Limit
+	// C: one more row tells whether there is a page beyond this one
+	q.Limit++
+	subscriptions, err := service.DBConnection.GetSubscriptionsPage(ctx, q)
+	if err != nil {
+		return SubscriptionPage{}, fmt.Errorf("error when querying the subscriptions: %w", err)
+	}
+
+	more := len(subscriptions) > limit
+	if more {
+		if q.Before != nil {
+			subscriptions = subscriptions[1:]
+		} else {
+			subscriptions = subscriptions[:limit]
+		}
+	}
+
+	page := SubscriptionPage{Subscriptions: subscriptions}
+	cursor := func(before bool, key data.SubscriptionKey) string {
+		return listCursor{SortBy: q.SortBy, Descending: q.Descending, Before: before, Key: key}.encode()
+	}
+	// C: an empty page still leads back to the rows of the cursor it was read from
+	first, last := q.After, q.Before
+	if len(subscriptions) > 0 {
+		firstKey, lastKey := subscriptionKey(subscriptions[0]), subscriptionKey(subscriptions[len(subscriptions)-1])
+		first, last = &firstKey, &lastKey
+	}
+
+	if (q.Before == nil && more) || (q.Before != nil && last != nil) {
+		page.NextCursor = cursor(false, *last)
+	}
+	if (q.Before != nil && more) || (q.After != nil && first != nil) {
+		page.PrevCursor = cursor(true, *first)
+	}
+	return page, nil
+}
+
+// dishesOfSubscriptions returns the dishes of the subscriptions by subscription id. They
+// are read with one query whatever the number of subscriptions.
+func (service *SubscriptionService) dishesOfSubscriptions(ctx context.Context, subscriptions []data.Subscription) (map[string][]data.SubscriptionDish, error) {
+	ids := make([]string, 0, len(subscriptions))
+	for _, sub := range subscriptions {
+		ids = append(ids, sub.ID)
+	}
+	dishes, err := service.DBConnection.GetDishesBySubscriptionIDs(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+
+	bySubscription := make(map[string][]data.SubscriptionDish, len(subscriptions))
+	for _, dish := range dishes {
+		bySubscription[dish.SubscriptionID] = append(bySubscription[dish.SubscriptionID], dish)
+	}
+	return bySubscription, nil
+}
+
+// subscriptionsWithDishes puts each subscription together with its dishes, in the time
+// zone of the subscription.
+func (service *SubscriptionService) subscriptionsWithDishes(ctx context.Context, subscriptions []data.Subscription) ([]SubscriptionServiceResponseDataDTO, error) {
+	dishes, err := service.dishesOfSubscriptions(ctx, subscriptions)
+	if err != nil {
+		return nil, err
+	}
+
+	DTOs := make([]SubscriptionServiceResponseDataDTO, 0, len(subscriptions))
+	for _, sub := range subscriptions {
+		local := localDishes(dishes[sub.ID], subscriptionLocation(sub))
+		DTOs = append(DTOs, SubscriptionServiceResponseDataDTO{
+			Subscription: sub,
+			DishIncluded: *convertDishToDTO(&local),
+		})
+	}
+	return DTOs, nil
+}
+
+func subscriptionKey(sub data.Subscription) data.SubscriptionKey {
+	return data.SubscriptionKey{StartDate: sub.StartDate, Status: sub.Status, ID: sub.ID}
+}
@@ -0,0 +1,87 @@
+package domain
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"testing"
+
+	"github.com/ClaudiaYao/CapstoneSubscriptionService/app/data"
+)
+
+func TestParseSubscriptionListQuery(t *testing.T) {
+	q, err := ParseSubscriptionListQuery("U1", url.Values{"status": {"Active, Paused"}, "customized": {"false"}, "from": {"2023-03-01"}})
+	if err != nil || q.Limit != defaultListLimit || q.SortBy != data.SortByStartDate || !q.Descending ||
+		len(q.Statuses) != 2 || q.Customized == nil || *q.Customized || !q.From.Equal(day(2023, 3, 1)) {
+		t.Errorf("got %+v %v", q, err)
+	}
+
+	cursor := listCursor{SortBy: data.SortByStatus, Key: data.SubscriptionKey{ID: "Sub1"}}.encode()
+	for _, values := range []url.Values{
+		{"limit": {"0"}},
+		{"limit": {"101"}},
+		{"sort": {"price"}},
+		{"order": {"up"}},
+		{"status": {"Active,Gone"}},
+		{"from": {"2023-03-02"}, "to": {"2023-03-01"}},
+		{"customized": {"maybe"}},
+		{"cursor": {"not a cursor"}},
+		// the cursor was made for the status order
+		{"cursor": {cursor}},
+	} {
+		if _, err := ParseSubscriptionListQuery("U1", values); !errors.Is(err, ErrInvalidListQuery) {
+			t.Errorf("%v: got %v, want ErrInvalidListQuery", values, err)
+		}
+	}
+}
+
+func TestListSubscriptionsFollowsCursors(t *testing.T) {
+	ctx := context.Background()
+	repo := data.NewMemoryRepository()
+	for i := 1; i <= 5; i++ {
+		sub := data.Subscription{ID: fmt.Sprintf("Sub%d", i), UserID: "U1", Status: string(StatusActive), Frequency: "FREQ=DAILY", StartDate: day(2023, 3, i), TimeZone: "UTC"}
+		if _, err := repo.InsertSubscription(ctx, sub); err != nil {
+			t.Fatal(err)
+		}
+	}
+	service := &SubscriptionService{DBConnection: repo}
+
+	read := func(cursor string) SubscriptionPage {
+		t.Helper()
+		q, err := ParseSubscriptionListQuery("U1", url.Values{"limit": {"2"}, "cursor": {cursor}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		page, err := service.ListSubscriptions(ctx, q)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return page
+	}
+	ids := func(page SubscriptionPage) (ids string) {
+		for _, sub := range page.Subscriptions {
+			ids += sub.ID + " "
+		}
+		return ids
+	}
+
+	first := read("")
+	if ids(first) != "Sub5 Sub4 " || first.PrevCursor != "" || first.NextCursor == "" {
+		t.Fatalf("got %s %+v on the first page", ids(first), first)
+	}
+	second := read(first.NextCursor)
+	if ids(second) != "Sub3 Sub2 " || second.PrevCursor == "" || second.NextCursor == "" {
+		t.Fatalf("got %s on the second page", ids(second))
+	}
+	last := read(second.NextCursor)
+	if ids(last) != "Sub1 " || last.NextCursor != "" {
+		t.Fatalf("got %s on the last page", ids(last))
+	}
+	if back := read(last.PrevCursor); ids(back) != ids(second) || back.NextCursor == "" || back.PrevCursor == "" {
+		t.Errorf("got %s going back from the last page, want %s", ids(back), ids(second))
+	}
+	if back := read(second.PrevCursor); ids(back) != ids(first) || back.PrevCursor != "" {
+		t.Errorf("got %s %q going back to the first page", ids(back), back.PrevCursor)
+	}
+}
@@ -0,0 +1,162 @@
+package domain
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/ClaudiaYao/CapstoneSubscriptionService/app/data"
+)
+
+var (
+	ErrInvalidOpeningHours = errors.New("invalid opening hours")
+	ErrOutsideOpeningHours = errors.New("delivery is outside the opening hours of the restaurant")
+)
+
+// OpeningHoursConflict is a delivery expected while the restaurant of its dish is closed.
+type OpeningHoursConflict struct {
+	DishID             string    `json:"dishID"`
+	SubscriptionDishID string    `json:"subscriptionDishID,omitempty"`
+	DeliveryID         string    `json:"deliveryID,omitempty"`
+	RestaurantID       string    `json:"restaurantID"`
+	ExpectedTime       time.Time `json:"expectedTime"`
+	OpeningHours       string    `json:"openingHours"`
+}
+
+// OpeningHoursError lists every delivery which falls outside the opening hours.
+type OpeningHoursError struct {
+	Conflicts []OpeningHoursConflict `json:"conflicts"`
+}
+
+func (e *OpeningHoursError) Error() string {
+	parts := []string{}
+	for _, conflict := range e.Conflicts {
+		parts = append(parts, fmt.Sprintf("dish %s on %s (%s)", conflict.DishID, conflict.ExpectedTime.Format(mailTimeLayout), conflict.OpeningHours))
+	}
+	return fmt.Sprintf("%d deliveries are outside the opening hours of their restaurant: %s", len(e.Conflicts), strings.Join(parts, "; "))
+}
+
+func (e *OpeningHoursError) Is(target error) bool {
+	return target == ErrOutsideOpeningHours
+}
+
+// weekdayIndex is the position of a weekday in WeeklyHours, which start on Monday
+func weekdayIndex(weekday time.Weekday) int {
+	return (int(weekday) + 6) % 7
+}
+
+// IsOpen reports whether the restaurant is open at the wall clock time of t, including
+// the hours after midnight of a day which closes late.
+func (h WeeklyHours) IsOpen(t time.Time) bool {
+	day := weekdayIndex(t.Weekday())
+	minute := t.Hour()*60 + t.Minute()
+	if today := h[day]; today.Close > 0 && minute >= today.Open && minute < today.Close {
+		return true
+	}
+	yesterday := h[(day+6)%7]
+	return minute+24*60 < yesterday.Close
+}
+
+// describe shows the opening hours of the day t falls on, e.g. "Monday 08:00-21:00".
+func (h WeeklyHours) describe(t time.Time) string {
+	hours := h[weekdayIndex(t.Weekday())]
+	if hours.Close == 0 {
+		return "closed on " + t.Weekday().String()
+	}
+	clock := func(minutes int) string {
+		return fmt.Sprintf("%02d:%02d", minutes/60%24, minutes%60)
+	}
+	return fmt.Sprintf("%s %s-%s", t.Weekday(), clock(hours.Open), clock(hours.Close))
+}
+
+// openingHoursConflicts checks the deliveries of a dish against the opening hours of its
+// restaurant. The hours are the local hours of the area the subscription is delivered in.
+// Dishes are not checked when no provider is configured or their restaurant is unknown.
+func (service *SubscriptionService) openingHoursConflicts(ctx context.Context, dish data.SubscriptionDish, deliveries []data.DishDelivery, loc *time.Location) ([]OpeningHoursConflict, error) {
+	if service.Restaurants == nil || service.RestaurantHours == nil || len(deliveries) == 0 {
+		return nil, nil
+	}
+
+	restaurantID, err := service.Restaurants.RestaurantOfDish(ctx, dish.DishID)
+	if errors.Is(err, data.ErrNotExist) {
+		log.Printf("opening hours: no restaurant for dish %s", dish.DishID)
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error when looking up the restaurant of dish %s: %w", dish.DishID, err)
+	}
+
+	hours, err := service.RestaurantHours.RestaurantHours(ctx, restaurantID)
+	if errors.Is(err, data.ErrNotExist) {
+		log.Printf("opening hours: no hours for restaurant %s", restaurantID)
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error when looking up the opening hours of restaurant %s: %w", restaurantID, err)
+	}
+
+	conflicts := []OpeningHoursConflict{}
+	for _, delivery := range deliveries {
+		local := delivery.ExpectedTime.In(loc)
+		if hours.IsOpen(local) {
+			continue
+		}
+		conflicts = append(conflicts, OpeningHoursConflict{
+			DishID:             dish.DishID,
+			SubscriptionDishID: dish.ID,
+			DeliveryID:         delivery.ID,
+			RestaurantID:       restaurantID,
+			ExpectedTime:       local,
+			OpeningHours:       hours.describe(local),
+		})
+	}
+	return conflicts, nil
+}
+
+// openPlannedTimes leaves out the planned deliveries of a dish which fall outside the
+// opening hours of its restaurant. The background jobs have nobody to report a conflict
+// to, so each one is logged instead.
+func (service *SubscriptionService) openPlannedTimes(ctx context.Context, dish data.SubscriptionDish, planned []time.Time, loc *time.Location) ([]time.Time, error) {
+	deliveries := newDeliveries(dish, planned)
+	conflicts, err := service.openingHoursConflicts(ctx, dish, deliveries, loc)
+	if err != nil || len(conflicts) == 0 {
+		return planned, err
+	}
+
+	closed := make(map[int64]bool, len(conflicts))
+	for _, conflict := range conflicts {
+		log.Printf("opening hours: no delivery of dish %s on %s (%s)", dish.DishID, conflict.ExpectedTime.Format(mailTimeLayout), conflict.OpeningHours)
+		closed[conflict.ExpectedTime.UnixNano()] = true
+	}
+	open := make([]time.Time, 0, len(planned)-len(conflicts))
+	for _, t := range planned {
+		if !closed[t.UnixNano()] {
+			open = append(open, t)
+		}
+	}
+	return open, nil
+}
+
+// checkPlannedOpeningHours checks every planned delivery of the dishes and reports all the
+// conflicts together.
+func (service *SubscriptionService) checkPlannedOpeningHours(ctx context.Context, dishes []data.SubscriptionDish, planned map[string][]time.Time, loc *time.Location) error {
+	conflicts := []OpeningHoursConflict{}
+	for _, dish := range dishes {
+		deliveries := []data.DishDelivery{}
+		for _, t := range planned[dish.ID] {
+			deliveries = append(deliveries, data.DishDelivery{SubscriptionDishID: dish.ID, ExpectedTime: t})
+		}
+		found, err := service.openingHoursConflicts(ctx, dish, deliveries, loc)
+		if err != nil {
+			return err
+		}
+		conflicts = append(conflicts, found...)
+	}
+	if len(conflicts) > 0 {
+		return &OpeningHoursError{Conflicts: conflicts}
+	}
+	return nil
+}
@@ -0,0 +1,101 @@
+package domain
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/ClaudiaYao/CapstoneSubscriptionService/app/data"
+)
+
+func TestParseWeeklyHours(t *testing.T) {
+	hours, err := ParseWeeklyHours([][]string{
+		{"0800", "2100"}, {"0800", "2100"}, {"0800", "2100"}, {"0800", "2100"},
+		{"0800", "2400"}, {"", ""}, {"1100", "0200"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		at   time.Time
+		open bool
+	}{
+		{time.Date(2023, 1, 2, 7, 59, 0, 0, time.UTC), false}, // Monday before opening
+		{time.Date(2023, 1, 2, 8, 0, 0, 0, time.UTC), true},
+		{time.Date(2023, 1, 2, 21, 0, 0, 0, time.UTC), false}, // closing time is not included
+		{time.Date(2023, 1, 6, 23, 30, 0, 0, time.UTC), true}, // Friday until midnight
+		{time.Date(2023, 1, 7, 12, 0, 0, 0, time.UTC), false}, // closed on Saturday
+		{time.Date(2023, 1, 9, 1, 30, 0, 0, time.UTC), true},  // Sunday night after midnight
+		{time.Date(2023, 1, 9, 2, 0, 0, 0, time.UTC), false},
+	}
+	for _, tt := range tests {
+		if open := hours.IsOpen(tt.at); open != tt.open {
+			t.Errorf("%v: got open %v, want %v", tt.at.Format("Mon 15:04"), open, tt.open)
+		}
+	}
+
+	if got := hours.describe(time.Date(2023, 1, 8, 9, 0, 0, 0, time.UTC)); got != "Sunday 11:00-02:00" {
+		t.Errorf("got %q", got)
+	}
+}
+
+func TestParseWeeklyHoursRejectsBadHours(t *testing.T) {
+	if _, err := ParseWeeklyHours([][]string{{"0800", "2100"}}); !errors.Is(err, ErrInvalidOpeningHours) {
+		t.Errorf("got %v, want ErrInvalidOpeningHours for a single day", err)
+	}
+	week := [][]string{{"8am", "2100"}, {}, {}, {}, {}, {}, {}}
+	if _, err := ParseWeeklyHours(week); !errors.Is(err, ErrInvalidOpeningHours) {
+		t.Errorf("got %v, want ErrInvalidOpeningHours", err)
+	}
+}
+
+func TestGeneratedDeliveriesRespectOpeningHours(t *testing.T) {
+	ctx := context.Background()
+	repo := data.NewMemoryRepository()
+	service, sub, dish, deliveries := newTestSubscription(t, repo, "FREQ=WEEKLY;BYDAY=MO,TU,TH,FR,SA,SU", time.Time{}, 14)
+
+	// the restaurant is closed on Wednesday
+	open := []string{"0800", "2100"}
+	hours, err := ParseWeeklyHours([][]string{open, open, {"", ""}, open, open, open, open})
+	if err != nil {
+		t.Fatal(err)
+	}
+	restaurants := &RestaurantFile{dishRestaurants: map[string]string{"D1": "R1", "D2": "R1"}, hours: map[string]WeeklyHours{"R1": hours}}
+	service.Restaurants, service.RestaurantHours = restaurants, restaurants
+
+	_, err = service.AddSubscriptionDish(ctx, sub.ID, SubscriptionDishRequested{DishID: "D2", ScheduleTime: dish.ScheduleTime, Frequency: "FREQ=DAILY"})
+	if !errors.Is(err, ErrOutsideOpeningHours) {
+		t.Errorf("got %v for a daily dish, want ErrOutsideOpeningHours", err)
+	}
+	if _, err := service.AddSubscriptionDish(ctx, sub.ID, SubscriptionDishRequested{DishID: "D2", Frequency: "FREQ=DAILY"}); !errors.Is(err, ErrScheduleTimeRequired) {
+		t.Errorf("got %v for a dish without a schedule time, want ErrScheduleTimeRequired", err)
+	}
+	if dishes, _ := repo.GetDishBySubscriptionID(ctx, sub.ID); len(dishes) != 1 {
+		t.Errorf("got %d dishes, want no dish added", len(dishes))
+	}
+
+	_, err = service.UpdateSubscriptionDish(ctx, sub.ID, dish.ID, SubscriptionDishChangeRequested{Frequency: "FREQ=DAILY"})
+	if !errors.Is(err, ErrOutsideOpeningHours) {
+		t.Errorf("got %v for a daily frequency, want ErrOutsideOpeningHours", err)
+	}
+	if after, _ := repo.GetDishDeliveryCondition(ctx, dish.ID); len(after) != len(deliveries) {
+		t.Errorf("got %d deliveries, want the %d planned before the rejected change", len(after), len(deliveries))
+	}
+
+	// the horizon job has nobody to report to, it leaves the closed days out
+	dish.Frequency = "FREQ=DAILY"
+	if _, err := repo.UpdateSubscriptionDish(ctx, dish); err != nil {
+		t.Fatal(err)
+	}
+	created, err := service.materializeHorizon(ctx, sub, testNow.AddDate(0, 0, 14))
+	if err != nil || len(created) == 0 {
+		t.Fatalf("got %d deliveries %v, want the horizon topped up", len(created), err)
+	}
+	for _, delivery := range created {
+		if delivery.ExpectedTime.Weekday() == time.Wednesday {
+			t.Errorf("got a delivery on %v, want none while the restaurant is closed", delivery.ExpectedTime)
+		}
+	}
+}
@@ -0,0 +1,338 @@
+package domain
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/ClaudiaYao/CapstoneSubscriptionService/app/data"
+	"github.com/ClaudiaYao/CapstoneSubscriptionService/app/recurrence"
+	"github.com/lithammer/shortuuid"
+)
+
+// ResumePolicy decides what happens to the deliveries that fell inside a pause window.
+type ResumePolicy string
+
+const (
+	// ResumeExtend moves the end date forward and re-adds the skipped deliveries at the end
+	ResumeExtend ResumePolicy = "extend"
+	// ResumeDrop simply drops the skipped deliveries
+	ResumeDrop ResumePolicy = "drop"
+)
+
+var (
+	ErrInvalidPauseWindow  = errors.New("pause end must be after pause start")
+	ErrInvalidResumePolicy = errors.New("resume policy must be either extend or drop")
+	ErrPauseScheduled      = errors.New("subscription already has a pause which has not ended")
+)
+
+type PauseResult struct {
+	Subscription        data.Subscription      `json:"subscription"`
+	Pause               data.SubscriptionPause `json:"pause"`
+	SuspendedDeliveries []data.DishDelivery    `json:"suspendedDeliveries"`
+}
+
+type ResumeResult struct {
+	Subscription       data.Subscription   `json:"subscription"`
+	Policy             ResumePolicy        `json:"policy"`
+	ResumedDeliveries  []data.DishDelivery `json:"resumedDeliveries"`
+	SkippedDeliveries  []data.DishDelivery `json:"skippedDeliveries"`
+	ExtendedDeliveries []data.DishDelivery `json:"extendedDeliveries,omitempty"`
+}
+
+// PauseSubscription pauses the subscription over [from, until). A zero from means now, a
+// zero until pauses until resumed. A pause starting later is only stored, the subscription
+// stays Active until ApplyPauses starts it on its start date.
+func (service *SubscriptionService) PauseSubscription(ctx context.Context, subscriptionID string, from, until time.Time, actor string) (*PauseResult, error) {
+	now := service.now()
+	if from.IsZero() || from.Before(now) {
+		from = now
+	}
+	if !until.IsZero() && !until.After(from) {
+		return nil, ErrInvalidPauseWindow
+	}
+
+	var result *PauseResult
+	err := service.inTransaction(ctx, func(tx *SubscriptionService) (err error) {
+		result, err = tx.pauseSubscription(ctx, subscriptionID, from, until, now, actor)
+		return err
+	})
+	return result, err
+}
+
+func (service *SubscriptionService) pauseSubscription(ctx context.Context, subscriptionID string, from, until, now time.Time, actor string) (*PauseResult, error) {
+	sub, err := service.DBConnection.GetSubscriptionByID(ctx, subscriptionID)
+	if err != nil {
+		return nil, err
+	}
+	if err := SubscriptionStatus(sub.Status).ValidateTransition(StatusPaused); err != nil {
+		return nil, err
+	}
+	if _, err := service.DBConnection.GetOpenSubscriptionPause(ctx, subscriptionID); err == nil {
+		return nil, ErrPauseScheduled
+	} else if !errors.Is(err, data.ErrNotExist) {
+		return nil, fmt.Errorf("error when querying the pause: %w", err)
+	}
+
+	pause := data.SubscriptionPause{
+		ID:             "SP" + shortuuid.New(),
+		SubscriptionID: subscriptionID,
+		PauseFrom:      from,
+		PauseUntil:     until,
+	}
+	if err := service.DBConnection.InsertSubscriptionPause(ctx, pause); err != nil {
+		return nil, fmt.Errorf("error when inserting the pause: %w", err)
+	}
+
+	suspended := []data.DishDelivery{}
+	if !from.After(now) {
+		sub, suspended, err = service.startPause(ctx, pause, actor)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	loc := subscriptionLocation(sub)
+	pause.PauseFrom = localTime(pause.PauseFrom, loc)
+	pause.PauseUntil = localTime(pause.PauseUntil, loc)
+	return &PauseResult{
+		Subscription:        sub,
+		Pause:               pause,
+		SuspendedDeliveries: localDeliveries(suspended, loc),
+	}, nil
+}
+
+// startPause marks the subscription Paused and suspends every scheduled delivery inside
+// the pause window.
+func (service *SubscriptionService) startPause(ctx context.Context, pause data.SubscriptionPause, actor string) (data.Subscription, []data.DishDelivery, error) {
+	sub, err := service.ChangeSubscriptionStatus(ctx, pause.SubscriptionID, StatusPaused, actor)
+	if err != nil {
+		return sub, nil, fmt.Errorf("error when pausing the subscription: %w", err)
+	}
+
+	suspended, err := service.changeDeliveryStatusInWindow(ctx, data.DeliveryWindowUpdate{
+		SubscriptionID: pause.SubscriptionID,
+		FromStatus:     string(DeliveryScheduled),
+		ToStatus:       string(DeliverySuspended),
+		From:           pause.PauseFrom,
+		Until:          pause.PauseUntil,
+	})
+	if err != nil {
+		return sub, nil, fmt.Errorf("error when suspending the dish deliveries: %w", err)
+	}
+	return sub, suspended, nil
+}
+
+// ApplyPauses starts every pause whose start date has come and resumes, with ResumeExtend,
+// every paused subscription whose pause has ended. A failing subscription is logged and
+// does not stop the others.
+func (service *SubscriptionService) ApplyPauses(ctx context.Context, now time.Time) error {
+	starting, err := service.DBConnection.GetPausesStartingBefore(ctx, string(StatusActive), now)
+	if err != nil {
+		return fmt.Errorf("error when querying starting pauses: %w", err)
+	}
+	for _, pause := range starting {
+		err := service.inTransaction(ctx, func(tx *SubscriptionService) error {
+			_, _, err := tx.startPause(ctx, pause, systemActor)
+			return err
+		})
+		if err != nil && !errors.Is(err, ErrStatusConflict) {
+			log.Printf("scheduler: could not pause subscription %s: %v", pause.SubscriptionID, err)
+		}
+	}
+
+	ending, err := service.DBConnection.GetPausesEndingBefore(ctx, string(StatusPaused), now)
+	if err != nil {
+		return fmt.Errorf("error when querying ended pauses: %w", err)
+	}
+	for _, pause := range ending {
+		err := service.inTransaction(ctx, func(tx *SubscriptionService) error {
+			_, err := tx.resumeSubscription(ctx, pause.SubscriptionID, ResumeExtend, now, systemActor)
+			return err
+		})
+		if err != nil && !errors.Is(err, ErrStatusConflict) {
+			log.Printf("scheduler: could not resume subscription %s: %v", pause.SubscriptionID, err)
+		}
+	}
+	return nil
+}
+
+// ResumeSubscription makes a paused subscription Active again. Suspended deliveries that
+// are still ahead go back to the schedule; those that passed during the pause are skipped
+// and, with ResumeExtend, re-added after the last delivery of their dish. A pause which
+// has not started yet is withdrawn.
+func (service *SubscriptionService) ResumeSubscription(ctx context.Context, subscriptionID string, policy ResumePolicy, actor string) (*ResumeResult, error) {
+	if policy == "" {
+		policy = ResumeExtend
+	}
+	if policy != ResumeExtend && policy != ResumeDrop {
+		return nil, ErrInvalidResumePolicy
+	}
+
+	var result *ResumeResult
+	err := service.inTransaction(ctx, func(tx *SubscriptionService) (err error) {
+		result, err = tx.resumeSubscription(ctx, subscriptionID, policy, service.now(), actor)
+		return err
+	})
+	return result, err
+}
+
+func (service *SubscriptionService) resumeSubscription(ctx context.Context, subscriptionID string, policy ResumePolicy, now time.Time, actor string) (*ResumeResult, error) {
+	pause, err := service.DBConnection.GetOpenSubscriptionPause(ctx, subscriptionID)
+	if err != nil && !errors.Is(err, data.ErrNotExist) {
+		return nil, fmt.Errorf("error when querying the pause: %w", err)
+	}
+
+	if pause.ID != "" && pause.PauseFrom.After(now) {
+		// the pause has not started yet, withdrawing it leaves the subscription as it is
+		sub, err := service.DBConnection.GetSubscriptionByID(ctx, subscriptionID)
+		if err != nil {
+			return nil, err
+		}
+		if err := service.DBConnection.CloseSubscriptionPause(ctx, now, string(policy), pause.ID); err != nil {
+			return nil, fmt.Errorf("error when closing the pause: %w", err)
+		}
+		return &ResumeResult{Subscription: sub, Policy: policy}, nil
+	}
+
+	sub, err := service.ChangeSubscriptionStatus(ctx, subscriptionID, StatusActive, actor)
+	if err != nil {
+		return nil, fmt.Errorf("error when resuming the subscription: %w", err)
+	}
+
+	resumed, err := service.changeDeliveryStatusInWindow(ctx, data.DeliveryWindowUpdate{
+		SubscriptionID: subscriptionID,
+		FromStatus:     string(DeliverySuspended),
+		ToStatus:       string(DeliveryScheduled),
+		From:           now,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error when resuming the dish deliveries: %w", err)
+	}
+
+	skipped, err := service.changeDeliveryStatusInWindow(ctx, data.DeliveryWindowUpdate{
+		SubscriptionID: subscriptionID,
+		FromStatus:     string(DeliverySuspended),
+		ToStatus:       string(DeliverySkipped),
+		Until:          now,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error when skipping the dish deliveries: %w", err)
+	}
+
+	loc := subscriptionLocation(sub)
+	result := &ResumeResult{
+		Policy:            policy,
+		ResumedDeliveries: localDeliveries(resumed, loc),
+		SkippedDeliveries: localDeliveries(skipped, loc),
+	}
+
+	if sub.EndDate.IsZero() {
+		// open ended subscriptions have no end date to move, the rolling horizon is
+		// simply topped up again since it was not extended while paused
+		if _, err := service.materializeHorizon(ctx, sub, now); err != nil {
+			return nil, err
+		}
+	} else if policy == ResumeExtend && len(skipped) > 0 {
+		extended, latest, err := service.appendDeliveries(ctx, sub, skipped)
+		if err != nil {
+			return nil, err
+		}
+		if endDate := civilDate(latest, loc); endDate.After(sub.EndDate) {
+			sub, err = service.updateSubscriptionEndDate(ctx, sub, endDate)
+			if err != nil {
+				return nil, fmt.Errorf("error when extending the subscription: %w", err)
+			}
+		}
+		result.ExtendedDeliveries = localDeliveries(extended, loc)
+	}
+
+	if pause.ID != "" {
+		if err := service.DBConnection.CloseSubscriptionPause(ctx, now, string(policy), pause.ID); err != nil {
+			return nil, fmt.Errorf("error when closing the pause: %w", err)
+		}
+	}
+
+	result.Subscription = sub
+	return result, nil
+}
+
+// appendDeliveries adds, for every skipped delivery, one more delivery of the same dish
+// after the dish's last delivery. It returns the new rows and the latest expected time.
+func (service *SubscriptionService) appendDeliveries(ctx context.Context, sub data.Subscription, skipped []data.DishDelivery) ([]data.DishDelivery, time.Time, error) {
+	missing := map[string]int{}
+	for _, delivery := range skipped {
+		missing[delivery.SubscriptionDishID]++
+	}
+
+	dishes, err := service.DBConnection.GetDishBySubscriptionID(ctx, sub.ID)
+	if err != nil {
+		return nil, time.Time{}, fmt.Errorf("error when querying the dishes: %w", err)
+	}
+
+	loc := subscriptionLocation(sub)
+	added := []data.DishDelivery{}
+	var latest time.Time
+	for _, dish := range dishes {
+		if missing[dish.ID] == 0 {
+			continue
+		}
+
+		deliveries, err := service.DBConnection.GetDishDeliveryCondition(ctx, dish.ID)
+		if err != nil {
+			return nil, time.Time{}, fmt.Errorf("error when querying the dish deliveries: %w", err)
+		}
+
+		rule, err := recurrence.Parse(dish.Frequency)
+		if err != nil {
+			return nil, time.Time{}, err
+		}
+
+		dtstart := dish.ScheduleTime.In(loc)
+		nextTime := dtstart
+		for _, delivery := range deliveries {
+			if delivery.ExpectedTime.After(nextTime) {
+				nextTime = delivery.ExpectedTime
+			}
+		}
+
+		calendar, err := service.loadBlackoutCalendar(ctx, sub, dish, nextTime, nextTime.AddDate(0, 0, missing[dish.ID]*blackoutLookahead(rule)))
+		if err != nil {
+			return nil, time.Time{}, err
+		}
+
+		lastDay := civilDate(nextTime, loc)
+		for count := 0; count < missing[dish.ID]; {
+			next, ok := rule.After(dtstart, nextTime)
+			if !ok {
+				// the recurrence rule has ended, there is no later delivery to add
+				break
+			}
+			nextTime = next
+			expected, ok := calendar.apply(next, loc)
+			if !ok || !civilDate(expected, loc).After(lastDay) {
+				// skipped by a blackout, the next occurrence makes up for it
+				continue
+			}
+			lastDay = civilDate(expected, loc)
+			count++
+			dishDelivery := data.DishDelivery{
+				ID:                 "DD" + shortuuid.New(),
+				SubscriptionDishID: dish.ID,
+				Status:             string(DeliveryScheduled),
+				ExpectedTime:       expected,
+				Note:               dish.Note,
+			}
+			added = append(added, dishDelivery)
+			if expected.After(latest) {
+				latest = expected
+			}
+		}
+	}
+	if err := service.insertDishDeliveries(ctx, sub, dishes, added); err != nil {
+		return nil, time.Time{}, fmt.Errorf("error when inserting the dish deliveries: %w", err)
+	}
+	return added, latest, nil
+}
@@ -0,0 +1,104 @@
+package domain
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/ClaudiaYao/CapstoneSubscriptionService/app/data"
+)
+
+func TestScheduledPauseStartsAndEnds(t *testing.T) {
+	ctx := context.Background()
+	service, sub, _, deliveries := newTestSubscription(t, data.NewMemoryRepository(), "FREQ=DAILY", day(2023, 3, 18), 0)
+	repo := service.DBConnection
+
+	// the pause covers the deliveries of the fourth and fifth day
+	from := sub.StartDate.AddDate(0, 0, 4)
+	until := from.AddDate(0, 0, 2)
+	result, err := service.PauseSubscription(ctx, sub.ID, from, until, "U1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Subscription.Status != string(StatusActive) || len(result.SuspendedDeliveries) != 0 {
+		t.Errorf("got %s with %d suspended deliveries, want the pause to wait for its start", result.Subscription.Status, len(result.SuspendedDeliveries))
+	}
+	if _, err := service.PauseSubscription(ctx, sub.ID, time.Time{}, time.Time{}, "U1"); !errors.Is(err, ErrPauseScheduled) {
+		t.Errorf("got %v for a second pause, want ErrPauseScheduled", err)
+	}
+
+	if err := service.ApplyPauses(ctx, from.Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	paused, err := repo.GetSubscriptionByID(ctx, sub.ID)
+	if err != nil || paused.Status != string(StatusPaused) {
+		t.Fatalf("got %+v %v, want the subscription paused on the start date", paused, err)
+	}
+
+	if err := service.ApplyPauses(ctx, until.Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	resumed, err := repo.GetSubscriptionByID(ctx, sub.ID)
+	if err != nil || resumed.Status != string(StatusActive) {
+		t.Fatalf("got %+v %v, want the subscription resumed at the end of the pause", resumed, err)
+	}
+	if !resumed.EndDate.Equal(sub.EndDate.AddDate(0, 0, 2)) {
+		t.Errorf("got end date %v, want it moved by the two skipped days", resumed.EndDate)
+	}
+	if _, err := repo.GetOpenSubscriptionPause(ctx, sub.ID); !errors.Is(err, data.ErrNotExist) {
+		t.Errorf("got %v, want the pause closed", err)
+	}
+
+	after, err := repo.GetDishDeliveryCondition(ctx, deliveries[0].SubscriptionDishID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(after) != len(deliveries)+2 {
+		t.Errorf("got %d deliveries, want the two skipped ones re-added", len(after))
+	}
+	for _, delivery := range after {
+		inPause := !delivery.ExpectedTime.Before(from) && delivery.ExpectedTime.Before(until)
+		if inPause != (delivery.Status == string(DeliverySkipped)) {
+			t.Errorf("%s at %v is %s, want only the deliveries inside the pause skipped", delivery.ID, delivery.ExpectedTime, delivery.Status)
+		}
+	}
+}
+
+func TestResumePolicies(t *testing.T) {
+	for _, policy := range []ResumePolicy{ResumeExtend, ResumeDrop} {
+		t.Run(string(policy), func(t *testing.T) {
+			ctx := context.Background()
+			service, sub, _, deliveries := newTestSubscription(t, data.NewMemoryRepository(), "FREQ=DAILY", day(2023, 3, 18), 0)
+
+			paused, err := service.PauseSubscription(ctx, sub.ID, time.Time{}, time.Time{}, "U1")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if paused.Subscription.Status != string(StatusPaused) || len(paused.SuspendedDeliveries) == 0 {
+				t.Fatalf("got %s with %d suspended deliveries, want the pause to start now", paused.Subscription.Status, len(paused.SuspendedDeliveries))
+			}
+
+			result, err := service.resumeSubscription(ctx, sub.ID, policy, testNow.AddDate(0, 0, 3), "U1")
+			if err != nil {
+				t.Fatal(err)
+			}
+			skipped := len(result.SkippedDeliveries)
+			if skipped == 0 || skipped+len(result.ResumedDeliveries) != len(paused.SuspendedDeliveries) {
+				t.Errorf("got %d skipped and %d resumed of %d suspended deliveries", skipped, len(result.ResumedDeliveries), len(paused.SuspendedDeliveries))
+			}
+
+			wantAdded, wantEnd := 0, sub.EndDate
+			if policy == ResumeExtend {
+				wantAdded, wantEnd = skipped, sub.EndDate.AddDate(0, 0, skipped)
+			}
+			if len(result.ExtendedDeliveries) != wantAdded || !result.Subscription.EndDate.Equal(wantEnd) {
+				t.Errorf("got %d added deliveries and end date %v, want %d and %v", len(result.ExtendedDeliveries), result.Subscription.EndDate, wantAdded, wantEnd)
+			}
+			after, err := service.DBConnection.GetDishDeliveryCondition(ctx, deliveries[0].SubscriptionDishID)
+			if err != nil || len(after) != len(deliveries)+wantAdded {
+				t.Errorf("got %d deliveries %v, want %d", len(after), err, len(deliveries)+wantAdded)
+			}
+		})
+	}
+}
@@ -0,0 +1,104 @@
+package domain
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/ClaudiaYao/CapstoneSubscriptionService/app/data"
+)
+
+// PlaylistClient is a RestaurantLookup, DishCatalogue and RestaurantHoursProvider which asks
+// the playlist service, the owner of dishes and restaurants.
+type PlaylistClient struct {
+	BaseURL string
+	Client  *http.Client
+}
+
+func NewPlaylistClient(baseURL string) *PlaylistClient {
+	return &PlaylistClient{
+		BaseURL: baseURL,
+		Client:  &http.Client{Timeout: 5 * time.Second},
+	}
+}
+
+type playlistDish struct {
+	ID           string `json:"id"`
+	Name         string `json:"name"`
+	RestaurantID string `json:"restaurantID"`
+}
+
+func (c *PlaylistClient) RestaurantOfDish(ctx context.Context, dishID string) (string, error) {
+	var dish playlistDish
+	if err := c.get(ctx, "/playlist/dish/"+url.PathEscape(dishID), &dish); err != nil {
+		return "", err
+	}
+	return dish.RestaurantID, nil
+}
+
+func (c *PlaylistClient) DishesOfRestaurant(ctx context.Context, restaurantID string) ([]string, error) {
+	var dishes []playlistDish
+	if err := c.get(ctx, "/playlist/restaurant/"+url.PathEscape(restaurantID)+"/dish", &dishes); err != nil {
+		return nil, err
+	}
+	dishIDs := make([]string, 0, len(dishes))
+	for _, dish := range dishes {
+		dishIDs = append(dishIDs, dish.ID)
+	}
+	return dishIDs, nil
+}
+
+func (c *PlaylistClient) DishName(ctx context.Context, dishID string) (string, error) {
+	var dish playlistDish
+	if err := c.get(ctx, "/playlist/dish/"+url.PathEscape(dishID), &dish); err != nil {
+		return "", err
+	}
+	return dish.Name, nil
+}
+
+func (c *PlaylistClient) RestaurantHours(ctx context.Context, restaurantID string) (WeeklyHours, error) {
+	var restaurant struct {
+		OperationHours [][]string `json:"operationHours"`
+	}
+	if err := c.get(ctx, "/playlist/restaurant/"+url.PathEscape(restaurantID), &restaurant); err != nil {
+		return WeeklyHours{}, err
+	}
+	return ParseWeeklyHours(restaurant.OperationHours)
+}
+
+// get reads the data of a jsonResponse of the playlist service into dst.
+func (c *PlaylistClient) get(ctx context.Context, path string, dst any) error {
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, c.BaseURL+path, nil)
+	if err != nil {
+		return err
+	}
+
+	response, err := c.Client.Do(request)
+	if err != nil {
+		return fmt.Errorf("error calling playlist service: %w", err)
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode == http.StatusNotFound {
+		return data.ErrNotExist
+	}
+	if response.StatusCode != http.StatusOK && response.StatusCode != http.StatusAccepted {
+		return fmt.Errorf("error calling playlist service: status %d", response.StatusCode)
+	}
+
+	var payload struct {
+		Error   bool            `json:"error"`
+		Message string          `json:"message"`
+		Data    json.RawMessage `json:"data"`
+	}
+	if err := json.NewDecoder(response.Body).Decode(&payload); err != nil {
+		return fmt.Errorf("error reading playlist service response: %w", err)
+	}
+	if payload.Error {
+		return fmt.Errorf("playlist service: %s", payload.Message)
+	}
+	return json.Unmarshal(payload.Data, dst)
+}
@@ -0,0 +1,109 @@
+package domain
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/ClaudiaYao/CapstoneSubscriptionService/app/data"
+)
+
+var (
+	ErrSubscriptionNotClosed = errors.New("only cancelled or expired subscriptions can be reactivated")
+	ErrInvalidReactivation   = errors.New("reactivation end date must be after its start date")
+)
+
+type ReactivationResult struct {
+	Subscription data.Subscription   `json:"subscription"`
+	Deliveries   []data.DishDelivery `json:"deliveries"`
+}
+
+// ReactivateSubscription moves a cancelled or expired subscription back to Active for a
+// new date range. The subscription keeps its id and dishes, only the deliveries from the
+// new start date on are created again. A zero start date means now, a zero end date makes
+// the subscription open ended.
+func (service *SubscriptionService) ReactivateSubscription(ctx context.Context, subscriptionID string, startDate, endDate time.Time, actor string) (*ReactivationResult, error) {
+	var result *ReactivationResult
+	err := service.inTransaction(ctx, func(tx *SubscriptionService) (err error) {
+		result, err = tx.reactivateSubscription(ctx, subscriptionID, startDate, endDate, actor)
+		return err
+	})
+	return result, err
+}
+
+func (service *SubscriptionService) reactivateSubscription(ctx context.Context, subscriptionID string, startDate, endDate time.Time, actor string) (*ReactivationResult, error) {
+	now := service.now()
+	if startDate.IsZero() || startDate.Before(now) {
+		startDate = now
+	}
+	if !endDate.IsZero() && !endDate.After(startDate) {
+		return nil, ErrInvalidReactivation
+	}
+
+	sub, err := service.DBConnection.GetSubscriptionByID(ctx, subscriptionID)
+	if err != nil {
+		return nil, err
+	}
+
+	status := SubscriptionStatus(sub.Status)
+	if status != StatusCancelled && status != StatusExpired {
+		return nil, ErrSubscriptionNotClosed
+	}
+	if sub.AutoRenew && endDate.IsZero() {
+		return nil, ErrRenewalNeedsEndDate
+	}
+
+	reopened, err := service.reopenSubscription(ctx, subscriptionID, StatusActive, actor)
+	if err != nil {
+		return nil, fmt.Errorf("error when reactivating the subscription: %w", err)
+	}
+
+	loc := subscriptionLocation(sub)
+	startDate = civilDate(startDate, loc)
+	term := 0
+	if !endDate.IsZero() {
+		endDate = civilDate(endDate, loc)
+		term = termDays(startDate, endDate)
+	}
+	sub, err = service.DBConnection.UpdateSubscriptionTerm(ctx, startDate, endDate, term, subscriptionID)
+	if err != nil {
+		return nil, fmt.Errorf("error when updating the subscription term: %w", err)
+	}
+	if err := service.audit(ctx, subscriptionID, AuditSubscription, subscriptionID, AuditTermChanged, reopened, sub); err != nil {
+		return nil, err
+	}
+
+	// a pause left open by the cancellation is over now
+	pause, err := service.DBConnection.GetOpenSubscriptionPause(ctx, subscriptionID)
+	if err != nil && !errors.Is(err, data.ErrNotExist) {
+		return nil, fmt.Errorf("error when querying the pause: %w", err)
+	}
+	if pause.ID != "" {
+		if err := service.DBConnection.CloseSubscriptionPause(ctx, now, string(ResumeDrop), pause.ID); err != nil {
+			return nil, fmt.Errorf("error when closing the pause: %w", err)
+		}
+	}
+
+	// the deliveries cancelled with the subscription are replaced by the new schedule,
+	// past ones stay as they are
+	dishes, err := service.DBConnection.GetDishBySubscriptionID(ctx, subscriptionID)
+	if err != nil {
+		return nil, fmt.Errorf("error when querying the dishes: %w", err)
+	}
+	for _, dish := range dishes {
+		if _, err := service.deleteDishDeliveries(ctx, sub, dish, DeliveryCancelled, now); err != nil {
+			return nil, fmt.Errorf("error when deleting the cancelled deliveries: %w", err)
+		}
+	}
+
+	deliveries, err := service.materializeHorizon(ctx, sub, now)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ReactivationResult{
+		Subscription: sub,
+		Deliveries:   localDeliveries(deliveries, loc),
+	}, nil
+}
@@ -0,0 +1,129 @@
+package domain
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/ClaudiaYao/CapstoneSubscriptionService/app/data"
+	"github.com/lithammer/shortuuid"
+)
+
+var ErrEffectiveDateTooEarly = errors.New("receiver changes cannot take effect before an earlier change or in the past")
+
+// ReceiverChangeRequested holds new receiver details. Empty fields keep their current value,
+// and a zero EffectiveFrom applies the change to every delivery from now on.
+type ReceiverChangeRequested struct {
+	ReceiverName         string    `json:"receiverName,omitempty"`
+	ReceiverContact      string    `json:"receiverContact,omitempty"`
+	DeliveryInstructions *string   `json:"deliveryInstructions,omitempty"`
+	EffectiveFrom        time.Time `json:"effectiveFrom,omitempty"`
+}
+
+// ChangeReceiver replaces the receiver details of a subscription for every delivery expected
+// from the effective date on. The old details are kept in the history and still apply to
+// the deliveries before that date, including every delivery already dispatched.
+func (service *SubscriptionService) ChangeReceiver(ctx context.Context, subscriptionID string, change ReceiverChangeRequested, actor string) (data.Subscription, error) {
+	sub, err := service.DBConnection.GetSubscriptionByID(ctx, subscriptionID)
+	if err != nil {
+		return sub, err
+	}
+
+	status := SubscriptionStatus(sub.Status)
+	if status == StatusCancelled || status == StatusExpired {
+		return sub, ErrSubscriptionClosed
+	}
+
+	now := service.now()
+	effectiveFrom := change.EffectiveFrom
+	if effectiveFrom.IsZero() {
+		effectiveFrom = now
+	}
+	if effectiveFrom.Before(now) {
+		return sub, ErrEffectiveDateTooEarly
+	}
+
+	history, err := service.DBConnection.GetReceiverHistory(ctx, subscriptionID)
+	if err != nil {
+		return sub, fmt.Errorf("error when querying the receiver history: %w", err)
+	}
+	if len(history) > 0 && effectiveFrom.Before(history[len(history)-1].ValidUntil) {
+		return sub, ErrEffectiveDateTooEarly
+	}
+
+	receiver := data.ReceiverDetails{
+		ReceiverName:         sub.ReceiverName,
+		ReceiverContact:      sub.ReceiverContact,
+		DeliveryInstructions: sub.DeliveryInstructions,
+	}
+	if change.ReceiverName != "" {
+		receiver.ReceiverName = change.ReceiverName
+	}
+	if change.ReceiverContact != "" {
+		receiver.ReceiverContact = change.ReceiverContact
+	}
+	if change.DeliveryInstructions != nil {
+		receiver.DeliveryInstructions = *change.DeliveryInstructions
+	}
+
+	replaced := data.ReceiverHistory{
+		ID:         "RH" + shortuuid.New(),
+		ValidUntil: effectiveFrom,
+		ChangedBy:  actor,
+		ChangedAt:  now,
+	}
+	before := sub
+	err = service.inTransaction(ctx, func(tx *SubscriptionService) error {
+		sub, err = tx.DBConnection.ChangeSubscriptionReceiver(ctx, subscriptionID, receiver, replaced)
+		if err != nil {
+			return err
+		}
+		return tx.audit(ctx, subscriptionID, AuditSubscription, subscriptionID, AuditReceiverChanged, before, sub)
+	})
+	return sub, err
+}
+
+// ReceiverForDelivery returns the receiver details which apply to a single delivery.
+func (service *SubscriptionService) ReceiverForDelivery(ctx context.Context, deliveryID string) (data.ReceiverDetails, error) {
+	delivery, err := service.DBConnection.GetDishDeliveryByID(ctx, deliveryID)
+	if err != nil {
+		return data.ReceiverDetails{}, err
+	}
+
+	dish, err := service.DBConnection.GetSubscriptionDishByID(ctx, delivery.SubscriptionDishID)
+	if err != nil {
+		return data.ReceiverDetails{}, err
+	}
+
+	sub, err := service.DBConnection.GetSubscriptionByID(ctx, dish.SubscriptionID)
+	if err != nil {
+		return data.ReceiverDetails{}, err
+	}
+
+	history, err := service.DBConnection.GetReceiverHistory(ctx, sub.ID)
+	if err != nil {
+		return data.ReceiverDetails{}, fmt.Errorf("error when querying the receiver history: %w", err)
+	}
+
+	return receiverAt(sub, history, delivery.ExpectedTime), nil
+}
+
+// receiverAt picks the receiver details valid at the given time. history must be ordered
+// by ValidUntil; the details on the subscription itself apply after the last change.
+func receiverAt(sub data.Subscription, history []data.ReceiverHistory, at time.Time) data.ReceiverDetails {
+	for _, old := range history {
+		if at.Before(old.ValidUntil) {
+			return data.ReceiverDetails{
+				ReceiverName:         old.ReceiverName,
+				ReceiverContact:      old.ReceiverContact,
+				DeliveryInstructions: old.DeliveryInstructions,
+			}
+		}
+	}
+	return data.ReceiverDetails{
+		ReceiverName:         sub.ReceiverName,
+		ReceiverContact:      sub.ReceiverContact,
+		DeliveryInstructions: sub.DeliveryInstructions,
+	}
+}
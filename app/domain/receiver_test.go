@@ -0,0 +1,35 @@
+package domain
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ClaudiaYao/CapstoneSubscriptionService/app/data"
+)
+
+func TestReceiverAt(t *testing.T) {
+	change1 := time.Date(2023, 4, 10, 0, 0, 0, 0, time.UTC)
+	change2 := time.Date(2023, 4, 20, 0, 0, 0, 0, time.UTC)
+
+	sub := data.Subscription{ReceiverName: "Current", ReceiverContact: "3"}
+	history := []data.ReceiverHistory{
+		{ReceiverName: "First", ReceiverContact: "1", ValidUntil: change1},
+		{ReceiverName: "Second", ReceiverContact: "2", DeliveryInstructions: "leave at door", ValidUntil: change2},
+	}
+
+	tests := []struct {
+		at   time.Time
+		want string
+	}{
+		{change1.Add(-time.Hour), "First"},
+		{change1, "Second"},
+		{change2.Add(-time.Hour), "Second"},
+		{change2, "Current"},
+	}
+
+	for _, tt := range tests {
+		if got := receiverAt(sub, history, tt.at); got.ReceiverName != tt.want {
+			t.Errorf("at %s: expected %s, got %s", tt.at, tt.want, got.ReceiverName)
+		}
+	}
+}
@@ -0,0 +1,131 @@
+package domain
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/ClaudiaYao/CapstoneSubscriptionService/app/data"
+)
+
+var (
+	ErrRenewalNeedsEndDate = errors.New("only subscriptions with an end date can renew automatically")
+	ErrSubscriptionClosed  = errors.New("subscription is already cancelled or expired")
+)
+
+// SetAutoRenew turns automatic renewal on or off. It can be changed any time before the
+// subscription is cancelled or expired.
+func (service *SubscriptionService) SetAutoRenew(ctx context.Context, subscriptionID string, autoRenew bool) (data.Subscription, error) {
+	sub, err := service.DBConnection.GetSubscriptionByID(ctx, subscriptionID)
+	if err != nil {
+		return sub, err
+	}
+
+	status := SubscriptionStatus(sub.Status)
+	if status == StatusCancelled || status == StatusExpired {
+		return sub, ErrSubscriptionClosed
+	}
+	if autoRenew && sub.EndDate.IsZero() {
+		return sub, ErrRenewalNeedsEndDate
+	}
+
+	err = service.inTransaction(ctx, func(tx *SubscriptionService) error {
+		before := sub
+		sub, err = tx.DBConnection.UpdateSubscriptionAutoRenew(ctx, autoRenew, subscriptionID)
+		if err != nil {
+			return err
+		}
+		return tx.audit(ctx, subscriptionID, AuditSubscription, subscriptionID, AuditAutoRenewChanged, before, sub)
+	})
+	return sub, err
+}
+
+// RenewSubscriptions starts the next term of every active subscription which renews
+// automatically and reaches its end date today or has already passed it.
+func (service *SubscriptionService) RenewSubscriptions(ctx context.Context, now time.Time) error {
+	// C: the end date is compared with today in the subscription's own zone
+	dayAfterTomorrow := civilDate(now, time.UTC).AddDate(0, 0, 2)
+
+	subscriptions, err := service.DBConnection.GetRenewableSubscriptions(ctx, string(StatusActive), dayAfterTomorrow)
+	if err != nil {
+		return fmt.Errorf("error when querying renewable subscriptions: %w", err)
+	}
+
+	for _, sub := range subscriptions {
+		if sub.EndDate.After(civilDate(now, subscriptionLocation(sub))) {
+			continue
+		}
+		if err := service.renewSubscription(ctx, sub, now); err != nil {
+			log.Printf("scheduler: could not renew subscription %s: %v", sub.ID, err)
+		}
+	}
+	return nil
+}
+
+// renewSubscription moves the end date one term forward and creates the deliveries of the
+// new term from the same subscription dishes.
+func (service *SubscriptionService) renewSubscription(ctx context.Context, sub data.Subscription, now time.Time) error {
+	term := sub.TermDays
+	if term <= 0 {
+		term = termDays(sub.StartDate, sub.EndDate)
+	}
+	if term <= 0 {
+		return fmt.Errorf("subscription has no term length")
+	}
+
+	// C: the receiver is only told once the new term and its deliveries are stored
+	var renewed data.Subscription
+	var deliveries []data.DishDelivery
+	err := service.inTransaction(ctx, func(tx *SubscriptionService) (err error) {
+		renewed, err = tx.renewTerm(ctx, sub, sub.EndDate.AddDate(0, 0, term))
+		if err != nil {
+			return err
+		}
+		deliveries, err = tx.materializeHorizon(ctx, renewed, now)
+		return err
+	})
+	if errors.Is(err, ErrStatusConflict) {
+		// renewal was turned off or the subscription changed in the meantime
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	// the notice goes to whoever receives the first deliveries of the new term
+	history, err := service.DBConnection.GetReceiverHistory(ctx, renewed.ID)
+	if err != nil {
+		log.Printf("scheduler: could not query the receivers of subscription %s: %v", renewed.ID, err)
+	}
+	newTermStart := startOfDay(sub.EndDate.AddDate(0, 0, 1), subscriptionLocation(renewed))
+	message := fmt.Sprintf("Your subscription %s is renewed until %s with %d deliveries.",
+		renewed.ID, renewed.EndDate.Format("2006-01-02"), len(deliveries))
+	if _, err := service.notifyReceiver(ctx, receiverAt(renewed, history, newTermStart), "subscription is renewed.", message); err != nil {
+		log.Printf("scheduler: renewal mail for subscription %s fail to sent: %v", renewed.ID, err)
+	}
+	return nil
+}
+
+// renewTerm moves the end date of a subscription which still renews automatically. The
+// row is only changed while it is active, renews and ends on the date it was read with, so
+// a subscriber turning renewal off while the job runs is never renewed.
+func (service *SubscriptionService) renewTerm(ctx context.Context, sub data.Subscription, endDate time.Time) (data.Subscription, error) {
+	renewed, err := service.DBConnection.RenewSubscription(ctx, string(StatusActive), sub.EndDate, endDate, sub.ID)
+	if errors.Is(err, data.ErrUpdateFailed) {
+		return renewed, ErrStatusConflict
+	}
+	if err != nil {
+		return renewed, fmt.Errorf("error when extending the subscription: %w", err)
+	}
+	return renewed, service.audit(ctx, sub.ID, AuditSubscription, sub.ID, AuditTermChanged, sub, renewed)
+}
+
+// termDays is the number of calendar days of a term. The end date is the last day of the
+// term, so a term starting and ending on the same day lasts one day.
+func termDays(start, end time.Time) int {
+	startDay := time.Date(start.Year(), start.Month(), start.Day(), 0, 0, 0, 0, time.UTC)
+	endDay := time.Date(end.Year(), end.Month(), end.Day(), 0, 0, 0, 0, time.UTC)
+	return int(endDay.Sub(startDay).Hours()/24) + 1
+}
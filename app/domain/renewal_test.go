@@ -0,0 +1,150 @@
+package domain
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/ClaudiaYao/CapstoneSubscriptionService/app/data"
+)
+
+func TestTermDaysCountsTheEndDate(t *testing.T) {
+	tests := []struct {
+		start, end time.Time
+		want       int
+	}{
+		{day(2023, 3, 6), day(2023, 3, 6), 1},
+		{day(2023, 3, 1), day(2023, 3, 31), 31},
+		{day(2023, 2, 1), day(2023, 2, 28), 28},
+	}
+	for _, tt := range tests {
+		if got := termDays(tt.start, tt.end); got != tt.want {
+			t.Errorf("termDays(%v, %v) = %d, want %d", tt.start, tt.end, got, tt.want)
+		}
+	}
+}
+
+func TestRenewalKeepsTheTermLength(t *testing.T) {
+	ctx := context.Background()
+	repo := data.NewMemoryRepository()
+	sub := data.Subscription{
+		ID: "Sub1", UserID: "U1", Status: string(StatusActive), Frequency: "FREQ=DAILY", TimeZone: "UTC",
+		StartDate: day(2023, 3, 1), EndDate: day(2023, 3, 31), AutoRenew: true,
+	}
+	sub.TermDays = termDays(sub.StartDate, sub.EndDate)
+	if _, err := repo.InsertSubscription(ctx, sub); err != nil {
+		t.Fatal(err)
+	}
+	dish := data.SubscriptionDish{ID: "SD1", SubscriptionID: sub.ID, DishID: "D1", Frequency: "FREQ=DAILY", ScheduleTime: day(2023, 3, 1).Add(9 * time.Hour)}
+	if _, err := repo.InsertDishes(ctx, dish); err != nil {
+		t.Fatal(err)
+	}
+	service := &SubscriptionService{DBConnection: repo, AppConfig: &AppConfiguration{}}
+
+	if err := service.RenewSubscriptions(ctx, day(2023, 3, 31).Add(12*time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+
+	renewed, err := repo.GetSubscriptionByID(ctx, sub.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the second term runs from 1 April, as many days as March
+	if !renewed.EndDate.Equal(day(2023, 5, 1)) {
+		t.Errorf("got end date %v, want 2023-05-01", renewed.EndDate)
+	}
+	deliveries, err := repo.GetDishDeliveryCondition(ctx, dish.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deliveries) != 31 || !deliveries[0].ExpectedTime.Equal(day(2023, 4, 1).Add(9*time.Hour)) {
+		t.Errorf("got %d deliveries, want one a day from 1 April to 1 May", len(deliveries))
+	}
+}
+
+func TestExpireSubscriptions(t *testing.T) {
+	ctx := context.Background()
+	repo := data.NewMemoryRepository()
+	subscriptions := []data.Subscription{
+		{ID: "SubEnded", EndDate: day(2023, 3, 30)},
+		{ID: "SubLastDay", EndDate: day(2023, 3, 31)},
+		{ID: "SubRenewing", EndDate: day(2023, 3, 30), AutoRenew: true},
+		{ID: "SubPaused", EndDate: day(2023, 3, 30), AutoRenew: true, Status: string(StatusPaused)},
+	}
+	for _, sub := range subscriptions {
+		sub.UserID, sub.Frequency, sub.TimeZone, sub.StartDate = "U1", "FREQ=DAILY", "UTC", day(2023, 3, 1)
+		if sub.Status == "" {
+			sub.Status = string(StatusActive)
+		}
+		if _, err := repo.InsertSubscription(ctx, sub); err != nil {
+			t.Fatal(err)
+		}
+	}
+	dish := data.SubscriptionDish{ID: "SD1", SubscriptionID: "SubEnded", DishID: "D1", Frequency: "FREQ=DAILY", ScheduleTime: day(2023, 3, 1).Add(9 * time.Hour)}
+	if _, err := repo.InsertDishes(ctx, dish); err != nil {
+		t.Fatal(err)
+	}
+	undelivered := data.DishDelivery{ID: "DD1", SubscriptionDishID: dish.ID, Status: string(DeliveryScheduled), ExpectedTime: day(2023, 3, 30).Add(9 * time.Hour)}
+	if _, err := repo.InsertDishDelivery(ctx, undelivered); err != nil {
+		t.Fatal(err)
+	}
+	service := &SubscriptionService{DBConnection: repo, AppConfig: &AppConfiguration{}}
+
+	if err := service.ExpireSubscriptions(ctx, day(2023, 3, 31).Add(12*time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]SubscriptionStatus{
+		"SubEnded":    StatusExpired,
+		"SubLastDay":  StatusActive,
+		"SubRenewing": StatusActive,
+		"SubPaused":   StatusExpired,
+	}
+	for id, status := range want {
+		sub, err := repo.GetSubscriptionByID(ctx, id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if sub.Status != string(status) {
+			t.Errorf("%s: got %s, want %s", id, sub.Status, status)
+		}
+	}
+	delivery, err := repo.GetDishDeliveryByID(ctx, undelivered.ID)
+	if err != nil || delivery.Status != string(DeliveryMissed) {
+		t.Errorf("got %+v %v, want the undelivered delivery to be missed", delivery, err)
+	}
+}
+
+func TestRenewalSkipsSubscriptionsNoLongerRenewing(t *testing.T) {
+	ctx := context.Background()
+	repo := data.NewMemoryRepository()
+	sub := data.Subscription{
+		ID: "Sub1", UserID: "U1", Status: string(StatusActive), Frequency: "FREQ=DAILY", TimeZone: "UTC",
+		StartDate: day(2023, 3, 1), EndDate: day(2023, 3, 31), AutoRenew: true, TermDays: 31,
+	}
+	if _, err := repo.InsertSubscription(ctx, sub); err != nil {
+		t.Fatal(err)
+	}
+	service := &SubscriptionService{DBConnection: repo, AppConfig: &AppConfiguration{}}
+
+	// the subscriber turns renewal off after the job read the subscription
+	if _, err := service.SetAutoRenew(ctx, sub.ID, false); err != nil {
+		t.Fatal(err)
+	}
+	if err := service.renewSubscription(ctx, sub, day(2023, 3, 31).Add(12*time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	after, err := repo.GetSubscriptionByID(ctx, sub.ID)
+	if err != nil || !after.EndDate.Equal(sub.EndDate) {
+		t.Errorf("got end date %v %v, want the subscription not renewed", after.EndDate, err)
+	}
+}
+
+func TestReceiverAddress(t *testing.T) {
+	if got := receiverAddress(data.ReceiverDetails{ReceiverContact: "jo@example.org"}); got != "jo@example.org" {
+		t.Errorf("got %q, want the receiver's mail address", got)
+	}
+	if got := receiverAddress(data.ReceiverDetails{ReceiverContact: "80086872"}); got != subscriberAddress {
+		t.Errorf("got %q for a phone number, want the subscriber's address", got)
+	}
+}
@@ -0,0 +1,189 @@
+package domain
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ClaudiaYao/CapstoneSubscriptionService/app/data"
+)
+
+// RestaurantLookup tells which restaurant prepares a dish, and which dishes a restaurant
+// prepares. Dishes and restaurants are owned by the playlist service, this service only
+// keeps the dish ids.
+type RestaurantLookup interface {
+	RestaurantOfDish(ctx context.Context, dishID string) (string, error)
+	DishesOfRestaurant(ctx context.Context, restaurantID string) ([]string, error)
+}
+
+// DishCatalogue gives the name of a dish, to show it to customers.
+type DishCatalogue interface {
+	DishName(ctx context.Context, dishID string) (string, error)
+}
+
+// RestaurantHoursProvider gives the opening hours of a restaurant.
+type RestaurantHoursProvider interface {
+	RestaurantHours(ctx context.Context, restaurantID string) (WeeklyHours, error)
+}
+
+// OpeningHours is when a restaurant is open on one day, in minutes after midnight. Close is
+// past 24:00 when the restaurant stays open after midnight, and a closed day is zero.
+type OpeningHours struct {
+	Open  int `json:"open"`
+	Close int `json:"close"`
+}
+
+// WeeklyHours are the opening hours of a restaurant from Monday to Sunday, as the playlist
+// service keeps them.
+type WeeklyHours [7]OpeningHours
+
+// ParseWeeklyHours reads the hours as they are stored by the playlist service, one
+// ["0800","2100"] pair per day starting on Monday. A closing time before the opening time
+// is on the next day, an empty pair means the restaurant is closed that day.
+func ParseWeeklyHours(days [][]string) (WeeklyHours, error) {
+	var hours WeeklyHours
+	if len(days) != len(hours) {
+		return hours, fmt.Errorf("%w: %d days of opening hours", ErrInvalidOpeningHours, len(days))
+	}
+	for i, day := range days {
+		if len(day) == 0 || (len(day) == 2 && day[0] == "" && day[1] == "") {
+			continue
+		}
+		if len(day) != 2 {
+			return hours, fmt.Errorf("%w: %v", ErrInvalidOpeningHours, day)
+		}
+		open, err := hhmmMinutes(day[0])
+		if err != nil {
+			return hours, err
+		}
+		close, err := hhmmMinutes(day[1])
+		if err != nil {
+			return hours, err
+		}
+		if close <= open {
+			close += 24 * 60
+		}
+		hours[i] = OpeningHours{Open: open, Close: close}
+	}
+	return hours, nil
+}
+
+// hhmmMinutes reads a time written as 0830, 2400 is the end of the day.
+func hhmmMinutes(value string) (int, error) {
+	if len(value) != 4 {
+		return 0, fmt.Errorf("%w: %q", ErrInvalidOpeningHours, value)
+	}
+	if value == "2400" {
+		return 24 * 60, nil
+	}
+	t, err := time.Parse("1504", value)
+	if err != nil {
+		return 0, fmt.Errorf("%w: %q", ErrInvalidOpeningHours, value)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// RestaurantFile is a RestaurantLookup, DishCatalogue and RestaurantHoursProvider backed by the files of
+// the generated data. The dish file has one dish per line as id|name|restaurant id|..., the
+// restaurant file one restaurant per line with its opening hours in the eighth field.
+type RestaurantFile struct {
+	dishRestaurants map[string]string
+	dishNames       map[string]string
+	hours           map[string]WeeklyHours
+}
+
+// LoadRestaurantFile reads the dish file and, when restaurantPath is not empty, the
+// opening hours of the restaurant file.
+func LoadRestaurantFile(dishPath, restaurantPath string) (*RestaurantFile, error) {
+	file := &RestaurantFile{dishRestaurants: map[string]string{}, dishNames: map[string]string{}, hours: map[string]WeeklyHours{}}
+	err := readDataFile(dishPath, func(fields []string) error {
+		if len(fields) >= 3 {
+			file.dishNames[fields[0]] = fields[1]
+			file.dishRestaurants[fields[0]] = fields[2]
+		}
+		return nil
+	})
+	if err != nil || restaurantPath == "" {
+		return file, err
+	}
+
+	err = readDataFile(restaurantPath, func(fields []string) error {
+		if len(fields) < 8 {
+			return nil
+		}
+		var days [][]string
+		if err := json.Unmarshal([]byte(fields[7]), &days); err != nil {
+			return fmt.Errorf("%w: restaurant %s: %v", ErrInvalidOpeningHours, fields[0], err)
+		}
+		hours, err := ParseWeeklyHours(days)
+		if err != nil {
+			return fmt.Errorf("restaurant %s: %w", fields[0], err)
+		}
+		file.hours[fields[0]] = hours
+		return nil
+	})
+	return file, err
+}
+
+// readDataFile calls line with the | separated fields of every line of a generated file.
+func readDataFile(path string, line func(fields []string) error) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if err := line(strings.Split(scanner.Text(), "|")); err != nil {
+			return err
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("error when reading %s: %w", path, err)
+	}
+	return nil
+}
+
+func (f *RestaurantFile) RestaurantOfDish(ctx context.Context, dishID string) (string, error) {
+	restaurantID, ok := f.dishRestaurants[dishID]
+	if !ok {
+		return "", data.ErrNotExist
+	}
+	return restaurantID, nil
+}
+
+func (f *RestaurantFile) DishesOfRestaurant(ctx context.Context, restaurantID string) ([]string, error) {
+	dishIDs := []string{}
+	for dishID, id := range f.dishRestaurants {
+		if id == restaurantID {
+			dishIDs = append(dishIDs, dishID)
+		}
+	}
+	if len(dishIDs) == 0 {
+		return nil, data.ErrNotExist
+	}
+	sort.Strings(dishIDs)
+	return dishIDs, nil
+}
+
+func (f *RestaurantFile) RestaurantHours(ctx context.Context, restaurantID string) (WeeklyHours, error) {
+	hours, ok := f.hours[restaurantID]
+	if !ok {
+		return hours, data.ErrNotExist
+	}
+	return hours, nil
+}
+
+func (f *RestaurantFile) DishName(ctx context.Context, dishID string) (string, error) {
+	name, ok := f.dishNames[dishID]
+	if !ok {
+		return "", data.ErrNotExist
+	}
+	return name, nil
+}
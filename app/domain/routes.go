@@ -4,6 +4,7 @@ import (
 	"net/http"
 	"time"
 
+	"github.com/ClaudiaYao/CapstoneSubscriptionService/app/domain/auth"
 	"github.com/go-chi/chi"
 	"github.com/go-chi/chi/middleware"
 	"github.com/go-chi/cors"
@@ -23,9 +24,14 @@ func (service *SubscriptionService) Routes() http.Handler {
 	}))
 
 	mux.Use(middleware.Heartbeat("/ping"))
+	// C: the request id is kept with every change in the audit log
+	mux.Use(middleware.RequestID)
 
 	mux.Get("/", service.Welcome)
 
+	// the calendar feed is read by calendar clients, which only know the token in its URL
+	mux.With(withoutQueryInLog, middleware.Logger).Get("/calendar/{user_id}/deliveries.ics", service.DeliveryCalendarHandler)
+
 	mux.Route("/subscription", func(mux chi.Router) {
 
 		mux.Use(middleware.Timeout(60 * time.Second))
@@ -34,14 +40,50 @@ func (service *SubscriptionService) Routes() http.Handler {
 
 		mux.Post("/new", service.CreateSubscription)
 		mux.Put("/cancel/{subscription_id}", service.CancelSubscription)
+		mux.Put("/cancel/{subscription_id}/undo", service.UndoCancellationHandler)
+		mux.Put("/pause/{subscription_id}", service.PauseSubscriptionHandler)
+		mux.Put("/resume/{subscription_id}", service.ResumeSubscriptionHandler)
+		mux.Put("/reactivate/{subscription_id}", service.ReactivateSubscriptionHandler)
+		mux.Put("/renewal/{subscription_id}", service.SetAutoRenewHandler)
+		mux.Put("/receiver/{subscription_id}", service.ChangeReceiverHandler)
+		mux.Get("/receiver/{subscription_id}", service.GetReceiverHistoryHandler)
+		mux.Post("/blackout", service.CreateBlackoutHandler)
+		mux.Post("/blackout/import", service.ImportBlackoutsHandler)
+		mux.Get("/blackout", service.GetBlackoutsHandler)
+		mux.Delete("/blackout/{blackout_id}", service.DeleteBlackoutHandler)
+		mux.Post("/calendar/token", service.CreateCalendarTokenHandler)
+		mux.Get("/slot", service.GetDeliverySlotsHandler)
+		mux.With(service.RequireRole(auth.RoleAdmin)).Post("/slot", service.CreateDeliverySlotHandler)
 		mux.Get("/user/{user_id}", service.GetSubscriptionByUserID)
 		mux.Get("/{id}", service.GetSubscriptionByID)
+		mux.With(service.RequireRole(auth.RoleAdmin)).Get("/{id}/history", service.GetSubscriptionHistoryHandler)
 
 		mux.Get("/dish/{subscription_id}", service.GetDishBySubscriptionID)
+		mux.Post("/dish/{subscription_id}", service.AddSubscriptionDishHandler)
+		mux.Put("/dish/{subscription_id}/{subscription_dish_id}", service.UpdateSubscriptionDishHandler)
+		mux.Delete("/dish/{subscription_id}/{subscription_dish_id}", service.RemoveSubscriptionDishHandler)
 		mux.Get("/delivery/{dish_id}", service.GetDishDeliveryStatus)
+		mux.Get("/delivery/{delivery_id}/receiver", service.GetDeliveryReceiverHandler)
+		mux.Put("/delivery/{delivery_id}/skip", service.SkipDishDeliveryHandler)
+		mux.Put("/delivery/{delivery_id}/reschedule", service.RescheduleDishDeliveryHandler)
+		mux.With(service.RequireRole(auth.RoleCourier)).Put("/delivery/{delivery_id}/progress", service.ProgressDishDeliveryHandler)
+		mux.Get("/deliveries/user/{user_id}", service.GetUserDeliveriesHandler)
+		mux.Get("/deliveries/subscription/{subscription_id}", service.GetSubscriptionDeliveriesHandler)
+		mux.Get("/deliveries/restaurant/{restaurant_id}", service.GetRestaurantDeliveriesHandler)
 
 	})
 
 	// mux.Get("/playlists/sort?{}", app.Playlists)
 	return mux
 }
+
+// withoutQueryInLog leaves the query string out of the request URI, which the access log
+// prints. The query of the calendar feed holds its token. Handlers read the query from
+// r.URL, which is kept.
+func withoutQueryInLog(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		logged := *r
+		logged.RequestURI = r.URL.EscapedPath()
+		next.ServeHTTP(w, &logged)
+	})
+}
@@ -0,0 +1,190 @@
+package domain
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/ClaudiaYao/CapstoneSubscriptionService/app/data"
+)
+
+// systemActor is recorded as the actor of every change made by a background job
+const systemActor = "system"
+
+// scheduledJob is a piece of periodic work. Every job has its own advisory lock key,
+// so when several replicas run only one of them executes the job at a time.
+type scheduledJob struct {
+	name     string
+	lockKey  int64
+	interval time.Duration
+	run      func(ctx context.Context, now time.Time) error
+}
+
+func (service *SubscriptionService) scheduledJobs() []scheduledJob {
+	return []scheduledJob{
+		{
+			name:     "expire subscriptions",
+			lockKey:  840001,
+			interval: service.AppConfig.ExpiryJobInterval,
+			run:      service.ExpireSubscriptions,
+		},
+		{
+			name:     "extend delivery horizon",
+			lockKey:  840002,
+			interval: service.AppConfig.HorizonJobInterval,
+			run:      service.ExtendDeliveryHorizon,
+		},
+		{
+			name:     "renew subscriptions",
+			lockKey:  840003,
+			interval: service.AppConfig.RenewalJobInterval,
+			run:      service.RenewSubscriptions,
+		},
+		{
+			name:     "start and end pauses",
+			lockKey:  840004,
+			interval: service.AppConfig.PauseJobInterval,
+			run:      service.ApplyPauses,
+		},
+	}
+}
+
+// StartScheduler starts every background job in its own goroutine. The jobs stop when ctx is done.
+func (service *SubscriptionService) StartScheduler(ctx context.Context) {
+	for _, job := range service.scheduledJobs() {
+		if job.interval <= 0 {
+			log.Printf("scheduler: %s is disabled", job.name)
+			continue
+		}
+		go service.runPeriodically(ctx, job)
+	}
+}
+
+func (service *SubscriptionService) runPeriodically(ctx context.Context, job scheduledJob) {
+	ticker := time.NewTicker(job.interval)
+	defer ticker.Stop()
+
+	for {
+		service.runLocked(ctx, job)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// runLocked runs the job once, unless another replica is already running it.
+func (service *SubscriptionService) runLocked(ctx context.Context, job scheduledJob) {
+	release, ok, err := service.DBConnection.TryAdvisoryLock(ctx, job.lockKey)
+	if err != nil {
+		log.Printf("scheduler: could not lock %s: %v", job.name, err)
+		return
+	}
+	if !ok {
+		log.Printf("scheduler: %s is running on another replica", job.name)
+		return
+	}
+	defer func() {
+		if err := release(); err != nil {
+			log.Printf("scheduler: could not unlock %s: %v", job.name, err)
+		}
+	}()
+
+	if err := job.run(ctx, service.now()); err != nil {
+		log.Printf("scheduler: %s failed: %v", job.name, err)
+	}
+}
+
+// ExpireSubscriptions closes out every active or paused subscription whose end date has
+// passed, except active ones which renew automatically: the subscription becomes Expired,
+// its undelivered deliveries become Missed, and the subscriber is notified. A failing
+// subscription is logged and does not stop the others.
+func (service *SubscriptionService) ExpireSubscriptions(ctx context.Context, now time.Time) error {
+	// C: end_date is a date, so a subscription expires once its whole last day has passed
+	// C: in its own zone. No zone is a day ahead of UTC tomorrow, the rest is filtered below.
+	tomorrow := civilDate(now, time.UTC).AddDate(0, 0, 1)
+
+	for _, status := range []SubscriptionStatus{StatusActive, StatusPaused} {
+		subscriptions, err := service.DBConnection.GetSubscriptionsEndedBefore(ctx, string(status), tomorrow)
+		if err != nil {
+			return fmt.Errorf("error when querying ended subscriptions: %w", err)
+		}
+
+		for _, sub := range subscriptions {
+			if !sub.EndDate.Before(civilDate(now, subscriptionLocation(sub))) {
+				continue
+			}
+			if status == StatusActive && sub.AutoRenew {
+				// renewing subscriptions are handled by RenewSubscriptions
+				continue
+			}
+			if err := service.expireSubscription(ctx, sub); err != nil {
+				log.Printf("scheduler: could not expire subscription %s: %v", sub.ID, err)
+			}
+		}
+	}
+	return nil
+}
+
+func (service *SubscriptionService) expireSubscription(ctx context.Context, sub data.Subscription) error {
+	missed := 0
+	err := service.inTransaction(ctx, func(tx *SubscriptionService) (err error) {
+		sub, err = tx.ChangeSubscriptionStatus(ctx, sub.ID, StatusExpired, systemActor)
+		if err != nil {
+			return err
+		}
+
+		for _, status := range undeliveredStatuses {
+			deliveries, err := tx.changeDeliveryStatusInWindow(ctx, data.DeliveryWindowUpdate{
+				SubscriptionID: sub.ID,
+				FromStatus:     string(status),
+				ToStatus:       string(DeliveryMissed),
+			})
+			if err != nil {
+				return fmt.Errorf("error when marking the deliveries as missed: %w", err)
+			}
+			missed += len(deliveries)
+		}
+		return nil
+	})
+	if errors.Is(err, ErrStatusConflict) {
+		// the subscription was changed in the meantime, it is picked up again next run if needed
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	message := fmt.Sprintf("Your subscription %s ended on %s.", sub.ID, sub.EndDate.Format("2006-01-02"))
+	if missed > 0 {
+		message += fmt.Sprintf(" %d deliveries which did not go out were marked as missed.", missed)
+	}
+	if _, err := service.notifySubscriber(ctx, "subscription is expired.", message); err != nil {
+		log.Printf("scheduler: expired mail for subscription %s fail to sent: %v", sub.ID, err)
+	}
+	return nil
+}
+
+// ExtendDeliveryHorizon materializes the deliveries of every active open ended subscription
+// up to the rolling horizon, so those subscriptions never run out of scheduled deliveries.
+func (service *SubscriptionService) ExtendDeliveryHorizon(ctx context.Context, now time.Time) error {
+	subscriptions, err := service.DBConnection.GetOpenEndedSubscriptions(ctx, string(StatusActive))
+	if err != nil {
+		return fmt.Errorf("error when querying open ended subscriptions: %w", err)
+	}
+
+	for _, sub := range subscriptions {
+		err := service.inTransaction(ctx, func(tx *SubscriptionService) error {
+			_, err := tx.materializeHorizon(ctx, sub, now)
+			return err
+		})
+		if err != nil {
+			log.Printf("scheduler: could not extend subscription %s: %v", sub.ID, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,248 @@
+package domain
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ClaudiaYao/CapstoneSubscriptionService/app/data"
+	"github.com/lithammer/shortuuid"
+)
+
+// slotClockLayout is how the start and end of a delivery slot are written
+const slotClockLayout = "15:04"
+
+var (
+	ErrInvalidDeliverySlot = errors.New("invalid delivery slot")
+	ErrNoDeliverySlot      = errors.New("the schedule time is not within a delivery slot")
+	ErrPostalCodeRequired  = errors.New("a postal code is required to book delivery slots")
+)
+
+// SlotUnavailableError is returned when a dish cannot be delivered in the slot it asked
+// for. Alternatives are the slots which still have room on every delivery day of the dish.
+type SlotUnavailableError struct {
+	DishID       string              `json:"dishID"`
+	SlotID       string              `json:"slotID,omitempty"`
+	DeliveryDate time.Time           `json:"deliveryDate,omitempty"`
+	Alternatives []data.DeliverySlot `json:"alternatives"`
+	err          error
+}
+
+func (e *SlotUnavailableError) Error() string {
+	if e.DishID == "" {
+		return e.err.Error()
+	}
+	return fmt.Sprintf("dish %s: %v", e.DishID, e.err)
+}
+
+func (e *SlotUnavailableError) Unwrap() error {
+	return e.err
+}
+
+type DeliverySlotRequested struct {
+	StartTime string `json:"startTime"`
+	EndTime   string `json:"endTime"`
+	Capacity  int    `json:"capacity"`
+}
+
+// CreateDeliverySlot adds a slot to the catalogue. Times are local times such as "11:00".
+func (service *SubscriptionService) CreateDeliverySlot(ctx context.Context, request DeliverySlotRequested) (data.DeliverySlot, error) {
+	start, err := time.Parse(slotClockLayout, request.StartTime)
+	if err != nil {
+		return data.DeliverySlot{}, fmt.Errorf("%w: start time must be written as HH:MM", ErrInvalidDeliverySlot)
+	}
+	end, err := time.Parse(slotClockLayout, request.EndTime)
+	if err != nil {
+		return data.DeliverySlot{}, fmt.Errorf("%w: end time must be written as HH:MM", ErrInvalidDeliverySlot)
+	}
+	if !end.After(start) {
+		return data.DeliverySlot{}, fmt.Errorf("%w: the slot has to end after it starts", ErrInvalidDeliverySlot)
+	}
+	if request.Capacity < 1 {
+		return data.DeliverySlot{}, fmt.Errorf("%w: capacity must be a positive number", ErrInvalidDeliverySlot)
+	}
+
+	return service.DBConnection.InsertDeliverySlot(ctx, data.DeliverySlot{
+		ID:        "SL" + shortuuid.New(),
+		StartTime: start.Format(slotClockLayout),
+		EndTime:   end.Format(slotClockLayout),
+		Capacity:  request.Capacity,
+		Active:    true,
+	})
+}
+
+// postalZone is the zone capacity is counted in, the first digits of the postal code.
+// In Singapore the first two digits are the postal sector.
+func (service *SubscriptionService) postalZone(postalCode string) string {
+	code := strings.ToUpper(strings.ReplaceAll(postalCode, " ", ""))
+	if digits := service.AppConfig.PostalZoneDigits; digits > 0 && len(code) > digits {
+		return code[:digits]
+	}
+	return code
+}
+
+// slotContains reports whether the local time of day of t falls within the slot, the end
+// of the slot is not included.
+func slotContains(slot data.DeliverySlot, t time.Time) bool {
+	clock := t.Format(slotClockLayout)
+	return clock >= slot.StartTime && clock < slot.EndTime
+}
+
+// assignSlot returns the slot a dish is delivered in: the requested one, or the one whose
+// window holds the local schedule time.
+func assignSlot(slots []data.DeliverySlot, dishID, slotID string, scheduleTime time.Time) (data.DeliverySlot, error) {
+	for _, slot := range slots {
+		if slotID != "" && slot.ID != slotID {
+			continue
+		}
+		if slotContains(slot, scheduleTime) {
+			return slot, nil
+		}
+	}
+	return data.DeliverySlot{}, &SlotUnavailableError{DishID: dishID, SlotID: slotID, Alternatives: slots, err: ErrNoDeliverySlot}
+}
+
+// slotReservations counts the deliveries of every slot and day. They are sorted, so
+// concurrent sign-ups lock the reservation rows in the same order.
+func slotReservations(zone string, dishes []data.SubscriptionDish, planned map[string][]time.Time, loc *time.Location) []data.SlotReservation {
+	type key struct {
+		slotID string
+		date   time.Time
+	}
+	counts := map[key]int{}
+	for _, dish := range dishes {
+		if dish.SlotID == "" {
+			continue
+		}
+		for _, t := range planned[dish.ID] {
+			counts[key{dish.SlotID, civilDate(t, loc)}]++
+		}
+	}
+
+	reservations := []data.SlotReservation{}
+	for k, n := range counts {
+		reservations = append(reservations, data.SlotReservation{SlotID: k.slotID, PostalZone: zone, DeliveryDate: k.date, Reserved: n})
+	}
+	sort.Slice(reservations, func(i, j int) bool {
+		if reservations[i].SlotID != reservations[j].SlotID {
+			return reservations[i].SlotID < reservations[j].SlotID
+		}
+		return reservations[i].DeliveryDate.Before(reservations[j].DeliveryDate)
+	})
+	return reservations
+}
+
+// reserveSlots books the planned deliveries of the dishes in their slots, all of them or
+// none. When a slot is full the error offers the slots which could take the dish instead.
+func (service *SubscriptionService) reserveSlots(ctx context.Context, sub data.Subscription, dishes []data.SubscriptionDish, planned map[string][]time.Time) error {
+	loc := subscriptionLocation(sub)
+	zone := service.postalZone(sub.PostalCode)
+	reservations := slotReservations(zone, dishes, planned, loc)
+	if len(reservations) == 0 {
+		return nil
+	}
+
+	err := service.DBConnection.ReserveSlots(ctx, reservations)
+	var full *data.SlotFullError
+	if err != nil && !errors.As(err, &full) {
+		return fmt.Errorf("error when reserving the delivery slots: %w", err)
+	}
+	if err == nil {
+		return nil
+	}
+
+	unavailable := &SlotUnavailableError{SlotID: full.SlotID, DeliveryDate: full.DeliveryDate, err: err}
+	for _, dish := range dishes {
+		if dish.SlotID != full.SlotID {
+			continue
+		}
+		for _, t := range planned[dish.ID] {
+			if civilDate(t, loc).Equal(full.DeliveryDate) {
+				unavailable.DishID = dish.DishID
+				alternatives, err := service.alternativeSlots(ctx, zone, dish, planned[dish.ID], loc)
+				if err != nil {
+					return err
+				}
+				unavailable.Alternatives = alternatives
+				return unavailable
+			}
+		}
+	}
+	return unavailable
+}
+
+// alternativeSlots lists the other slots with room for every planned delivery of the dish.
+func (service *SubscriptionService) alternativeSlots(ctx context.Context, zone string, dish data.SubscriptionDish, planned []time.Time, loc *time.Location) ([]data.DeliverySlot, error) {
+	alternatives := []data.DeliverySlot{}
+	if len(planned) == 0 {
+		return alternatives, nil
+	}
+
+	slots, err := service.DBConnection.GetActiveDeliverySlots(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("error when querying the delivery slots: %w", err)
+	}
+	from, until := civilDate(planned[0], loc), civilDate(planned[len(planned)-1], loc)
+	reservations, err := service.DBConnection.GetSlotReservations(ctx, zone, from, until)
+	if err != nil {
+		return nil, fmt.Errorf("error when querying the slot reservations: %w", err)
+	}
+
+	reserved := map[string]map[time.Time]int{}
+	for _, reservation := range reservations {
+		if reserved[reservation.SlotID] == nil {
+			reserved[reservation.SlotID] = map[time.Time]int{}
+		}
+		reserved[reservation.SlotID][reservation.DeliveryDate] = reservation.Reserved
+	}
+
+	for _, slot := range slots {
+		if slot.ID == dish.SlotID {
+			continue
+		}
+		free := true
+		for _, t := range planned {
+			if reserved[slot.ID][civilDate(t, loc)] >= slot.Capacity {
+				free = false
+				break
+			}
+		}
+		if free {
+			alternatives = append(alternatives, slot)
+		}
+	}
+	return alternatives, nil
+}
+
+// holdsSlotPlace reports whether the delivery takes a place in the slot of its dish.
+// Cancelled deliveries have given theirs back.
+func holdsSlotPlace(delivery data.DishDelivery) bool {
+	return DeliveryStatus(delivery.Status) != DeliveryCancelled
+}
+
+// moveSlotPlaces keeps the slot reservations in step with a change of the subscription's
+// deliveries: the released deliveries give their place back and the booked ones take one.
+// Every insert, delete and move of deliveries goes through it in the transaction of the
+// change, so a slot which is full undoes the whole change.
+func (service *SubscriptionService) moveSlotPlaces(ctx context.Context, sub data.Subscription, dishes []data.SubscriptionDish, released, booked []data.DishDelivery) error {
+	zone := service.postalZone(sub.PostalCode)
+	releasing := slotReservations(zone, dishes, expectedTimes(released), subscriptionLocation(sub))
+	if len(releasing) > 0 {
+		if err := service.DBConnection.ReleaseSlots(ctx, releasing); err != nil {
+			return fmt.Errorf("error when releasing the delivery slots: %w", err)
+		}
+	}
+	return service.reserveSlots(ctx, sub, dishes, expectedTimes(booked))
+}
+
+// expectedTimes are the expected times of the deliveries by dish
+func expectedTimes(deliveries []data.DishDelivery) map[string][]time.Time {
+	times := map[string][]time.Time{}
+	for _, delivery := range deliveries {
+		times[delivery.SubscriptionDishID] = append(times[delivery.SubscriptionDishID], delivery.ExpectedTime)
+	}
+	return times
+}
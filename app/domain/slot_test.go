@@ -0,0 +1,132 @@
+package domain
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/ClaudiaYao/CapstoneSubscriptionService/app/data"
+)
+
+func TestAssignSlot(t *testing.T) {
+	loc, _ := loadLocation("Asia/Singapore")
+	slots := []data.DeliverySlot{
+		{ID: "SLlunch", StartTime: "11:00", EndTime: "12:00", Capacity: 10},
+		{ID: "SLdinner", StartTime: "18:00", EndTime: "19:30", Capacity: 10},
+	}
+
+	slot, err := assignSlot(slots, "Dish1", "", time.Date(2023, 1, 2, 18, 45, 0, 0, loc))
+	if err != nil || slot.ID != "SLdinner" {
+		t.Errorf("got %v %v, want the dinner slot", slot.ID, err)
+	}
+
+	// the end of a slot belongs to the next one
+	_, err = assignSlot(slots, "Dish1", "", time.Date(2023, 1, 2, 12, 0, 0, 0, loc))
+	var unavailable *SlotUnavailableError
+	if !errors.As(err, &unavailable) || !errors.Is(err, ErrNoDeliverySlot) || len(unavailable.Alternatives) != 2 {
+		t.Errorf("got %v, want ErrNoDeliverySlot offering both slots", err)
+	}
+
+	// a requested slot has to match the schedule time
+	if _, err := assignSlot(slots, "Dish1", "SLlunch", time.Date(2023, 1, 2, 18, 45, 0, 0, loc)); !errors.Is(err, ErrNoDeliverySlot) {
+		t.Errorf("got %v, want ErrNoDeliverySlot", err)
+	}
+}
+
+func TestSlotReservationsAreCountedPerSlotAndDay(t *testing.T) {
+	loc, _ := loadLocation("Asia/Singapore")
+	dishes := []data.SubscriptionDish{
+		{ID: "SDish1", SlotID: "SLb"},
+		{ID: "SDish2", SlotID: "SLb"},
+		{ID: "SDish3", SlotID: "SLa"},
+		{ID: "SDish4"},
+	}
+	day1 := time.Date(2023, 1, 2, 11, 30, 0, 0, loc)
+	day2 := day1.AddDate(0, 0, 1)
+	planned := map[string][]time.Time{
+		"SDish1": {day1, day2},
+		"SDish2": {day1},
+		"SDish3": {day2},
+		"SDish4": {day1},
+	}
+
+	got := slotReservations("52", dishes, planned, loc)
+	want := []data.SlotReservation{
+		{SlotID: "SLa", PostalZone: "52", DeliveryDate: civilDate(day2, loc), Reserved: 1},
+		{SlotID: "SLb", PostalZone: "52", DeliveryDate: civilDate(day1, loc), Reserved: 2},
+		{SlotID: "SLb", PostalZone: "52", DeliveryDate: civilDate(day2, loc), Reserved: 1},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("reservation %d: got %v, want %v", i, got[i], want[i])
+		}
+	}
+}
+
+func TestPostalZone(t *testing.T) {
+	service := &SubscriptionService{AppConfig: &AppConfiguration{PostalZoneDigits: 2}}
+	if zone := service.postalZone(" 520 123"); zone != "52" {
+		t.Errorf("got %q, want 52", zone)
+	}
+}
+
+func TestDeliveryChangesMoveSlotPlaces(t *testing.T) {
+	ctx := context.Background()
+	repo := data.NewMemoryRepository()
+	if _, err := repo.InsertDeliverySlot(ctx, data.DeliverySlot{ID: "SL1", StartTime: "09:00", EndTime: "10:00", Capacity: 1, Active: true}); err != nil {
+		t.Fatal(err)
+	}
+	// the horizon job books a place for every delivery it creates
+	service, sub, dish, deliveries := newTestSubscription(t, repo, "FREQ=WEEKLY", time.Time{}, 21)
+	start := sub.StartDate
+
+	reserved := func() map[time.Time]int {
+		reservations, err := repo.GetSlotReservations(ctx, "52", start, start.AddDate(0, 1, 0))
+		if err != nil {
+			t.Fatal(err)
+		}
+		places := map[time.Time]int{}
+		for _, reservation := range reservations {
+			if reservation.Reserved > 0 {
+				places[reservation.DeliveryDate] = reservation.Reserved
+			}
+		}
+		return places
+	}
+
+	if places := reserved(); dish.SlotID != "SL1" || len(places) != len(deliveries) {
+		t.Fatalf("got %v for %d deliveries, want one place a delivery", places, len(deliveries))
+	}
+	// a rescheduled delivery takes its place to the new day
+	first := deliveries[0]
+	moved := first.ExpectedTime.AddDate(0, 0, 2)
+	if _, err := service.RescheduleDishDelivery(ctx, first.ID, moved, ""); err != nil {
+		t.Fatal(err)
+	}
+	places := reserved()
+	if places[civilDate(first.ExpectedTime, time.UTC)] != 0 || places[civilDate(moved, time.UTC)] != 1 {
+		t.Errorf("got %v, want the place moved from %v to %v", places, first.ExpectedTime, moved)
+	}
+
+	// a dish which does not fit in the slot is not added at all
+	_, err := service.AddSubscriptionDish(ctx, sub.ID, SubscriptionDishRequested{DishID: "D2", ScheduleTime: dish.ScheduleTime, Frequency: "FREQ=WEEKLY"})
+	var unavailable *SlotUnavailableError
+	if !errors.As(err, &unavailable) || unavailable.SlotID != "SL1" {
+		t.Errorf("got %v, want the slot to be full", err)
+	}
+	if dishes, _ := repo.GetDishBySubscriptionID(ctx, sub.ID); len(dishes) != 1 {
+		t.Errorf("got %d dishes, want the full slot to undo the change", len(dishes))
+	}
+
+	// removing the dish gives every place back
+	if _, err := service.RemoveSubscriptionDish(ctx, sub.ID, dish.ID); err != nil {
+		t.Fatal(err)
+	}
+	if places := reserved(); len(places) != 0 {
+		t.Errorf("got %v, want no place left", places)
+	}
+}
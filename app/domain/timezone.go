@@ -0,0 +1,108 @@
+package domain
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/ClaudiaYao/CapstoneSubscriptionService/app/data"
+)
+
+var ErrInvalidTimeZone = errors.New("unknown time zone")
+
+// mailTimeLayout shows times in mails together with the abbreviation of their zone
+const mailTimeLayout = "2006-01-02 15:04 MST"
+
+var locations sync.Map
+
+// loadLocation is time.LoadLocation with the zones kept once they are loaded.
+func loadLocation(name string) (*time.Location, error) {
+	if loc, ok := locations.Load(name); ok {
+		return loc.(*time.Location), nil
+	}
+	// an empty name and "Local" would silently pick the zone of the server
+	if name == "" || name == "Local" {
+		return nil, fmt.Errorf("%w: %q", ErrInvalidTimeZone, name)
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %q", ErrInvalidTimeZone, name)
+	}
+	locations.Store(name, loc)
+	return loc, nil
+}
+
+// subscriptionLocation is the time zone the deliveries of the subscription are planned and
+// shown in. The zone is checked when the subscription is created, UTC is only a fallback.
+func subscriptionLocation(sub data.Subscription) *time.Location {
+	loc, err := loadLocation(sub.TimeZone)
+	if err != nil {
+		return time.UTC
+	}
+	return loc
+}
+
+// dishLocation is the time zone of the subscription the dish belongs to.
+func (service *SubscriptionService) dishLocation(ctx context.Context, subscriptionDishID string) (*time.Location, error) {
+	timeZone, err := service.DBConnection.GetDishTimeZone(ctx, subscriptionDishID)
+	if err != nil {
+		return nil, err
+	}
+	return subscriptionLocation(data.Subscription{TimeZone: timeZone}), nil
+}
+
+// civilDate returns the calendar day t falls on in loc, as midnight UTC. Start and end dates
+// are stored as dates, and this is how they come back from the database.
+func civilDate(t time.Time, loc *time.Location) time.Time {
+	year, month, day := t.In(loc).Date()
+	return time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+}
+
+// startOfDay is the instant the given civil date starts in loc.
+func startOfDay(date time.Time, loc *time.Location) time.Time {
+	year, month, day := date.Date()
+	return time.Date(year, month, day, 0, 0, 0, 0, loc)
+}
+
+// endOfDay is the last instant of the given civil date in loc.
+func endOfDay(date time.Time, loc *time.Location) time.Time {
+	return startOfDay(date, loc).AddDate(0, 0, 1).Add(-time.Nanosecond)
+}
+
+// localTime shows t in loc, leaving the zero time alone.
+func localTime(t time.Time, loc *time.Location) time.Time {
+	if t.IsZero() {
+		return t
+	}
+	return t.In(loc)
+}
+
+func localDelivery(delivery data.DishDelivery, loc *time.Location) data.DishDelivery {
+	delivery.ExpectedTime = localTime(delivery.ExpectedTime, loc)
+	delivery.DeliveryTime = localTime(delivery.DeliveryTime, loc)
+	return delivery
+}
+
+func localDeliveries(deliveries []data.DishDelivery, loc *time.Location) []data.DishDelivery {
+	local := make([]data.DishDelivery, 0, len(deliveries))
+	for _, delivery := range deliveries {
+		local = append(local, localDelivery(delivery, loc))
+	}
+	return local
+}
+
+func localDish(dish data.SubscriptionDish, loc *time.Location) data.SubscriptionDish {
+	dish.ScheduleTime = localTime(dish.ScheduleTime, loc)
+	dish.RemovedAt = localTime(dish.RemovedAt, loc)
+	return dish
+}
+
+func localDishes(dishes []data.SubscriptionDish, loc *time.Location) []data.SubscriptionDish {
+	local := make([]data.SubscriptionDish, 0, len(dishes))
+	for _, dish := range dishes {
+		local = append(local, localDish(dish, loc))
+	}
+	return local
+}
@@ -0,0 +1,48 @@
+package domain
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ClaudiaYao/CapstoneSubscriptionService/app/recurrence"
+)
+
+func TestRecurrenceKeepsLocalTimeAcrossDST(t *testing.T) {
+	loc, err := loadLocation("America/New_York")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// daylight saving time starts on 12 March 2023
+	dtstart := time.Date(2023, 3, 6, 9, 0, 0, 0, loc)
+	rule, _ := recurrence.Parse("FREQ=WEEKLY;BYDAY=MO;COUNT=3")
+
+	for _, occurrence := range rule.Between(dtstart, dtstart, dtstart.AddDate(0, 1, 0)) {
+		if hour, min, _ := occurrence.Clock(); hour != 9 || min != 0 {
+			t.Errorf("%v: expected 09:00 local time", occurrence)
+		}
+	}
+}
+
+func TestCivilDate(t *testing.T) {
+	loc, _ := loadLocation("Asia/Singapore")
+
+	// 1 April 00:30 in Singapore is still 31 March in UTC
+	instant := time.Date(2023, 4, 1, 0, 30, 0, 0, loc)
+	want := time.Date(2023, 4, 1, 0, 0, 0, 0, time.UTC)
+	if got := civilDate(instant, loc); !got.Equal(want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	if end := endOfDay(want, loc); !end.Before(time.Date(2023, 4, 2, 0, 0, 0, 0, loc)) || end.Before(instant) {
+		t.Errorf("end of day %v is not on 1 April in Singapore", end)
+	}
+}
+
+func TestLoadLocationRejectsUnknownZones(t *testing.T) {
+	for _, name := range []string{"", "Local", "Mars/Olympus_Mons"} {
+		if _, err := loadLocation(name); err == nil {
+			t.Errorf("%q: expected an error", name)
+		}
+	}
+}
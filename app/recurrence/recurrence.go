@@ -0,0 +1,403 @@
+// Package recurrence reads the RFC 5545 recurrence rules which set how often a dish is
+// delivered. It only depends on the standard library, so tools can use it as well.
+package recurrence
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Frequency is the FREQ part of a recurrence rule.
+type Frequency string
+
+const (
+	FreqDaily   Frequency = "DAILY"
+	FreqWeekly  Frequency = "WEEKLY"
+	FreqMonthly Frequency = "MONTHLY"
+)
+
+// ByDay is one entry of BYDAY, e.g. MO for every Monday or 1MO for the first Monday of
+// the month. A negative ordinal counts from the end of the month.
+type ByDay struct {
+	Ordinal int
+	Weekday time.Weekday
+}
+
+// Rule is the part of an RFC 5545 recurrence rule (RRULE) used to plan deliveries:
+// FREQ, INTERVAL, BYDAY, COUNT and UNTIL. The first occurrence is the schedule time of the
+// dish, which also gives the time of day of every delivery.
+type Rule struct {
+	Freq     Frequency
+	Interval int
+	ByDay    []ByDay
+	Count    int
+	Until    time.Time
+}
+
+// maxPeriods bounds how many days, weeks or months are looked at, so a rule
+// which never matches does not loop forever.
+const maxPeriods = 10000
+
+var ErrInvalidRule = errors.New("invalid recurrence rule")
+
+// RuleError is returned when a frequency cannot be parsed into a recurrence rule.
+type RuleError struct {
+	Rule   string
+	Reason string
+}
+
+func (e *RuleError) Error() string {
+	return fmt.Sprintf("invalid recurrence rule %q: %s", e.Rule, e.Reason)
+}
+
+func (e *RuleError) Is(target error) bool {
+	return target == ErrInvalidRule
+}
+
+// legacyFrequencies are the plain frequencies used before recurrence rules, still accepted
+// in requests.
+var legacyFrequencies = map[string]Frequency{
+	"daily":   FreqDaily,
+	"weekly":  FreqWeekly,
+	"monthly": FreqMonthly,
+}
+
+var weekdayCodes = map[string]time.Weekday{
+	"SU": time.Sunday,
+	"MO": time.Monday,
+	"TU": time.Tuesday,
+	"WE": time.Wednesday,
+	"TH": time.Thursday,
+	"FR": time.Friday,
+	"SA": time.Saturday,
+}
+
+const (
+	untilDateTimeUTC = "20060102T150405Z"
+	untilDateTime    = "20060102T150405"
+	untilDate        = "20060102"
+)
+
+// Parse parses a recurrence rule such as "FREQ=WEEKLY;BYDAY=MO,WE,FR" or
+// "RRULE:FREQ=MONTHLY;BYDAY=1MO". The old "daily", "weekly" and "monthly" are accepted too.
+func Parse(rule string) (Rule, error) {
+	text := strings.TrimSpace(rule)
+	if freq, ok := legacyFrequencies[strings.ToLower(text)]; ok {
+		return Rule{Freq: freq, Interval: 1}, nil
+	}
+
+	invalid := func(format string, args ...interface{}) (Rule, error) {
+		return Rule{}, &RuleError{Rule: rule, Reason: fmt.Sprintf(format, args...)}
+	}
+
+	text = strings.TrimPrefix(strings.ToUpper(text), "RRULE:")
+	r := Rule{Interval: 1}
+	seen := map[string]bool{}
+	for _, part := range strings.Split(text, ";") {
+		if part == "" {
+			continue
+		}
+		name, value, ok := strings.Cut(part, "=")
+		if !ok || value == "" {
+			return invalid("%s is not of the form NAME=VALUE", part)
+		}
+		if seen[name] {
+			return invalid("%s is given more than once", name)
+		}
+		seen[name] = true
+
+		switch name {
+		case "FREQ":
+			switch freq := Frequency(value); freq {
+			case FreqDaily, FreqWeekly, FreqMonthly:
+				r.Freq = freq
+			default:
+				return invalid("unsupported frequency %s", value)
+			}
+		case "INTERVAL":
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 1 {
+				return invalid("INTERVAL must be a positive number")
+			}
+			r.Interval = n
+		case "COUNT":
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 1 {
+				return invalid("COUNT must be a positive number")
+			}
+			r.Count = n
+		case "UNTIL":
+			until, err := parseUntil(value)
+			if err != nil {
+				return invalid("UNTIL must be a date or a date-time")
+			}
+			r.Until = until
+		case "BYDAY":
+			for _, code := range strings.Split(value, ",") {
+				byDay, err := parseByDay(code)
+				if err != nil {
+					return invalid("%s is not a valid BYDAY value", code)
+				}
+				r.ByDay = append(r.ByDay, byDay)
+			}
+		default:
+			return invalid("%s is not supported", name)
+		}
+	}
+
+	if r.Freq == "" {
+		return invalid("FREQ is required")
+	}
+	if r.Count > 0 && !r.Until.IsZero() {
+		return invalid("COUNT and UNTIL cannot be combined")
+	}
+	if r.Freq != FreqMonthly {
+		for _, byDay := range r.ByDay {
+			if byDay.Ordinal != 0 {
+				return invalid("numbered BYDAY values are only allowed with FREQ=MONTHLY")
+			}
+		}
+	}
+	return r, nil
+}
+
+// NormalizeFrequency checks a requested frequency and returns it as the recurrence rule
+// that is stored for it.
+func NormalizeFrequency(frequency string) (string, error) {
+	r, err := Parse(frequency)
+	if err != nil {
+		return "", err
+	}
+	return r.String(), nil
+}
+
+func parseUntil(value string) (time.Time, error) {
+	if t, err := time.Parse(untilDateTimeUTC, value); err == nil {
+		return t, nil
+	}
+	if t, err := time.Parse(untilDateTime, value); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse(untilDate, value)
+	if err != nil {
+		return time.Time{}, err
+	}
+	// a date only UNTIL still includes the deliveries on that day
+	return t.AddDate(0, 0, 1).Add(-time.Second), nil
+}
+
+func parseByDay(code string) (ByDay, error) {
+	if len(code) < 2 {
+		return ByDay{}, ErrInvalidRule
+	}
+	weekday, ok := weekdayCodes[code[len(code)-2:]]
+	if !ok {
+		return ByDay{}, ErrInvalidRule
+	}
+	byDay := ByDay{Weekday: weekday}
+	if prefix := code[:len(code)-2]; prefix != "" {
+		n, err := strconv.Atoi(prefix)
+		if err != nil || n == 0 || n < -5 || n > 5 {
+			return ByDay{}, ErrInvalidRule
+		}
+		byDay.Ordinal = n
+	}
+	return byDay, nil
+}
+
+// String returns the rule in RRULE form, without the "RRULE:" prefix.
+func (r Rule) String() string {
+	parts := []string{"FREQ=" + string(r.Freq)}
+	if r.Interval > 1 {
+		parts = append(parts, "INTERVAL="+strconv.Itoa(r.Interval))
+	}
+	if len(r.ByDay) > 0 {
+		codes := []string{}
+		for _, byDay := range r.ByDay {
+			code := strings.ToUpper(byDay.Weekday.String()[:2])
+			if byDay.Ordinal != 0 {
+				code = strconv.Itoa(byDay.Ordinal) + code
+			}
+			codes = append(codes, code)
+		}
+		parts = append(parts, "BYDAY="+strings.Join(codes, ","))
+	}
+	if r.Count > 0 {
+		parts = append(parts, "COUNT="+strconv.Itoa(r.Count))
+	}
+	if !r.Until.IsZero() {
+		parts = append(parts, "UNTIL="+r.Until.UTC().Format(untilDateTimeUTC))
+	}
+	return strings.Join(parts, ";")
+}
+
+// Occurrences calls yield with every occurrence of the rule starting at dtstart, in order,
+// until yield returns false or the rule ends.
+func (r Rule) Occurrences(dtstart time.Time, yield func(time.Time) bool) {
+	interval := r.Interval
+	if interval < 1 {
+		interval = 1
+	}
+
+	count := 0
+	for period := 0; period < maxPeriods; period++ {
+		for _, t := range r.candidates(dtstart, period*interval) {
+			if t.Before(dtstart) {
+				continue
+			}
+			if !r.Until.IsZero() && t.After(r.Until) {
+				return
+			}
+			count++
+			if !yield(t) {
+				return
+			}
+			if r.Count > 0 && count >= r.Count {
+				return
+			}
+		}
+	}
+}
+
+// Between returns the occurrences from and until, both included.
+func (r Rule) Between(dtstart, from, until time.Time) []time.Time {
+	times := []time.Time{}
+	r.Occurrences(dtstart, func(t time.Time) bool {
+		if t.After(until) {
+			return false
+		}
+		if !t.Before(from) {
+			times = append(times, t)
+		}
+		return true
+	})
+	return times
+}
+
+// After returns the first occurrence after t.
+func (r Rule) After(dtstart, t time.Time) (time.Time, bool) {
+	var next time.Time
+	found := false
+	r.Occurrences(dtstart, func(occurrence time.Time) bool {
+		if occurrence.After(t) {
+			next, found = occurrence, true
+			return false
+		}
+		return true
+	})
+	return next, found
+}
+
+// Before returns the last occurrence before t.
+func (r Rule) Before(dtstart, t time.Time) (time.Time, bool) {
+	var previous time.Time
+	found := false
+	r.Occurrences(dtstart, func(occurrence time.Time) bool {
+		if !occurrence.Before(t) {
+			return false
+		}
+		previous, found = occurrence, true
+		return true
+	})
+	return previous, found
+}
+
+// candidates returns the times of the n-th day, week or month after the one of dtstart
+// which match the rule, in order. Times keep the wall clock of dtstart in its location.
+func (r Rule) candidates(dtstart time.Time, n int) []time.Time {
+	year, month, day := dtstart.Date()
+	hour, min, sec := dtstart.Clock()
+	loc := dtstart.Location()
+	at := func(year int, month time.Month, day int) time.Time {
+		return time.Date(year, month, day, hour, min, sec, dtstart.Nanosecond(), loc)
+	}
+
+	switch r.Freq {
+	case FreqWeekly:
+		// weeks start on Monday
+		monday := day - (int(dtstart.Weekday())+6)%7 + 7*n
+		weekdays := []time.Weekday{dtstart.Weekday()}
+		if len(r.ByDay) > 0 {
+			weekdays = weekdays[:0]
+			for _, byDay := range r.ByDay {
+				weekdays = append(weekdays, byDay.Weekday)
+			}
+		}
+		offsets := []int{}
+		for _, weekday := range weekdays {
+			offsets = append(offsets, (int(weekday)+6)%7)
+		}
+		sort.Ints(offsets)
+
+		times := []time.Time{}
+		for i, offset := range offsets {
+			if i > 0 && offset == offsets[i-1] {
+				continue
+			}
+			times = append(times, at(year, month, monday+offset))
+		}
+		return times
+
+	case FreqMonthly:
+		first := time.Date(year, month+time.Month(n), 1, 0, 0, 0, 0, loc)
+		daysInMonth := time.Date(first.Year(), first.Month()+1, 0, 0, 0, 0, 0, loc).Day()
+		if len(r.ByDay) == 0 {
+			// months without the day of dtstart are left out, as RFC 5545 does
+			if day > daysInMonth {
+				return nil
+			}
+			return []time.Time{at(first.Year(), first.Month(), day)}
+		}
+
+		days := map[int]bool{}
+		for _, byDay := range r.ByDay {
+			matching := []int{}
+			for d := 1; d <= daysInMonth; d++ {
+				if first.AddDate(0, 0, d-1).Weekday() == byDay.Weekday {
+					matching = append(matching, d)
+				}
+			}
+			switch {
+			case byDay.Ordinal > 0 && byDay.Ordinal <= len(matching):
+				days[matching[byDay.Ordinal-1]] = true
+			case byDay.Ordinal < 0 && -byDay.Ordinal <= len(matching):
+				days[matching[len(matching)+byDay.Ordinal]] = true
+			case byDay.Ordinal == 0:
+				for _, d := range matching {
+					days[d] = true
+				}
+			}
+		}
+		ordered := []int{}
+		for d := range days {
+			ordered = append(ordered, d)
+		}
+		sort.Ints(ordered)
+
+		times := []time.Time{}
+		for _, d := range ordered {
+			times = append(times, at(first.Year(), first.Month(), d))
+		}
+		return times
+
+	default:
+		t := at(year, month, day+n)
+		if len(r.ByDay) > 0 && !r.onWeekday(t.Weekday()) {
+			return nil
+		}
+		return []time.Time{t}
+	}
+}
+
+func (r Rule) onWeekday(weekday time.Weekday) bool {
+	for _, byDay := range r.ByDay {
+		if byDay.Weekday == weekday {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,105 @@
+package recurrence
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		rule string
+		want string
+	}{
+		{"daily", "FREQ=DAILY"},
+		{"Weekly", "FREQ=WEEKLY"},
+		{"RRULE:FREQ=WEEKLY;BYDAY=MO,WE,FR", "FREQ=WEEKLY;BYDAY=MO,WE,FR"},
+		{"FREQ=WEEKLY;INTERVAL=2", "FREQ=WEEKLY;INTERVAL=2"},
+		{"freq=monthly;byday=1mo", "FREQ=MONTHLY;BYDAY=1MO"},
+		{"FREQ=DAILY;COUNT=10", "FREQ=DAILY;COUNT=10"},
+		{"FREQ=DAILY;UNTIL=20230430", "FREQ=DAILY;UNTIL=20230430T235959Z"},
+	}
+
+	for _, tt := range tests {
+		r, err := Parse(tt.rule)
+		if err != nil {
+			t.Errorf("%s: unexpected error %v", tt.rule, err)
+			continue
+		}
+		if got := r.String(); got != tt.want {
+			t.Errorf("%s: got %s, want %s", tt.rule, got, tt.want)
+		}
+	}
+}
+
+func TestParseRejectsUnknownRules(t *testing.T) {
+	rules := []string{
+		"",
+		"fortnightly",
+		"FREQ=HOURLY",
+		"FREQ=WEEKLY;BYDAY=XX",
+		"FREQ=WEEKLY;BYDAY=1MO",
+		"FREQ=DAILY;INTERVAL=0",
+		"FREQ=DAILY;COUNT=3;UNTIL=20230430",
+		"FREQ=DAILY;BYSETPOS=1",
+	}
+
+	for _, rule := range rules {
+		if _, err := Parse(rule); !errors.Is(err, ErrInvalidRule) {
+			t.Errorf("%q: expected ErrInvalidRule, got %v", rule, err)
+		}
+	}
+}
+
+func TestRuleBetween(t *testing.T) {
+	// Saturday 1 April 2023
+	dtstart := time.Date(2023, 4, 1, 12, 0, 0, 0, time.UTC)
+	until := time.Date(2023, 5, 31, 23, 59, 0, 0, time.UTC)
+	day := func(month time.Month, day int) time.Time {
+		return time.Date(2023, month, day, 12, 0, 0, 0, time.UTC)
+	}
+
+	tests := []struct {
+		rule string
+		want []time.Time
+	}{
+		{"FREQ=WEEKLY;BYDAY=MO,WE,FR;COUNT=4", []time.Time{day(4, 3), day(4, 5), day(4, 7), day(4, 10)}},
+		{"FREQ=WEEKLY;INTERVAL=2;COUNT=3", []time.Time{day(4, 1), day(4, 15), day(4, 29)}},
+		{"FREQ=MONTHLY;BYDAY=1MO", []time.Time{day(4, 3), day(5, 1)}},
+		{"FREQ=MONTHLY;BYDAY=-1FR", []time.Time{day(4, 28), day(5, 26)}},
+		{"FREQ=DAILY;UNTIL=20230403", []time.Time{day(4, 1), day(4, 2), day(4, 3)}},
+		{"FREQ=DAILY;INTERVAL=3;BYDAY=SA", []time.Time{day(4, 1), day(4, 22), day(5, 13)}},
+	}
+
+	for _, tt := range tests {
+		r, err := Parse(tt.rule)
+		if err != nil {
+			t.Fatalf("%s: unexpected error %v", tt.rule, err)
+		}
+		got := r.Between(dtstart, dtstart, until)
+		if len(got) != len(tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.rule, got, tt.want)
+			continue
+		}
+		for i := range got {
+			if !got[i].Equal(tt.want[i]) {
+				t.Errorf("%s: occurrence %d is %v, want %v", tt.rule, i, got[i], tt.want[i])
+			}
+		}
+	}
+}
+
+func TestRuleMonthlySkipsShortMonths(t *testing.T) {
+	r, _ := Parse("monthly")
+	dtstart := time.Date(2023, 1, 31, 9, 0, 0, 0, time.UTC)
+
+	next, ok := r.After(dtstart, dtstart)
+	if !ok || !next.Equal(time.Date(2023, 3, 31, 9, 0, 0, 0, time.UTC)) {
+		t.Errorf("expected 31 March, got %v", next)
+	}
+
+	previous, ok := r.Before(dtstart, next)
+	if !ok || !previous.Equal(dtstart) {
+		t.Errorf("expected 31 January, got %v", previous)
+	}
+}
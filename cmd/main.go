@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"database/sql"
 	"log"
 	"net/http"
@@ -8,10 +9,13 @@ import (
 	"strconv"
 
 	"time"
+	// the zone database is embedded, the service image does not ship one
+	_ "time/tzdata"
 
 	"github.com/ClaudiaYao/CapstoneSubscriptionService/app/data"
 	domain "github.com/ClaudiaYao/CapstoneSubscriptionService/app/domain"
 	"github.com/ClaudiaYao/CapstoneSubscriptionService/app/domain/auth"
+	"github.com/ClaudiaYao/CapstoneSubscriptionService/resources/database"
 	_ "github.com/jackc/pgconn"
 	_ "github.com/jackc/pgx/v4"
 	_ "github.com/jackc/pgx/v4/stdlib"
@@ -23,13 +27,31 @@ var counts int64
 func main() {
 	log.Println("Starting subscription service")
 
-	// connect to DB
-	conn := connectToDB()
-	if conn == nil {
-		log.Panic("Can't connect to Postgres!")
+	appCon := getConfig()
+
+	// C: the sqlite and memory backends are for local runs without Postgres, memory
+	// C: records are lost when the service stops
+	var conn data.SubscriptionRepository
+	switch appCon.DataBackend {
+	case "postgres":
+		db := connectToDB()
+		if db == nil {
+			log.Panic("Can't connect to Postgres!")
+		}
+		conn = db
+	case "sqlite":
+		db, err := openSQLite(appCon.SQLitePath)
+		if err != nil {
+			log.Fatal(err)
+		}
+		conn = db
+	case "memory":
+		log.Println("Keeping the records in memory, they are lost when the service stops")
+		conn = data.NewMemoryRepository()
+	default:
+		log.Fatalf("unknown data backend %q", appCon.DataBackend)
 	}
 
-	appCon := getConfig()
 	tokenPath := "app/domain/auth/tokenData"
 	jwtMaker, err := auth.NewJWTMaker(tokenPath)
 	if err != nil {
@@ -49,6 +71,36 @@ func main() {
 		JwtVerifier:  jwtVerifier,
 	}
 
+	// C: the restaurant of each dish decides which restaurant blackouts and opening hours
+	// C: apply to it, they come from the playlist service or from the generated data files
+	switch appCon.RestaurantSource {
+	case "playlist":
+		playlist := domain.NewPlaylistClient("http://" + appCon.PlaylistServiceContainerName + os.Getenv("PLAYLIST_SERVICE_PORT"))
+		subService.Restaurants = playlist
+		subService.RestaurantHours = playlist
+		subService.Dishes = playlist
+	case "file":
+		if appCon.DishDataFile != "" {
+			restaurants, err := domain.LoadRestaurantFile(appCon.DishDataFile, appCon.RestaurantDataFile)
+			if err != nil {
+				log.Fatal(err)
+			}
+			subService.Restaurants = restaurants
+			subService.Dishes = restaurants
+			if appCon.RestaurantDataFile != "" {
+				subService.RestaurantHours = restaurants
+			}
+		}
+	default:
+		log.Fatalf("unknown restaurant source %q", appCon.RestaurantSource)
+	}
+
+	if appCon.BlackoutCalendarFile != "" {
+		importBlackoutCalendar(subService, appCon.BlackoutCalendarFile)
+	}
+
+	subService.StartScheduler(context.Background())
+
 	srv := &http.Server{
 		Addr:    appCon.ServicePort,
 		Handler: subService.Routes(),
@@ -73,6 +125,69 @@ func getConfig() *domain.AppConfiguration {
 		log.Fatal(err)
 	}
 
+	expiryInterval, err := durationEnv("EXPIRY_JOB_INTERVAL", time.Hour)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	horizonInterval, err := durationEnv("HORIZON_JOB_INTERVAL", 24*time.Hour)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	renewalInterval, err := durationEnv("RENEWAL_JOB_INTERVAL", time.Hour)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	pauseInterval, err := durationEnv("PAUSE_JOB_INTERVAL", 15*time.Minute)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	horizonDays, err := intEnv("DELIVERY_HORIZON_DAYS", 28)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	cancellationCutoff, err := durationEnv("CANCELLATION_CUTOFF", 24*time.Hour)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	cancellationUndoWindow, err := durationEnv("CANCELLATION_UNDO_WINDOW", 30*time.Minute)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	restaurantSource := os.Getenv("RESTAURANT_SOURCE")
+	if restaurantSource == "" {
+		restaurantSource = "file"
+	}
+
+	dataBackend := os.Getenv("DATA_BACKEND")
+	if dataBackend == "" {
+		dataBackend = "postgres"
+	}
+	sqlitePath := os.Getenv("SQLITE_PATH")
+	if sqlitePath == "" {
+		sqlitePath = "subscription.db"
+	}
+
+	postalZoneDigits, err := intEnv("POSTAL_ZONE_DIGITS", 2)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// C: subscriptions created without a time zone are planned in this one
+	defaultTimeZone := os.Getenv("DEFAULT_TIME_ZONE")
+	if defaultTimeZone == "" {
+		defaultTimeZone = "Asia/Singapore"
+	}
+	if _, err := time.LoadLocation(defaultTimeZone); err != nil {
+		log.Fatal(err)
+	}
+
 	return &domain.AppConfiguration{
 		TokenExpireSecs:                  expireSec,
 		ServicePort:                      os.Getenv("SERVICE_PORT"),
@@ -80,9 +195,60 @@ func getConfig() *domain.AppConfiguration {
 		LoginServiceContainerName:        os.Getenv("LOGIN_SERVICE"),
 		PlaylistServiceContainerName:     os.Getenv("PLATLIST_SERVICE"),
 		SubscriptionServiceContainerName: os.Getenv("SUBSCRIPTION_SERVICE"),
+		ExpiryJobInterval:                expiryInterval,
+		HorizonJobInterval:               horizonInterval,
+		RenewalJobInterval:               renewalInterval,
+		PauseJobInterval:                 pauseInterval,
+		DeliveryHorizonDays:              horizonDays,
+		CancellationCutoff:               cancellationCutoff,
+		CancellationUndoWindow:           cancellationUndoWindow,
+		DefaultTimeZone:                  defaultTimeZone,
+		DishDataFile:                     os.Getenv("DISH_DATA_FILE"),
+		BlackoutCalendarFile:             os.Getenv("BLACKOUT_ICS_FILE"),
+		PostalZoneDigits:                 postalZoneDigits,
+		RestaurantSource:                 restaurantSource,
+		RestaurantDataFile:               os.Getenv("RESTAURANT_DATA_FILE"),
+		DataBackend:                      dataBackend,
+		SQLitePath:                       sqlitePath,
 	}
 }
 
+// importBlackoutCalendar loads the public holidays or other service wide closures of an
+// iCalendar file as global blackouts. Importing the same file again updates them.
+func importBlackoutCalendar(subService *domain.SubscriptionService, path string) {
+	f, err := os.Open(path)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer f.Close()
+
+	blackouts, err := subService.ImportBlackouts(context.Background(), f, domain.BlackoutGlobal, "", domain.BlackoutSkip, "system", auth.RoleAdmin)
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("Imported %d blackouts from %s", len(blackouts), path)
+}
+
+// intEnv reads an integer from the environment, falling back to the default
+// when the variable is not set
+func intEnv(key string, fallback int) (int, error) {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback, nil
+	}
+	return strconv.Atoi(value)
+}
+
+// durationEnv reads a duration such as "1h" or "30m" from the environment,
+// falling back to the default when the variable is not set
+func durationEnv(key string, fallback time.Duration) (time.Duration, error) {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback, nil
+	}
+	return time.ParseDuration(value)
+}
+
 // C: this function will connect to database and then return *DataQuery
 func openDB(dsn string) (*data.DataQuery, error) {
 
@@ -96,9 +262,20 @@ func openDB(dsn string) (*data.DataQuery, error) {
 		return nil, err
 	}
 
-	dataquery := data.DataQuery{DBConn: db}
+	return data.New(db), nil
+}
 
-	return &dataquery, nil
+// C: opens the SQLite database and brings its schema up to date, the goose binary is not needed
+func openSQLite(path string) (*data.DataQuery, error) {
+	db, err := data.OpenSQLite(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := data.MigrateSQLite(context.Background(), db, database.SQLiteMigrations()); err != nil {
+		return nil, err
+	}
+	log.Println("Using SQLite database", path)
+	return data.NewSQLite(db), nil
 }
 
 // C: wrap the openDB function and provide retry mechanism
@@ -1,5 +1,5 @@
 
 \c subscription;
-\COPY subscription FROM myData/subscription.txt WITH (FORMAT text, DELIMITER '|');
-\COPY subscription_dish FROM myData/subscriptionDishes.txt WITH (FORMAT text, DELIMITER '|');
+\COPY subscription (id, user_id, playlist_id, customized, status, frequency, start_date, end_date, receiver_name, receiver_contact) FROM myData/subscription.txt WITH (FORMAT text, DELIMITER '|');
+\COPY subscription_dish (id, dish_id, subscription_id, schedule_time, frequency, dish_options, note) FROM myData/subscriptionDishes.txt WITH (FORMAT text, DELIMITER '|');
 \COPY dish_delivery FROM myData/dishesDelivery.txt WITH (FORMAT text, DELIMITER '|');
@@ -10,6 +10,7 @@ require (
 	github.com/jackc/pgx/v4 v4.17.2
 	github.com/joho/godotenv v1.4.0
 	github.com/lithammer/shortuuid v3.0.0+incompatible
+	github.com/mattn/go-sqlite3 v1.14.16
 	github.com/sirupsen/logrus v1.4.2
 )
 
@@ -25,7 +26,6 @@ require (
 	github.com/jackc/pgtype v1.12.0 // indirect
 	github.com/konsorten/go-windows-terminal-sequences v1.0.2 // indirect
 	github.com/lib/pq v1.10.7 // indirect
-	github.com/mattn/go-sqlite3 v1.14.16 // indirect
 	github.com/ziutek/mymysql v1.5.4 // indirect
 	golang.org/x/crypto v0.0.0-20220722155217-630584e8d5aa // indirect
 	golang.org/x/sys v0.0.0-20210615035016-665e8c7367d1 // indirect
@@ -0,0 +1,19 @@
+-- +goose Up
+
+CREATE TABLE "subscription_status_transition" (
+  "id" varchar PRIMARY KEY,
+  "subscription_id" varchar NOT NULL,
+  "from_status" varchar(20) NOT NULL,
+  "to_status" varchar(20) NOT NULL,
+  "triggered_by" varchar NOT NULL,
+  "triggered_at" timestamp NOT NULL
+);
+
+CREATE INDEX ON "subscription_status_transition" ("subscription_id");
+
+ALTER TABLE "subscription_status_transition" ADD FOREIGN KEY ("subscription_id") REFERENCES "subscription" ("id");
+
+-- +goose Down
+-- SQL in this section is executed when the migration is rolled back.
+
+DROP TABLE IF EXISTS subscription_status_transition;
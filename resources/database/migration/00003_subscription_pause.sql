@@ -0,0 +1,19 @@
+-- +goose Up
+
+CREATE TABLE "subscription_pause" (
+  "id" varchar PRIMARY KEY,
+  "subscription_id" varchar NOT NULL,
+  "pause_from" timestamp NOT NULL,
+  "pause_until" timestamp,
+  "resumed_at" timestamp,
+  "resume_policy" varchar(20)
+);
+
+CREATE INDEX ON "subscription_pause" ("subscription_id");
+
+ALTER TABLE "subscription_pause" ADD FOREIGN KEY ("subscription_id") REFERENCES "subscription" ("id");
+
+-- +goose Down
+-- SQL in this section is executed when the migration is rolled back.
+
+DROP TABLE IF EXISTS subscription_pause;
@@ -0,0 +1,13 @@
+-- +goose Up
+-- dish deliveries which are waiting to go out used to be stored as Active or Pending,
+-- and delivered ones as Completed
+
+UPDATE "dish_delivery" SET "status" = 'Scheduled' WHERE "status" IN ('Active', 'Pending');
+UPDATE "dish_delivery" SET "status" = 'Delivered' WHERE "status" = 'Completed';
+
+-- +goose Down
+-- SQL in this section is executed when the migration is rolled back.
+-- Active and Pending both become Scheduled, so the rollback restores all of them as Active.
+
+UPDATE "dish_delivery" SET "status" = 'Active' WHERE "status" = 'Scheduled';
+UPDATE "dish_delivery" SET "status" = 'Completed' WHERE "status" = 'Delivered';
@@ -0,0 +1,12 @@
+-- +goose Up
+
+ALTER TABLE "subscription" ADD COLUMN "auto_renew" bool NOT NULL DEFAULT false;
+
+-- length of one term in days, used to compute the next end date when renewing
+ALTER TABLE "subscription" ADD COLUMN "term_days" int;
+
+-- +goose Down
+-- SQL in this section is executed when the migration is rolled back.
+
+ALTER TABLE "subscription" DROP COLUMN IF EXISTS "term_days";
+ALTER TABLE "subscription" DROP COLUMN IF EXISTS "auto_renew";
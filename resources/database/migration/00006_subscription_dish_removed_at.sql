@@ -0,0 +1,9 @@
+-- +goose Up
+-- dishes removed from a live subscription are kept because their past deliveries refer to them
+
+ALTER TABLE "subscription_dish" ADD COLUMN "removed_at" timestamp;
+
+-- +goose Down
+-- SQL in this section is executed when the migration is rolled back.
+
+ALTER TABLE "subscription_dish" DROP COLUMN IF EXISTS "removed_at";
@@ -0,0 +1,25 @@
+-- +goose Up
+
+ALTER TABLE "subscription" ADD COLUMN "delivery_instructions" varchar;
+
+-- receiver details which were replaced, they still apply to deliveries expected before valid_until
+CREATE TABLE "subscription_receiver_history" (
+  "id" varchar PRIMARY KEY,
+  "subscription_id" varchar NOT NULL,
+  "receiver_name" varchar NOT NULL,
+  "receiver_contact" varchar NOT NULL,
+  "delivery_instructions" varchar,
+  "valid_until" timestamp NOT NULL,
+  "changed_by" varchar NOT NULL,
+  "changed_at" timestamp NOT NULL
+);
+
+CREATE INDEX ON "subscription_receiver_history" ("subscription_id");
+
+ALTER TABLE "subscription_receiver_history" ADD FOREIGN KEY ("subscription_id") REFERENCES "subscription" ("id");
+
+-- +goose Down
+-- SQL in this section is executed when the migration is rolled back.
+
+DROP TABLE IF EXISTS subscription_receiver_history;
+ALTER TABLE "subscription" DROP COLUMN IF EXISTS "delivery_instructions";
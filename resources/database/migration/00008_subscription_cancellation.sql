@@ -0,0 +1,35 @@
+-- +goose Up
+
+CREATE TABLE "subscription_cancellation" (
+  "id" varchar PRIMARY KEY,
+  "subscription_id" varchar NOT NULL,
+  "reason_code" varchar(30),
+  "comment" varchar,
+  "previous_status" varchar(20) NOT NULL,
+  "cancelled_by" varchar NOT NULL,
+  "cancelled_at" timestamp NOT NULL,
+  "undo_until" timestamp NOT NULL,
+  "undone_at" timestamp
+);
+
+-- the deliveries a cancellation touched, with their status before, so it can be undone
+CREATE TABLE "cancelled_delivery" (
+  "cancellation_id" varchar NOT NULL,
+  "dish_delivery_id" varchar NOT NULL,
+  "previous_status" varchar(30) NOT NULL,
+  PRIMARY KEY ("cancellation_id", "dish_delivery_id")
+);
+
+CREATE INDEX ON "subscription_cancellation" ("subscription_id");
+
+ALTER TABLE "subscription_cancellation" ADD FOREIGN KEY ("subscription_id") REFERENCES "subscription" ("id");
+
+ALTER TABLE "cancelled_delivery" ADD FOREIGN KEY ("cancellation_id") REFERENCES "subscription_cancellation" ("id");
+
+ALTER TABLE "cancelled_delivery" ADD FOREIGN KEY ("dish_delivery_id") REFERENCES "dish_delivery" ("id") ON DELETE CASCADE;
+
+-- +goose Down
+-- SQL in this section is executed when the migration is rolled back.
+
+DROP TABLE IF EXISTS cancelled_delivery;
+DROP TABLE IF EXISTS subscription_cancellation;
@@ -0,0 +1,32 @@
+-- +goose Up
+-- frequencies are stored as RFC 5545 recurrence rules, anything unknown used to be delivered daily
+
+UPDATE "subscription" SET "frequency" = CASE lower("frequency")
+    WHEN 'weekly' THEN 'FREQ=WEEKLY'
+    WHEN 'monthly' THEN 'FREQ=MONTHLY'
+    ELSE 'FREQ=DAILY'
+  END
+  WHERE "frequency" NOT LIKE 'FREQ=%';
+
+UPDATE "subscription_dish" SET "frequency" = CASE lower("frequency")
+    WHEN 'weekly' THEN 'FREQ=WEEKLY'
+    WHEN 'monthly' THEN 'FREQ=MONTHLY'
+    ELSE 'FREQ=DAILY'
+  END
+  WHERE "frequency" NOT LIKE 'FREQ=%';
+
+-- +goose Down
+-- SQL in this section is executed when the migration is rolled back.
+-- rules without a plain equivalent fall back to daily
+
+UPDATE "subscription" SET "frequency" = CASE
+    WHEN "frequency" LIKE 'FREQ=WEEKLY%' THEN 'weekly'
+    WHEN "frequency" LIKE 'FREQ=MONTHLY%' THEN 'monthly'
+    ELSE 'daily'
+  END;
+
+UPDATE "subscription_dish" SET "frequency" = CASE
+    WHEN "frequency" LIKE 'FREQ=WEEKLY%' THEN 'weekly'
+    WHEN "frequency" LIKE 'FREQ=MONTHLY%' THEN 'monthly'
+    ELSE 'daily'
+  END;
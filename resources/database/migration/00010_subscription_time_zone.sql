@@ -0,0 +1,61 @@
+-- +goose Up
+-- every subscription gets the IANA time zone its deliveries are planned in, existing
+-- subscriptions are all delivered in Singapore
+ALTER TABLE "subscription" ADD COLUMN "time_zone" varchar NOT NULL DEFAULT 'Asia/Singapore';
+
+-- times were stored as the local wall clock they were sent with. Delivery times followed
+-- the subscription's zone, times written by the service itself are in UTC.
+ALTER TABLE "subscription_dish"
+  ALTER COLUMN "schedule_time" TYPE timestamptz USING "schedule_time" AT TIME ZONE 'Asia/Singapore',
+  ALTER COLUMN "removed_at" TYPE timestamptz USING "removed_at" AT TIME ZONE 'UTC';
+
+ALTER TABLE "dish_delivery"
+  ALTER COLUMN "expected_time" TYPE timestamptz USING "expected_time" AT TIME ZONE 'Asia/Singapore',
+  ALTER COLUMN "delivery_time" TYPE timestamptz USING "delivery_time" AT TIME ZONE 'Asia/Singapore';
+
+ALTER TABLE "subscription_status_transition"
+  ALTER COLUMN "triggered_at" TYPE timestamptz USING "triggered_at" AT TIME ZONE 'UTC';
+
+ALTER TABLE "subscription_pause"
+  ALTER COLUMN "pause_from" TYPE timestamptz USING "pause_from" AT TIME ZONE 'Asia/Singapore',
+  ALTER COLUMN "pause_until" TYPE timestamptz USING "pause_until" AT TIME ZONE 'Asia/Singapore',
+  ALTER COLUMN "resumed_at" TYPE timestamptz USING "resumed_at" AT TIME ZONE 'UTC';
+
+ALTER TABLE "subscription_receiver_history"
+  ALTER COLUMN "valid_until" TYPE timestamptz USING "valid_until" AT TIME ZONE 'Asia/Singapore',
+  ALTER COLUMN "changed_at" TYPE timestamptz USING "changed_at" AT TIME ZONE 'UTC';
+
+ALTER TABLE "subscription_cancellation"
+  ALTER COLUMN "cancelled_at" TYPE timestamptz USING "cancelled_at" AT TIME ZONE 'UTC',
+  ALTER COLUMN "undo_until" TYPE timestamptz USING "undo_until" AT TIME ZONE 'UTC',
+  ALTER COLUMN "undone_at" TYPE timestamptz USING "undone_at" AT TIME ZONE 'UTC';
+
+-- +goose Down
+-- SQL in this section is executed when the migration is rolled back.
+
+ALTER TABLE "subscription_cancellation"
+  ALTER COLUMN "cancelled_at" TYPE timestamp USING "cancelled_at" AT TIME ZONE 'UTC',
+  ALTER COLUMN "undo_until" TYPE timestamp USING "undo_until" AT TIME ZONE 'UTC',
+  ALTER COLUMN "undone_at" TYPE timestamp USING "undone_at" AT TIME ZONE 'UTC';
+
+ALTER TABLE "subscription_receiver_history"
+  ALTER COLUMN "valid_until" TYPE timestamp USING "valid_until" AT TIME ZONE 'Asia/Singapore',
+  ALTER COLUMN "changed_at" TYPE timestamp USING "changed_at" AT TIME ZONE 'UTC';
+
+ALTER TABLE "subscription_pause"
+  ALTER COLUMN "pause_from" TYPE timestamp USING "pause_from" AT TIME ZONE 'Asia/Singapore',
+  ALTER COLUMN "pause_until" TYPE timestamp USING "pause_until" AT TIME ZONE 'Asia/Singapore',
+  ALTER COLUMN "resumed_at" TYPE timestamp USING "resumed_at" AT TIME ZONE 'UTC';
+
+ALTER TABLE "subscription_status_transition"
+  ALTER COLUMN "triggered_at" TYPE timestamp USING "triggered_at" AT TIME ZONE 'UTC';
+
+ALTER TABLE "dish_delivery"
+  ALTER COLUMN "expected_time" TYPE timestamp USING "expected_time" AT TIME ZONE 'Asia/Singapore',
+  ALTER COLUMN "delivery_time" TYPE timestamp USING "delivery_time" AT TIME ZONE 'Asia/Singapore';
+
+ALTER TABLE "subscription_dish"
+  ALTER COLUMN "schedule_time" TYPE timestamp USING "schedule_time" AT TIME ZONE 'Asia/Singapore',
+  ALTER COLUMN "removed_at" TYPE timestamp USING "removed_at" AT TIME ZONE 'UTC';
+
+ALTER TABLE "subscription" DROP COLUMN IF EXISTS "time_zone";
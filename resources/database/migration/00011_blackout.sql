@@ -0,0 +1,25 @@
+-- +goose Up
+-- dates on which deliveries are skipped or moved, e.g. public holidays or a restaurant
+-- closure. Global blackouts have an empty scope_id.
+
+CREATE TABLE "blackout" (
+  "id" varchar PRIMARY KEY,
+  "scope" varchar(20) NOT NULL,
+  "scope_id" varchar NOT NULL DEFAULT '',
+  "start_date" date NOT NULL,
+  "end_date" date NOT NULL,
+  "action" varchar(20) NOT NULL,
+  "summary" varchar,
+  "source_uid" varchar,
+  "created_by" varchar NOT NULL,
+  "created_at" timestamptz NOT NULL
+);
+
+CREATE INDEX ON "blackout" ("scope", "scope_id", "start_date");
+
+CREATE UNIQUE INDEX ON "blackout" ("scope", "scope_id", "source_uid");
+
+-- +goose Down
+-- SQL in this section is executed when the migration is rolled back.
+
+DROP TABLE IF EXISTS blackout;
@@ -0,0 +1,38 @@
+-- +goose Up
+-- fixed delivery windows with the number of deliveries each one takes per postal zone
+-- and day. start_time and end_time are local times such as 11:00.
+
+CREATE TABLE "delivery_slot" (
+  "id" varchar PRIMARY KEY,
+  "start_time" varchar(5) NOT NULL,
+  "end_time" varchar(5) NOT NULL,
+  "capacity" int NOT NULL,
+  "active" boolean NOT NULL DEFAULT true
+);
+
+CREATE TABLE "slot_reservation" (
+  "slot_id" varchar NOT NULL,
+  "postal_zone" varchar NOT NULL,
+  "delivery_date" date NOT NULL,
+  "reserved" int NOT NULL,
+  PRIMARY KEY ("slot_id", "postal_zone", "delivery_date")
+);
+
+ALTER TABLE "slot_reservation" ADD FOREIGN KEY ("slot_id") REFERENCES "delivery_slot" ("id");
+
+ALTER TABLE "subscription" ADD COLUMN "postal_code" varchar NOT NULL DEFAULT '';
+
+ALTER TABLE "subscription_dish" ADD COLUMN "slot_id" varchar;
+
+ALTER TABLE "subscription_dish" ADD FOREIGN KEY ("slot_id") REFERENCES "delivery_slot" ("id");
+
+-- +goose Down
+-- SQL in this section is executed when the migration is rolled back.
+
+ALTER TABLE "subscription_dish" DROP COLUMN IF EXISTS "slot_id";
+
+ALTER TABLE "subscription" DROP COLUMN IF EXISTS "postal_code";
+
+DROP TABLE IF EXISTS slot_reservation;
+
+DROP TABLE IF EXISTS delivery_slot;
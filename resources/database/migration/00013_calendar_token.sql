@@ -0,0 +1,14 @@
+-- +goose Up
+-- one secret per user for the calendar feed of their deliveries. Calendar clients cannot
+-- send a bearer token, so the feed URL carries this one. Only its SHA-256 is kept.
+
+CREATE TABLE "calendar_token" (
+  "user_id" varchar PRIMARY KEY,
+  "token_hash" varchar NOT NULL,
+  "created_at" timestamptz NOT NULL
+);
+
+-- +goose Down
+-- SQL in this section is executed when the migration is rolled back.
+
+DROP TABLE IF EXISTS calendar_token;
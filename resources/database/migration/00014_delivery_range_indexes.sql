@@ -0,0 +1,19 @@
+-- +goose Up
+-- indexes for reading the deliveries of a user, a subscription or the dishes of a
+-- restaurant within a date range
+
+CREATE INDEX "subscription_user_id_idx" ON "subscription" ("user_id");
+
+CREATE INDEX "subscription_dish_subscription_id_idx" ON "subscription_dish" ("subscription_id");
+
+CREATE INDEX "subscription_dish_dish_id_idx" ON "subscription_dish" ("dish_id");
+
+CREATE INDEX "dish_delivery_subscription_dish_id_expected_time_idx" ON "dish_delivery" ("subscription_dish_id", "expected_time");
+
+-- +goose Down
+-- SQL in this section is executed when the migration is rolled back.
+
+DROP INDEX IF EXISTS subscription_user_id_idx;
+DROP INDEX IF EXISTS subscription_dish_subscription_id_idx;
+DROP INDEX IF EXISTS subscription_dish_dish_id_idx;
+DROP INDEX IF EXISTS dish_delivery_subscription_dish_id_expected_time_idx;
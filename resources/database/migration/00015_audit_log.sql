@@ -0,0 +1,35 @@
+-- +goose Up
+-- every change of a subscription, its dishes and deliveries, written in the transaction of
+-- the change. The log is only ever appended to, rows are neither updated nor deleted.
+
+CREATE TABLE "audit_log" (
+  "id" varchar PRIMARY KEY,
+  "subscription_id" varchar NOT NULL,
+  "entity" varchar(20) NOT NULL,
+  "entity_id" varchar NOT NULL,
+  "action" varchar(40) NOT NULL,
+  "actor" varchar NOT NULL,
+  "old_value" jsonb,
+  "new_value" jsonb,
+  "request_id" varchar,
+  "created_at" timestamptz NOT NULL
+);
+
+CREATE INDEX ON "audit_log" ("subscription_id", "created_at");
+
+-- +goose StatementBegin
+CREATE FUNCTION audit_log_append_only() RETURNS trigger AS $$
+BEGIN
+  RAISE EXCEPTION 'audit_log is append only';
+END;
+$$ LANGUAGE plpgsql;
+-- +goose StatementEnd
+
+CREATE TRIGGER "audit_log_append_only" BEFORE UPDATE OR DELETE ON "audit_log"
+  FOR EACH ROW EXECUTE FUNCTION audit_log_append_only();
+
+-- +goose Down
+-- SQL in this section is executed when the migration is rolled back.
+
+DROP TABLE IF EXISTS audit_log;
+DROP FUNCTION IF EXISTS audit_log_append_only;
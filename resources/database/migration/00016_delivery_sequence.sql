@@ -0,0 +1,27 @@
+-- +goose Up
+-- the revision of a delivery, the calendar feed sends it as the SEQUENCE of the delivery's
+-- event so calendar clients replace the event after a reschedule or cancellation
+
+ALTER TABLE "dish_delivery" ADD COLUMN "sequence" integer NOT NULL DEFAULT 0;
+
+-- +goose StatementBegin
+CREATE FUNCTION dish_delivery_sequence() RETURNS trigger AS $$
+BEGIN
+  IF NEW.expected_time IS DISTINCT FROM OLD.expected_time OR NEW.status IS DISTINCT FROM OLD.status
+    OR NEW.note IS DISTINCT FROM OLD.note THEN
+    NEW.sequence := OLD.sequence + 1;
+  END IF;
+  RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+-- +goose StatementEnd
+
+CREATE TRIGGER "dish_delivery_sequence" BEFORE UPDATE ON "dish_delivery"
+  FOR EACH ROW EXECUTE FUNCTION dish_delivery_sequence();
+
+-- +goose Down
+-- SQL in this section is executed when the migration is rolled back.
+
+DROP TRIGGER IF EXISTS dish_delivery_sequence ON dish_delivery;
+DROP FUNCTION IF EXISTS dish_delivery_sequence;
+ALTER TABLE "dish_delivery" DROP COLUMN IF EXISTS "sequence";
@@ -0,0 +1,20 @@
+// Package database holds the migrations of the subscription database.
+package database
+
+import (
+	"embed"
+	"io/fs"
+)
+
+//go:embed sqlite_migration/*.sql
+var sqliteMigrations embed.FS
+
+// SQLiteMigrations returns the goose migrations of the SQLite database. The service applies
+// them itself when it starts on SQLite, so no goose binary is needed on a laptop.
+func SQLiteMigrations() fs.FS {
+	migrations, err := fs.Sub(sqliteMigrations, "sqlite_migration")
+	if err != nil {
+		panic(err)
+	}
+	return migrations
+}
@@ -0,0 +1,150 @@
+-- +goose Up
+-- the SQLite schema starts from the state of the Postgres migrations 00001 to 00014.
+-- Times are stored as text in UTC with a fixed width, so they sort as they compare, and
+-- timestamptz columns are declared timestamp for the driver to read them back as times.
+CREATE TABLE "subscription" (
+  "id" varchar PRIMARY KEY,
+  "user_id" varchar NOT NULL,
+  "playlist_id" varchar NOT NULL,
+  "customized" boolean NOT NULL,
+  "status" varchar(20) NOT NULL,
+  "frequency" varchar NOT NULL,
+  "start_date" date NOT NULL,
+  "end_date" date,
+  "receiver_name" varchar NOT NULL,
+  "receiver_contact" varchar NOT NULL,
+  "auto_renew" boolean NOT NULL DEFAULT false,
+  "term_days" int,
+  "delivery_instructions" varchar,
+  "time_zone" varchar NOT NULL DEFAULT 'Asia/Singapore',
+  "postal_code" varchar NOT NULL DEFAULT ''
+);
+
+CREATE TABLE "delivery_slot" (
+  "id" varchar PRIMARY KEY,
+  "start_time" varchar(5) NOT NULL,
+  "end_time" varchar(5) NOT NULL,
+  "capacity" int NOT NULL,
+  "active" boolean NOT NULL DEFAULT true
+);
+
+CREATE TABLE "subscription_dish" (
+  "id" varchar PRIMARY KEY,
+  "dish_id" varchar NOT NULL,
+  "subscription_id" varchar NOT NULL REFERENCES "subscription" ("id"),
+  "schedule_time" timestamp NOT NULL,
+  "frequency" varchar NOT NULL,
+  "dish_options" varchar NOT NULL,
+  "note" varchar,
+  "removed_at" timestamp,
+  "slot_id" varchar REFERENCES "delivery_slot" ("id")
+);
+
+CREATE TABLE "dish_delivery" (
+  "id" varchar PRIMARY KEY,
+  "subscription_dish_id" varchar NOT NULL REFERENCES "subscription_dish" ("id"),
+  "status" varchar(30) NOT NULL,
+  "expected_time" timestamp NOT NULL,
+  "delivery_time" timestamp,
+  "note" varchar(100)
+);
+
+CREATE TABLE "subscription_status_transition" (
+  "id" varchar PRIMARY KEY,
+  "subscription_id" varchar NOT NULL REFERENCES "subscription" ("id"),
+  "from_status" varchar(20) NOT NULL,
+  "to_status" varchar(20) NOT NULL,
+  "triggered_by" varchar NOT NULL,
+  "triggered_at" timestamp NOT NULL
+);
+
+CREATE TABLE "subscription_pause" (
+  "id" varchar PRIMARY KEY,
+  "subscription_id" varchar NOT NULL REFERENCES "subscription" ("id"),
+  "pause_from" timestamp NOT NULL,
+  "pause_until" timestamp,
+  "resumed_at" timestamp,
+  "resume_policy" varchar(20)
+);
+
+CREATE TABLE "subscription_receiver_history" (
+  "id" varchar PRIMARY KEY,
+  "subscription_id" varchar NOT NULL REFERENCES "subscription" ("id"),
+  "receiver_name" varchar NOT NULL,
+  "receiver_contact" varchar NOT NULL,
+  "delivery_instructions" varchar,
+  "valid_until" timestamp NOT NULL,
+  "changed_by" varchar NOT NULL,
+  "changed_at" timestamp NOT NULL
+);
+
+CREATE TABLE "subscription_cancellation" (
+  "id" varchar PRIMARY KEY,
+  "subscription_id" varchar NOT NULL REFERENCES "subscription" ("id"),
+  "reason_code" varchar(30),
+  "comment" varchar,
+  "previous_status" varchar(20) NOT NULL,
+  "cancelled_by" varchar NOT NULL,
+  "cancelled_at" timestamp NOT NULL,
+  "undo_until" timestamp NOT NULL,
+  "undone_at" timestamp
+);
+
+CREATE TABLE "cancelled_delivery" (
+  "cancellation_id" varchar NOT NULL REFERENCES "subscription_cancellation" ("id"),
+  "dish_delivery_id" varchar NOT NULL REFERENCES "dish_delivery" ("id") ON DELETE CASCADE,
+  "previous_status" varchar(30) NOT NULL,
+  PRIMARY KEY ("cancellation_id", "dish_delivery_id")
+);
+
+CREATE TABLE "blackout" (
+  "id" varchar PRIMARY KEY,
+  "scope" varchar(20) NOT NULL,
+  "scope_id" varchar NOT NULL DEFAULT '',
+  "start_date" date NOT NULL,
+  "end_date" date NOT NULL,
+  "action" varchar(20) NOT NULL,
+  "summary" varchar,
+  "source_uid" varchar,
+  "created_by" varchar NOT NULL,
+  "created_at" timestamp NOT NULL
+);
+
+CREATE TABLE "slot_reservation" (
+  "slot_id" varchar NOT NULL REFERENCES "delivery_slot" ("id"),
+  "postal_zone" varchar NOT NULL,
+  "delivery_date" date NOT NULL,
+  "reserved" int NOT NULL,
+  PRIMARY KEY ("slot_id", "postal_zone", "delivery_date")
+);
+
+CREATE TABLE "calendar_token" (
+  "user_id" varchar PRIMARY KEY,
+  "token_hash" varchar NOT NULL,
+  "created_at" timestamp NOT NULL
+);
+
+CREATE INDEX "subscription_status_transition_subscription_id_idx" ON "subscription_status_transition" ("subscription_id");
+CREATE INDEX "subscription_pause_subscription_id_idx" ON "subscription_pause" ("subscription_id");
+CREATE INDEX "subscription_receiver_history_subscription_id_idx" ON "subscription_receiver_history" ("subscription_id");
+CREATE INDEX "subscription_cancellation_subscription_id_idx" ON "subscription_cancellation" ("subscription_id");
+CREATE INDEX "blackout_scope_scope_id_start_date_idx" ON "blackout" ("scope", "scope_id", "start_date");
+CREATE UNIQUE INDEX "blackout_scope_scope_id_source_uid_idx" ON "blackout" ("scope", "scope_id", "source_uid");
+CREATE INDEX "subscription_user_id_idx" ON "subscription" ("user_id");
+CREATE INDEX "subscription_dish_subscription_id_idx" ON "subscription_dish" ("subscription_id");
+CREATE INDEX "subscription_dish_dish_id_idx" ON "subscription_dish" ("dish_id");
+CREATE INDEX "dish_delivery_subscription_dish_id_expected_time_idx" ON "dish_delivery" ("subscription_dish_id", "expected_time");
+
+-- +goose Down
+DROP TABLE IF EXISTS "calendar_token";
+DROP TABLE IF EXISTS "slot_reservation";
+DROP TABLE IF EXISTS "blackout";
+DROP TABLE IF EXISTS "cancelled_delivery";
+DROP TABLE IF EXISTS "subscription_cancellation";
+DROP TABLE IF EXISTS "subscription_receiver_history";
+DROP TABLE IF EXISTS "subscription_pause";
+DROP TABLE IF EXISTS "subscription_status_transition";
+DROP TABLE IF EXISTS "dish_delivery";
+DROP TABLE IF EXISTS "subscription_dish";
+DROP TABLE IF EXISTS "delivery_slot";
+DROP TABLE IF EXISTS "subscription";
@@ -0,0 +1,29 @@
+-- +goose Up
+-- audit_log of the Postgres migration 00015, with triggers in place of its function
+CREATE TABLE "audit_log" (
+  "id" varchar PRIMARY KEY,
+  "subscription_id" varchar NOT NULL,
+  "entity" varchar(20) NOT NULL,
+  "entity_id" varchar NOT NULL,
+  "action" varchar(40) NOT NULL,
+  "actor" varchar NOT NULL,
+  "old_value" text,
+  "new_value" text,
+  "request_id" varchar,
+  "created_at" timestamp NOT NULL
+);
+
+CREATE INDEX "audit_log_subscription_id_created_at_idx" ON "audit_log" ("subscription_id", "created_at");
+
+CREATE TRIGGER "audit_log_no_update" BEFORE UPDATE ON "audit_log"
+BEGIN
+  SELECT RAISE(ABORT, 'audit_log is append only');
+END;
+
+CREATE TRIGGER "audit_log_no_delete" BEFORE DELETE ON "audit_log"
+BEGIN
+  SELECT RAISE(ABORT, 'audit_log is append only');
+END;
+
+-- +goose Down
+DROP TABLE IF EXISTS "audit_log";
@@ -0,0 +1,13 @@
+-- +goose Up
+-- sequence of the Postgres migration 00016, counted by a trigger after the update
+ALTER TABLE "dish_delivery" ADD COLUMN "sequence" integer NOT NULL DEFAULT 0;
+
+CREATE TRIGGER "dish_delivery_sequence" AFTER UPDATE OF "expected_time", "status", "note" ON "dish_delivery"
+WHEN NEW."expected_time" IS NOT OLD."expected_time" OR NEW."status" IS NOT OLD."status" OR NEW."note" IS NOT OLD."note"
+BEGIN
+  UPDATE "dish_delivery" SET "sequence" = OLD."sequence" + 1 WHERE "id" = NEW."id";
+END;
+
+-- +goose Down
+DROP TRIGGER IF EXISTS "dish_delivery_sequence";
+ALTER TABLE "dish_delivery" DROP COLUMN "sequence";